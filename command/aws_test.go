@@ -0,0 +1,192 @@
+package command
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const testAWSAccessKeyID = "AKIDTEST"
+
+// fakeAWS is a local endpoint that stands in for AWS services. Requests
+// signed with another access key id are rejected.
+type fakeAWS struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests []string
+}
+
+// serveFakeAWS serves STS GetCallerIdentity itself and passes other requests
+// to handler.
+func serveFakeAWS(t *testing.T, handler http.HandlerFunc) *fakeAWS {
+	t.Helper()
+	f := &fakeAWS{}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		name := r.Method + " " + r.URL.Path
+		if action := r.Form.Get("Action"); action != "" {
+			name = action
+		}
+		f.mu.Lock()
+		f.requests = append(f.requests, name)
+		f.mu.Unlock()
+
+		if !strings.Contains(r.Header.Get("Authorization"), "Credential="+testAWSAccessKeyID+"/") {
+			w.WriteHeader(http.StatusForbidden)
+			if r.Form.Get("Action") != "" {
+				fmt.Fprint(w, `<ErrorResponse><Error><Type>Sender</Type><Code>InvalidClientTokenId</Code><Message>The security token included in the request is invalid.</Message></Error><RequestId>1</RequestId></ErrorResponse>`)
+			} else {
+				fmt.Fprint(w, `<Error><Code>InvalidAccessKeyId</Code><Message>The AWS Access Key Id you provided does not exist in our records.</Message></Error>`)
+			}
+			return
+		}
+		if r.Form.Get("Action") == "GetCallerIdentity" {
+			fmt.Fprint(w, `<GetCallerIdentityResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/"><GetCallerIdentityResult><Arn>arn:aws:iam::123456789012:user/test</Arn><UserId>AIDATEST</UserId><Account>123456789012</Account></GetCallerIdentityResult><ResponseMetadata><RequestId>1</RequestId></ResponseMetadata></GetCallerIdentityResponse>`)
+			return
+		}
+		handler(w, r)
+	}))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeAWS) reset() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests = nil
+}
+
+func (f *fakeAWS) calls() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string{}, f.requests...)
+}
+
+func TestAWSAuth(t *testing.T) {
+	f := serveFakeAWS(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/":
+			fmt.Fprint(w, `<ListAllMyBucketsResult><Owner><ID>1</ID></Owner><Buckets></Buckets></ListAllMyBucketsResult>`)
+		case r.Form.Get("Action") == "ListTopics":
+			fmt.Fprint(w, `<ListTopicsResponse><ListTopicsResult><Topics></Topics></ListTopicsResult><ResponseMetadata><RequestId>1</RequestId></ResponseMetadata></ListTopicsResponse>`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+
+	tests := []struct {
+		args    []string
+		results []string
+		calls   []string
+	}{
+		{
+			args:    []string{testAWSAccessKeyID, "secret", "s3", "--", "endpoint=" + f.URL},
+			results: []string{"true"},
+			calls:   []string{"GET /"},
+		},
+		{
+			args:    []string{testAWSAccessKeyID, "secret", "sns", "--", "endpoint=" + f.URL},
+			results: []string{"true"},
+			calls:   []string{"ListTopics"},
+		},
+		{
+			args:    []string{testAWSAccessKeyID, "secret", "sts", "--", "endpoint=" + f.URL},
+			results: []string{"true", "123456789012", "arn:aws:iam::123456789012:user/test"},
+			calls:   []string{"GetCallerIdentity"},
+		},
+		{
+			args:    []string{testAWSAccessKeyID, "secret", "sts", "--", "endpoint=" + f.URL, "identity=false"},
+			results: []string{"true"},
+			calls:   []string{"GetCallerIdentity"},
+		},
+		{
+			args:    []string{testAWSAccessKeyID, "secret", "s3", "--", "endpoint=" + f.URL, "sts_endpoint=" + f.URL, "identity=true"},
+			results: []string{"true", "123456789012", "arn:aws:iam::123456789012:user/test"},
+			calls:   []string{"GET /", "GetCallerIdentity"},
+		},
+	}
+	for _, test := range tests {
+		f.reset()
+		results, err := awsAuthCommand(newTestCmd(t), test.args...)
+		if err != nil {
+			t.Errorf("aws_auth(%q): %v", test.args[2:], err)
+			continue
+		}
+		if !reflect.DeepEqual(results, test.results) {
+			t.Errorf("aws_auth(%q) = %q, want %q", test.args[2:], results, test.results)
+		}
+		if calls := f.calls(); !reflect.DeepEqual(calls, test.calls) {
+			t.Errorf("aws_auth(%q) made calls %q, want %q", test.args[2:], calls, test.calls)
+		}
+	}
+}
+
+func TestAWSAuthFailure(t *testing.T) {
+	f := serveFakeAWS(t, nil)
+	for _, service := range []string{"s3", "sts"} {
+		results, err := awsAuthCommand(newTestCmd(t), "AKIDWRONG", "secret", service, "--", "endpoint="+f.URL)
+		if _, ok := err.(ErrCommandResponse); !ok {
+			t.Errorf("%s: got %v, want ErrCommandResponse", service, err)
+		} else if !strings.Contains(err.Error(), "AWS authentication failed") {
+			t.Errorf("%s: error = %v", service, err)
+		}
+		if !reflect.DeepEqual(results, []string{"false"}) {
+			t.Errorf("%s: results = %q", service, results)
+		}
+	}
+}
+
+func TestAWSAuthIdentityLookupFailure(t *testing.T) {
+	f := serveFakeAWS(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<ListAllMyBucketsResult><Owner><ID>1</ID></Owner><Buckets></Buckets></ListAllMyBucketsResult>`)
+	})
+	sts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, `<ErrorResponse><Error><Type>Sender</Type><Code>AccessDenied</Code><Message>STS is disabled by policy.</Message></Error><RequestId>1</RequestId></ErrorResponse>`)
+	}))
+	defer sts.Close()
+
+	results, err := awsAuthCommand(newTestCmd(t), testAWSAccessKeyID, "secret", "s3", "--",
+		"endpoint="+f.URL, "sts_endpoint="+sts.URL, "identity=true")
+	if _, ok := err.(ErrCommandResponse); !ok {
+		t.Fatalf("got %v, want ErrCommandResponse", err)
+	}
+	if !strings.HasPrefix(err.Error(), "AWS identity lookup failed") || !strings.Contains(err.Error(), "AccessDenied") {
+		t.Errorf("error = %v", err)
+	}
+	if !reflect.DeepEqual(results, []string{"true"}) {
+		t.Errorf("results = %q", results)
+	}
+}
+
+func TestAWSAuthErrors(t *testing.T) {
+	for _, args := range [][]string{
+		{testAWSAccessKeyID, "secret"},
+		{testAWSAccessKeyID, "secret", "sqs"},
+		{testAWSAccessKeyID, "secret", "iam"},
+		{testAWSAccessKeyID, "secret", "sts", "--", "identity=maybe"},
+	} {
+		if _, err := awsAuthCommand(newTestCmd(t), args...); err == nil {
+			t.Errorf("aws_auth(%q) succeeded", args)
+		}
+	}
+	if _, err := awsAuthCommand(newTestCmd(t), testAWSAccessKeyID, "secret", "sqs"); err == nil || !strings.Contains(err.Error(), "not supported") {
+		t.Errorf("sqs: %v", err)
+	}
+}
+
+func TestSTSRegionalEndpoint(t *testing.T) {
+	for region, want := range map[string]string{
+		"us-east-1":     "https://sts.us-east-1.amazonaws.com",
+		"us-gov-west-1": "https://sts.us-gov-west-1.amazonaws.com",
+		"cn-north-1":    "https://sts.cn-north-1.amazonaws.com.cn",
+	} {
+		if got := stsRegionalEndpoint(region); got != want {
+			t.Errorf("stsRegionalEndpoint(%q) = %q, want %q", region, got, want)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package command
+
+import (
+	"bytes"
+	"errors"
+	"io"
+)
+
+// The subset of BER needed for LDAP messages. Only low tag numbers and
+// definite lengths are supported, which is all LDAP uses.
+
+const (
+	berTagBoolean     = 0x01
+	berTagInteger     = 0x02
+	berTagOctetString = 0x04
+	berTagEnumerated  = 0x0a
+	berTagSequence    = 0x30
+	berTagSet         = 0x31
+
+	maxBERLength = 16 << 20
+)
+
+var errMalformedBER = errors.New("Malformed BER packet")
+
+type berPacket struct {
+	Tag   byte
+	Value []byte
+}
+
+func berEncode(tag byte, value []byte) []byte {
+	n := len(value)
+	if n < 0x80 {
+		return append([]byte{tag, byte(n)}, value...)
+	}
+	var length []byte
+	for ; n > 0; n >>= 8 {
+		length = append([]byte{byte(n)}, length...)
+	}
+	header := append([]byte{tag, 0x80 | byte(len(length))}, length...)
+	return append(header, value...)
+}
+
+func berConstructed(tag byte, children ...[]byte) []byte {
+	return berEncode(tag, bytes.Join(children, nil))
+}
+
+func berString(tag byte, s string) []byte {
+	return berEncode(tag, []byte(s))
+}
+
+// berInt encodes n in the fewest two's complement bytes.
+func berInt(tag byte, n int64) []byte {
+	var value []byte
+	for {
+		value = append([]byte{byte(n)}, value...)
+		if n >= -0x80 && n < 0x80 {
+			break
+		}
+		n >>= 8
+	}
+	return berEncode(tag, value)
+}
+
+func berBool(tag byte, b bool) []byte {
+	if b {
+		return berEncode(tag, []byte{0xff})
+	}
+	return berEncode(tag, []byte{0})
+}
+
+func readBERPacket(r io.Reader) (*berPacket, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	length := int(header[1])
+	if length&0x80 != 0 {
+		n := length & 0x7f
+		if n == 0 || n > 4 {
+			return nil, errMalformedBER
+		}
+		buf := make([]byte, n)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		length = 0
+		for _, b := range buf {
+			length = length<<8 | int(b)
+		}
+	}
+	if length > maxBERLength {
+		return nil, errMalformedBER
+	}
+	value := make([]byte, length)
+	if _, err := io.ReadFull(r, value); err != nil {
+		return nil, err
+	}
+	return &berPacket{Tag: header[0], Value: value}, nil
+}
+
+// children parses the value of a constructed packet.
+func (p *berPacket) children() ([]*berPacket, error) {
+	r := bytes.NewReader(p.Value)
+	children := []*berPacket{}
+	for r.Len() > 0 {
+		child, err := readBERPacket(r)
+		if err != nil {
+			return nil, errMalformedBER
+		}
+		children = append(children, child)
+	}
+	return children, nil
+}
+
+func (p *berPacket) int() int64 {
+	var n int64
+	for i, b := range p.Value {
+		if i == 0 && b&0x80 != 0 {
+			n = -1
+		}
+		n = n<<8 | int64(b)
+	}
+	return n
+}
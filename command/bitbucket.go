@@ -0,0 +1,115 @@
+package command
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const defaultBitbucketURL = "https://api.bitbucket.org"
+
+// bitbucketServerPermissions are checked in order against the repositories
+// and projects the credentials can see.
+var bitbucketServerPermissions = []struct {
+	Permission string
+	Resource   string
+}{
+	{"REPO_READ", "repos"},
+	{"REPO_WRITE", "repos"},
+	{"REPO_ADMIN", "repos"},
+	{"PROJECT_ADMIN", "projects"},
+}
+
+func bitbucketAuthCommand(c *GoCmd, args ...string) ([]string, error) {
+	// Options:
+	// url: Bitbucket Server url, defaults to Bitbucket Cloud
+	// user, password: username and password or app password
+	// token: HTTP access token, used instead of user and password
+	// scopes: comma separated scopes the credentials must have (Bitbucket Cloud)
+	// permissions: comma separated permissions the credentials must have, of
+	//   REPO_READ, REPO_WRITE, REPO_ADMIN and PROJECT_ADMIN (Bitbucket Server)
+	// ca, insecure, timeout: as for http_check
+	_, opts := parseArgs(args)
+	header := http.Header{}
+	if token := opts.get("token", ""); token != "" {
+		header.Set("Authorization", "Bearer "+token)
+	} else if user := opts.get("user", ""); user != "" {
+		basic := base64.StdEncoding.EncodeToString([]byte(user + ":" + opts.get("password", "")))
+		header.Set("Authorization", "Basic "+basic)
+	} else {
+		return nil, ErrMissingArgs
+	}
+	base, err := apiBaseURL(opts.get("url", defaultBitbucketURL))
+	if err != nil {
+		return nil, err
+	}
+	client, err := c.httpClientFromOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var result scmAuthResult
+	switch strings.ToLower(base.Hostname()) {
+	case "bitbucket.org", "api.bitbucket.org":
+		result = scmAuthResult{
+			Provider: "Bitbucket Cloud",
+			APIURL:   defaultBitbucketURL + "/2.0",
+		}
+		err = bitbucketCloudCheck(client, header, opts.getList("scopes"), &result)
+	default:
+		result = scmAuthResult{
+			Provider: "Bitbucket Server",
+			APIURL:   base.String() + "/rest/api/1.0",
+		}
+		err = bitbucketServerCheck(client, header, opts.getList("permissions"), &result)
+	}
+	return scmAuthResponse(&result, err)
+}
+
+func bitbucketCloudCheck(client *http.Client, header http.Header, required []string, result *scmAuthResult) error {
+	var user struct {
+		Username    string `json:"username"`
+		DisplayName string `json:"display_name"`
+	}
+	resp, err := apiRequest(client, "GET", result.APIURL+"/user", header, nil, &user)
+	if err != nil {
+		return err
+	}
+	result.Valid = true
+	result.Login = user.Username
+	result.Name = user.DisplayName
+	if scopes := resp.Header.Get("X-OAuth-Scopes"); scopes != "" {
+		result.Scopes = splitList(scopes)
+	}
+	return requireValues(result, result.Scopes, required, "scopes")
+}
+
+// bitbucketServerCheck authenticates against Bitbucket Server, which reports
+// the authenticated user in the X-AUSERNAME header and otherwise treats the
+// request as anonymous. Permissions are those held on at least one repository
+// or project.
+func bitbucketServerCheck(client *http.Client, header http.Header, required []string, result *scmAuthResult) error {
+	result.Permissions = []string{}
+	for _, p := range bitbucketServerPermissions {
+		var page struct {
+			Size int `json:"size"`
+		}
+		pageURL := fmt.Sprintf("%s/%s?permission=%s&limit=1", result.APIURL, p.Resource, p.Permission)
+		resp, err := apiRequest(client, "GET", pageURL, header, nil, &page)
+		if err != nil {
+			return err
+		}
+		if result.Login == "" {
+			result.Login = resp.Header.Get("X-AUSERNAME")
+			if result.Login == "" {
+				return apiError{Message: "request was not authenticated"}
+			}
+			result.Valid = true
+		}
+		if page.Size > 0 {
+			result.Permissions = append(result.Permissions, p.Permission)
+		}
+	}
+	return requireValues(result, result.Permissions, required, "permissions")
+}
@@ -0,0 +1,174 @@
+package command
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// fakeBitbucketServer serves the repository and project listings of
+// Bitbucket Server under /rest/api/1.0. User "admin" with password "pass",
+// or the HTTP access token "good", can read and write repositories but
+// administer neither repositories nor projects. Anything else is anonymous.
+func fakeBitbucketServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	granted := map[string]bool{"REPO_READ": true, "REPO_WRITE": true}
+	list := func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); ok && user == "admin" && pass == "pass" {
+			w.Header().Set("X-AUSERNAME", "admin")
+		} else if r.Header.Get("Authorization") == "Bearer good" {
+			w.Header().Set("X-AUSERNAME", "admin")
+		}
+		size := 0
+		if w.Header().Get("X-AUSERNAME") != "" && granted[r.URL.Query().Get("permission")] {
+			size = 1
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"size": size, "values": []interface{}{}})
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/rest/api/1.0/repos", list)
+	mux.HandleFunc("/rest/api/1.0/projects", list)
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// fakeBitbucketCloud serves /2.0/user as Bitbucket Cloud does, accepting
+// user "jdoe" with app password "app-pass", which reports its scopes in
+// X-OAuth-Scopes, and the access token "good", which reports none.
+func fakeBitbucketCloud() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/2.0/user", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if user, pass, ok := r.BasicAuth(); ok && user == "jdoe" && pass == "app-pass" {
+			w.Header().Set("X-OAuth-Scopes", "repository, account, pullrequest")
+		} else if r.Header.Get("Authorization") != "Bearer good" {
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"type":  "error",
+				"error": map[string]string{"message": "Access token expired."},
+			})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"username": "jdoe", "display_name": "Jane Doe"})
+	})
+	return mux
+}
+
+func runBitbucketAuth(t *testing.T, c *GoCmd, args ...string) (scmAuthResult, error) {
+	t.Helper()
+	results, err := bitbucketAuthCommand(c, args...)
+	var result scmAuthResult
+	if len(results) > 0 {
+		if err := json.Unmarshal([]byte(results[0]), &result); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return result, err
+}
+
+func TestBitbucketAuthServer(t *testing.T) {
+	server := fakeBitbucketServer(t)
+
+	result, err := runBitbucketAuth(t, newTestCmd(t), "--", "url="+server.URL, "user=admin", "password=pass", "permissions=REPO_WRITE")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Valid || result.Provider != "Bitbucket Server" || result.APIURL != server.URL+"/rest/api/1.0" ||
+		result.Login != "admin" || strings.Join(result.Permissions, ",") != "REPO_READ,REPO_WRITE" {
+		t.Errorf("result = %+v", result)
+	}
+
+	result, err = runBitbucketAuth(t, newTestCmd(t), "--", "url="+server.URL, "token=good", "permissions=REPO_READ,REPO_ADMIN,PROJECT_ADMIN")
+	if _, ok := err.(ErrCommandResponse); !ok {
+		t.Fatalf("got %v, want ErrCommandResponse", err)
+	}
+	if result.Valid || result.Login != "admin" || strings.Join(result.Missing, ",") != "REPO_ADMIN,PROJECT_ADMIN" {
+		t.Errorf("result = %+v", result)
+	}
+
+	// Bitbucket Server answers bad credentials as an anonymous user.
+	result, err = runBitbucketAuth(t, newTestCmd(t), "--", "url="+server.URL, "user=admin", "password=wrong")
+	if _, ok := err.(ErrCommandResponse); !ok {
+		t.Fatalf("got %v, want ErrCommandResponse", err)
+	}
+	if result.Valid || result.Error != "request was not authenticated" {
+		t.Errorf("result = %+v", result)
+	}
+}
+
+func TestBitbucketAuthServerContextPath(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.Handle("/bitbucket/", http.StripPrefix("/bitbucket", fakeBitbucketServer(t).Config.Handler))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	result, err := runBitbucketAuth(t, newTestCmd(t), "--", "url="+server.URL+"/bitbucket/", "token=good")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Valid || result.APIURL != server.URL+"/bitbucket/rest/api/1.0" {
+		t.Errorf("result = %+v", result)
+	}
+}
+
+func TestBitbucketAuthCloud(t *testing.T) {
+	pki := newTestPKI(t)
+	proxy := serveInterceptingProxy(t, pki, fakeBitbucketCloud(), "api.bitbucket.org")
+	c := newProxiedCmd(t, CmdConfig{ProxyURL: proxy.URL})
+
+	result, err := runBitbucketAuth(t, c, "--", "user=jdoe", "password=app-pass", "scopes=repository,account", "ca="+pki.caPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Valid || result.Provider != "Bitbucket Cloud" || result.APIURL != "https://api.bitbucket.org/2.0" ||
+		result.Login != "jdoe" || result.Name != "Jane Doe" || strings.Join(result.Scopes, ",") != "repository,account,pullrequest" {
+		t.Errorf("result = %+v", result)
+	}
+
+	// bitbucket.org is also Cloud and is checked through api.bitbucket.org.
+	result, err = runBitbucketAuth(t, c, "--", "url=https://bitbucket.org", "user=jdoe", "password=app-pass",
+		"scopes=repository,webhook,pipeline", "ca="+pki.caPEM)
+	if _, ok := err.(ErrCommandResponse); !ok {
+		t.Fatalf("got %v, want ErrCommandResponse", err)
+	}
+	if result.Valid || result.APIURL != "https://api.bitbucket.org/2.0" || strings.Join(result.Missing, ",") != "webhook,pipeline" {
+		t.Errorf("result = %+v", result)
+	}
+
+	// Without X-OAuth-Scopes no scopes are known, so any required scope is
+	// missing.
+	result, err = runBitbucketAuth(t, c, "--", "token=good", "scopes=repository", "ca="+pki.caPEM)
+	if _, ok := err.(ErrCommandResponse); !ok {
+		t.Fatalf("got %v, want ErrCommandResponse", err)
+	}
+	if result.Valid || len(result.Scopes) != 0 || strings.Join(result.Missing, ",") != "repository" {
+		t.Errorf("result = %+v", result)
+	}
+
+	result, err = runBitbucketAuth(t, c, "--", "token=expired", "ca="+pki.caPEM)
+	if _, ok := err.(ErrCommandResponse); !ok {
+		t.Fatalf("got %v, want ErrCommandResponse", err)
+	}
+	if result.Valid || result.Error != "HTTP status code 401: Access token expired." {
+		t.Errorf("result = %+v", result)
+	}
+
+	if len(proxy.connected()) == 0 {
+		t.Error("no tunnel was opened through the proxy")
+	}
+	for _, target := range proxy.connected() {
+		if target != "api.bitbucket.org:443" {
+			t.Errorf("connected to %s, want api.bitbucket.org:443", target)
+		}
+	}
+}
+
+func TestBitbucketAuthErrors(t *testing.T) {
+	if _, err := bitbucketAuthCommand(newTestCmd(t), "--", "url=https://bitbucket.example.com"); err != ErrMissingArgs {
+		t.Errorf("got %v, want ErrMissingArgs", err)
+	}
+}
@@ -0,0 +1,330 @@
+package command
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const defaultCADays = 3650
+
+var (
+	keyUsageNames = map[string]x509.KeyUsage{
+		"digital_signature":  x509.KeyUsageDigitalSignature,
+		"content_commitment": x509.KeyUsageContentCommitment,
+		"key_encipherment":   x509.KeyUsageKeyEncipherment,
+		"data_encipherment":  x509.KeyUsageDataEncipherment,
+		"key_agreement":      x509.KeyUsageKeyAgreement,
+		"cert_sign":          x509.KeyUsageCertSign,
+		"crl_sign":           x509.KeyUsageCRLSign,
+	}
+
+	extKeyUsageNames = map[string]x509.ExtKeyUsage{
+		"server_auth":      x509.ExtKeyUsageServerAuth,
+		"client_auth":      x509.ExtKeyUsageClientAuth,
+		"code_signing":     x509.ExtKeyUsageCodeSigning,
+		"email_protection": x509.ExtKeyUsageEmailProtection,
+	}
+)
+
+func caCreateCommand(c *GoCmd, args ...string) ([]string, error) {
+	// Options:
+	// key, bits, cn, o, ou, c, st, l, days, format: as for cert
+	// path_len: maximum number of intermediate CAs
+	_, opts := parseArgs(args)
+	opts.setDefault("cn", "Replicated CA")
+	opts.setDefault("days", fmt.Sprintf("%d", defaultCADays))
+
+	key, err := generateKeyFromOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	template, err := certTemplateFromOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	template.IsCA = true
+	template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature
+	template.ExtKeyUsage = nil
+	template.DNSNames = nil
+	template.IPAddresses = nil
+	if _, ok := opts["path_len"]; ok {
+		pathLen, err := opts.getInt("path_len", 0)
+		if err != nil {
+			return nil, err
+		}
+		template.MaxPathLen = pathLen
+		template.MaxPathLenZero = pathLen == 0
+	}
+
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return nil, err
+	}
+	keyPEM, err := encodePrivateKeyPEM(key)
+	if err != nil {
+		return nil, err
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER})
+
+	return formatPEMOutput(opts, keyPEM, certPEM)
+}
+
+func certIssueCommand(c *GoCmd, args ...string) ([]string, error) {
+	// Should be:
+	// 0: ca_cert: PEM or base64, may include intermediates
+	// 1: ca_key: PEM or base64
+	// Options:
+	// key, bits, cn, o, ou, c, st, l, dns, ip, days, format: as for cert
+	// usage: comma separated key usages
+	// ext_usage: comma separated extended key usages
+	positional, opts := parseArgs(args)
+	if len(positional) < 2 {
+		return nil, ErrMissingArgs
+	}
+	caChain, caKey, err := loadCA(positional[0], positional[1])
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := generateKeyFromOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	template, err := leafTemplateFromOptions(opts, key.Public())
+	if err != nil {
+		return nil, err
+	}
+
+	certPEM, chainPEM, err := signCertificate(template, key.Public(), caChain, caKey)
+	if err != nil {
+		return nil, err
+	}
+	keyPEM, err := encodePrivateKeyPEM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return formatPEMOutput(opts, keyPEM, certPEM, chainPEM)
+}
+
+func certSignCSRCommand(c *GoCmd, args ...string) ([]string, error) {
+	// Should be:
+	// 0: ca_cert: PEM or base64, may include intermediates
+	// 1: ca_key: PEM or base64
+	// 2: csr: PEM or base64
+	// Options:
+	// dns, ip: override the names requested in the csr
+	// days, usage, ext_usage, format: as for cert_issue
+	positional, opts := parseArgs(args)
+	if len(positional) < 3 {
+		return nil, ErrMissingArgs
+	}
+	caChain, caKey, err := loadCA(positional[0], positional[1])
+	if err != nil {
+		return nil, err
+	}
+
+	csrPEM, err := decodePEMInput(positional[2])
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(csrPEM)
+	if block == nil || !strings.Contains(block.Type, "CERTIFICATE REQUEST") {
+		return nil, errors.New("No certificate request found")
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return nil, fmt.Errorf("Invalid certificate request signature: %v", err)
+	}
+
+	opts.setDefault("cn", csr.Subject.CommonName)
+	template, err := leafTemplateFromOptions(opts, csr.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	template.Subject = csr.Subject
+	if _, ok := opts["dns"]; !ok && len(csr.DNSNames) > 0 {
+		template.DNSNames = csr.DNSNames
+	}
+	if _, ok := opts["ip"]; !ok && len(csr.IPAddresses) > 0 {
+		template.IPAddresses = csr.IPAddresses
+	}
+
+	certPEM, chainPEM, err := signCertificate(template, csr.PublicKey, caChain, caKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return formatPEMOutput(opts, certPEM, chainPEM)
+}
+
+func leafTemplateFromOptions(opts cmdOptions, pub crypto.PublicKey) (*x509.Certificate, error) {
+	template, err := certTemplateFromOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	template.IsCA = false
+
+	if _, ok := opts["usage"]; ok {
+		template.KeyUsage = 0
+		for _, name := range opts.getList("usage") {
+			usage, ok := keyUsageNames[name]
+			if !ok {
+				return nil, fmt.Errorf("Unknown key usage: %q", name)
+			}
+			template.KeyUsage |= usage
+		}
+	} else {
+		template.KeyUsage = leafKeyUsage(pub)
+	}
+
+	if _, ok := opts["ext_usage"]; ok {
+		template.ExtKeyUsage = nil
+		for _, name := range opts.getList("ext_usage") {
+			usage, ok := extKeyUsageNames[name]
+			if !ok {
+				return nil, fmt.Errorf("Unknown extended key usage: %q", name)
+			}
+			template.ExtKeyUsage = append(template.ExtKeyUsage, usage)
+		}
+	}
+	return template, nil
+}
+
+// signCertificate signs template with the first certificate in caChain and
+// returns the certificate and the full chain, leaf first.
+func signCertificate(template *x509.Certificate, pub crypto.PublicKey, caChain []*x509.Certificate, caKey crypto.Signer) ([]byte, []byte, error) {
+	caCert := caChain[0]
+	if template.NotAfter.After(caCert.NotAfter) {
+		template.NotAfter = caCert.NotAfter
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, caCert, pub, caKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER})
+
+	var chain bytes.Buffer
+	chain.Write(certPEM)
+	for _, cert := range caChain {
+		pem.Encode(&chain, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	}
+	return certPEM, chain.Bytes(), nil
+}
+
+// loadCA parses a CA certificate chain and its private key and checks that
+// they belong together.
+func loadCA(certInput, keyInput string) ([]*x509.Certificate, crypto.Signer, error) {
+	certPEM, err := decodePEMInput(certInput)
+	if err != nil {
+		return nil, nil, err
+	}
+	chain, err := parseCertificates(certPEM)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !chain[0].IsCA {
+		return nil, nil, errors.New("CA certificate is not a certificate authority")
+	}
+
+	keyPEM, err := decodePEMInput(keyInput)
+	if err != nil {
+		return nil, nil, err
+	}
+	key, err := parsePrivateKey(keyPEM)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !publicKeysEqual(chain[0].PublicKey, key.Public()) {
+		return nil, nil, errors.New("CA key does not match CA certificate")
+	}
+	return chain, key, nil
+}
+
+// decodePEMInput accepts PEM data either as is or base64 encoded, as output
+// by the cert command.
+func decodePEMInput(input string) ([]byte, error) {
+	input = strings.TrimSpace(input)
+	if strings.Contains(input, "-----BEGIN ") {
+		return []byte(input), nil
+	}
+	data, err := base64.StdEncoding.DecodeString(input)
+	if err != nil {
+		return nil, errors.New("Input is neither PEM nor base64 encoded PEM")
+	}
+	return data, nil
+}
+
+func parseCertificates(data []byte) ([]*x509.Certificate, error) {
+	certs := []*x509.Certificate{}
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("No certificate found")
+	}
+	return certs, nil
+}
+
+func parsePrivateKey(data []byte) (crypto.Signer, error) {
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return nil, errors.New("No private key found")
+		}
+		switch block.Type {
+		case "RSA PRIVATE KEY":
+			return x509.ParsePKCS1PrivateKey(block.Bytes)
+		case "EC PRIVATE KEY":
+			return x509.ParseECPrivateKey(block.Bytes)
+		case "PRIVATE KEY":
+			key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			signer, ok := key.(crypto.Signer)
+			if !ok {
+				return nil, errors.New("Unsupported private key type")
+			}
+			return signer, nil
+		case "ENCRYPTED PRIVATE KEY":
+			return nil, errors.New("Encrypted private keys are not supported")
+		}
+	}
+}
+
+func isRSAPublicKey(pub crypto.PublicKey) bool {
+	_, ok := pub.(*rsa.PublicKey)
+	return ok
+}
+
+func publicKeysEqual(a, b crypto.PublicKey) bool {
+	key, ok := a.(interface {
+		Equal(crypto.PublicKey) bool
+	})
+	return ok && key.Equal(b)
+}
@@ -0,0 +1,205 @@
+package command
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"net"
+	"testing"
+)
+
+func createTestCA(t *testing.T, args ...string) (string, string, *x509.Certificate) {
+	t.Helper()
+	results, err := caCreateCommand(nil, append([]string{"--", "format=pem"}, args...)...)
+	if err != nil {
+		t.Fatalf("caCreateCommand: %v", err)
+	}
+	return results[1], results[0], parseCertResult(t, results[1], OutputFormatPEM)
+}
+
+func TestCACreate(t *testing.T) {
+	_, _, ca := createTestCA(t, "key=ecdsa-p256", "path_len=0")
+	if !ca.IsCA {
+		t.Error("CA certificate is not a CA")
+	}
+	if ca.KeyUsage&x509.KeyUsageCertSign == 0 {
+		t.Error("CA certificate cannot sign certificates")
+	}
+	if ca.MaxPathLen != 0 || !ca.MaxPathLenZero {
+		t.Errorf("path length = %d, zero = %v", ca.MaxPathLen, ca.MaxPathLenZero)
+	}
+	if ca.Subject.CommonName != "Replicated CA" {
+		t.Errorf("common name = %q", ca.Subject.CommonName)
+	}
+	if len(ca.DNSNames) != 0 || len(ca.IPAddresses) != 0 {
+		t.Errorf("CA certificate has names %q %v", ca.DNSNames, ca.IPAddresses)
+	}
+}
+
+func TestCertIssue(t *testing.T) {
+	caPEM, caKeyPEM, ca := createTestCA(t, "key=ecdsa-p256")
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+
+	// CA input may be base64 encoded as returned by default.
+	caResults, err := caCreateCommand(nil, "--", "key=ed25519")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := certIssueCommand(nil, caResults[1], caResults[0], "--", "key=ed25519"); err != nil {
+		t.Errorf("certIssueCommand with base64 CA: %v", err)
+	}
+
+	tests := []struct {
+		args     []string
+		keyUsage x509.KeyUsage
+		extUsage []x509.ExtKeyUsage
+	}{
+		{
+			args:     []string{"key=rsa", "bits=1024", "cn=svc.example.com", "dns=svc.example.com", "ip=10.0.0.1"},
+			keyUsage: x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+			extUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		},
+		{
+			args:     []string{"key=ecdsa-p256", "cn=svc.example.com"},
+			keyUsage: x509.KeyUsageDigitalSignature,
+			extUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		},
+		{
+			args:     []string{"key=ed25519", "cn=svc.example.com", "usage=digital_signature,key_agreement", "ext_usage=server_auth,client_auth"},
+			keyUsage: x509.KeyUsageDigitalSignature | x509.KeyUsageKeyAgreement,
+			extUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		},
+	}
+	for _, test := range tests {
+		args := append([]string{caPEM, caKeyPEM, "--", "format=pem"}, test.args...)
+		results, err := certIssueCommand(nil, args...)
+		if err != nil {
+			t.Fatalf("certIssueCommand(%q): %v", test.args, err)
+		}
+		if len(results) != 3 {
+			t.Fatalf("certIssueCommand(%q) returned %d results", test.args, len(results))
+		}
+		cert := parseCertResult(t, results[1], OutputFormatPEM)
+		if cert.IsCA {
+			t.Errorf("certIssueCommand(%q) issued a CA certificate", test.args)
+		}
+		if cert.KeyUsage != test.keyUsage {
+			t.Errorf("certIssueCommand(%q) key usage = %v, want %v", test.args, cert.KeyUsage, test.keyUsage)
+		}
+		if len(cert.ExtKeyUsage) != len(test.extUsage) {
+			t.Errorf("certIssueCommand(%q) ext key usage = %v, want %v", test.args, cert.ExtKeyUsage, test.extUsage)
+		}
+		if _, err := cert.Verify(x509.VerifyOptions{DNSName: "svc.example.com", Roots: roots, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}}); err != nil {
+			t.Errorf("certIssueCommand(%q) certificate does not verify: %v", test.args, err)
+		}
+		chain, err := parseCertificates([]byte(results[2]))
+		if err != nil || len(chain) != 2 || !chain[1].Equal(ca) {
+			t.Errorf("certIssueCommand(%q) chain = %d certificates, %v", test.args, len(chain), err)
+		}
+	}
+}
+
+func TestCertIssueErrors(t *testing.T) {
+	caPEM, caKeyPEM, _ := createTestCA(t, "key=ecdsa-p256")
+	_, otherKeyPEM, _ := createTestCA(t, "key=ecdsa-p256")
+	leaf, err := certCommand(nil, "--", "key=ecdsa-p256", "format=pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{"missing args", []string{caPEM}},
+		{"not a CA", []string{leaf[1], leaf[0]}},
+		{"mismatched key", []string{caPEM, otherKeyPEM}},
+		{"bad input", []string{"not a certificate", caKeyPEM}},
+		{"unknown usage", []string{caPEM, caKeyPEM, "--", "key=ed25519", "usage=sign_everything"}},
+		{"unknown ext usage", []string{caPEM, caKeyPEM, "--", "key=ed25519", "ext_usage=everything"}},
+	}
+	for _, test := range tests {
+		if _, err := certIssueCommand(nil, test.args...); err == nil {
+			t.Errorf("%s: certIssueCommand succeeded", test.name)
+		}
+	}
+}
+
+func TestCertIssueValidityCappedByCA(t *testing.T) {
+	caPEM, caKeyPEM, ca := createTestCA(t, "key=ecdsa-p256", "days=10")
+	results, err := certIssueCommand(nil, caPEM, caKeyPEM, "--", "key=ed25519", "days=365", "format=pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert := parseCertResult(t, results[1], OutputFormatPEM); cert.NotAfter.After(ca.NotAfter) {
+		t.Errorf("certificate expires %v, after the CA %v", cert.NotAfter, ca.NotAfter)
+	}
+}
+
+func TestCertSignCSR(t *testing.T) {
+	caPEM, caKeyPEM, ca := createTestCA(t, "key=ecdsa-p256")
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csrDER, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:     pkix.Name{CommonName: "csr.example.com", Organization: []string{"Example"}},
+		DNSNames:    []string{"csr.example.com", "alt.example.com"},
+		IPAddresses: []net.IP{net.ParseIP("10.1.2.3")},
+	}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csrPEM := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csrDER}))
+
+	results, err := certSignCSRCommand(nil, caPEM, caKeyPEM, csrPEM, "--", "format=pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("returned %d results", len(results))
+	}
+	cert := parseCertResult(t, results[0], OutputFormatPEM)
+	if cert.Subject.CommonName != "csr.example.com" || len(cert.Subject.Organization) != 1 {
+		t.Errorf("subject = %v", cert.Subject)
+	}
+	if len(cert.DNSNames) != 2 || len(cert.IPAddresses) != 1 {
+		t.Errorf("names = %q %v", cert.DNSNames, cert.IPAddresses)
+	}
+	if !publicKeysEqual(cert.PublicKey, key.Public()) {
+		t.Error("certificate does not carry the CSR public key")
+	}
+	if cert.KeyUsage != x509.KeyUsageDigitalSignature {
+		t.Errorf("key usage = %v", cert.KeyUsage)
+	}
+	if _, err := cert.Verify(x509.VerifyOptions{DNSName: "alt.example.com", Roots: roots}); err != nil {
+		t.Errorf("certificate does not verify: %v", err)
+	}
+
+	// Names given as options override the CSR.
+	results, err = certSignCSRCommand(nil, caPEM, caKeyPEM, csrPEM, "--", "dns=override.example.com", "format=pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert = parseCertResult(t, results[0], OutputFormatPEM)
+	if len(cert.DNSNames) != 1 || cert.DNSNames[0] != "override.example.com" || len(cert.IPAddresses) != 1 {
+		t.Errorf("names = %q %v", cert.DNSNames, cert.IPAddresses)
+	}
+
+	// A tampered CSR fails the signature check.
+	csrDER[len(csrDER)-1] ^= 0xff
+	badPEM := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csrDER}))
+	if _, err := certSignCSRCommand(nil, caPEM, caKeyPEM, badPEM); err == nil {
+		t.Error("signed a CSR with an invalid signature")
+	}
+	if _, err := certSignCSRCommand(nil, caPEM, caKeyPEM, caPEM); err == nil {
+		t.Error("signed a certificate as a CSR")
+	}
+}
@@ -0,0 +1,218 @@
+package command
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"time"
+)
+
+const (
+	KeyTypeRSA       = "rsa"
+	KeyTypeECDSAP256 = "ecdsa-p256"
+	KeyTypeECDSAP384 = "ecdsa-p384"
+	KeyTypeEd25519   = "ed25519"
+
+	OutputFormatPEM    = "pem"
+	OutputFormatBase64 = "base64"
+
+	defaultRSABits  = 2048
+	defaultCertDays = 365
+)
+
+var (
+	validRSABits = []int{1024, 2048, 3072, 4096}
+
+	serialNumberLimit = new(big.Int).Lsh(big.NewInt(1), 128)
+)
+
+func certCommand(c *GoCmd, args ...string) ([]string, error) {
+	// Should be:
+	// 0: bits (optional, deprecated in favor of bits=)
+	// Options:
+	// key: "rsa", "ecdsa-p256", "ecdsa-p384" or "ed25519"
+	// bits: rsa key size
+	// cn, o, ou, c, st, l: subject
+	// dns, ip: comma separated subject alternative names
+	// days: validity
+	// format: "base64" or "pem"
+	positional, opts := parseArgs(args)
+	if len(positional) > 0 {
+		opts.setDefault("bits", positional[0])
+	}
+
+	key, err := generateKeyFromOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	template, err := certTemplateFromOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	template.KeyUsage = leafKeyUsage(key.Public())
+
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return nil, err
+	}
+	keyPEM, err := encodePrivateKeyPEM(key)
+	if err != nil {
+		return nil, err
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER})
+
+	return formatPEMOutput(opts, keyPEM, certPEM)
+}
+
+func generateKeyFromOptions(opts cmdOptions) (crypto.Signer, error) {
+	bits, err := opts.getInt("bits", defaultRSABits)
+	if err != nil {
+		return nil, err
+	}
+	return generateKey(opts.get("key", KeyTypeRSA), bits)
+}
+
+func generateKey(keyType string, bits int) (crypto.Signer, error) {
+	switch keyType {
+	case KeyTypeRSA:
+		valid := false
+		for _, b := range validRSABits {
+			if b == bits {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return nil, fmt.Errorf("RSA key size must be one of %v", validRSABits)
+		}
+		return rsa.GenerateKey(rand.Reader, bits)
+	case KeyTypeECDSAP256:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case KeyTypeECDSAP384:
+		return ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case KeyTypeEd25519:
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		return key, err
+	default:
+		return nil, fmt.Errorf("Key type must be one of %q, %q, %q or %q", KeyTypeRSA, KeyTypeECDSAP256, KeyTypeECDSAP384, KeyTypeEd25519)
+	}
+}
+
+// encodePrivateKeyPEM encodes RSA and ECDSA keys in their traditional
+// formats, which is what openssl produced, and other keys as PKCS #8.
+func encodePrivateKeyPEM(key crypto.Signer) ([]byte, error) {
+	var block *pem.Block
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		block = &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(k)}
+	case *ecdsa.PrivateKey:
+		der, err := x509.MarshalECPrivateKey(k)
+		if err != nil {
+			return nil, err
+		}
+		block = &pem.Block{Type: "EC PRIVATE KEY", Bytes: der}
+	default:
+		der, err := x509.MarshalPKCS8PrivateKey(k)
+		if err != nil {
+			return nil, err
+		}
+		block = &pem.Block{Type: "PRIVATE KEY", Bytes: der}
+	}
+	return pem.EncodeToMemory(block), nil
+}
+
+func certTemplateFromOptions(opts cmdOptions) (*x509.Certificate, error) {
+	days, err := opts.getInt("days", defaultCertDays)
+	if err != nil {
+		return nil, err
+	}
+	if days < 1 {
+		return nil, fmt.Errorf("Invalid value for days: %d", days)
+	}
+
+	serialNumber, err := rand.Int(rand.Reader, serialNumberLimit)
+	if err != nil {
+		return nil, err
+	}
+
+	subject := pkix.Name{
+		Country:            nameList(opts.get("c", "US")),
+		Province:           nameList(opts.get("st", "California")),
+		Locality:           nameList(opts.get("l", "Los Angeles")),
+		Organization:       nameList(opts.get("o", "Replicated")),
+		OrganizationalUnit: opts.getList("ou"),
+		CommonName:         opts.get("cn", "example.com"),
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          serialNumber,
+		Subject:               subject,
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().AddDate(0, 0, days),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              opts.getList("dns"),
+	}
+	for _, ipStr := range opts.getList("ip") {
+		ip := net.ParseIP(ipStr)
+		if ip == nil {
+			return nil, fmt.Errorf("Invalid IP address: %q", ipStr)
+		}
+		template.IPAddresses = append(template.IPAddresses, ip)
+	}
+	if len(template.DNSNames) == 0 && len(template.IPAddresses) == 0 && subject.CommonName != "" {
+		if ip := net.ParseIP(subject.CommonName); ip != nil {
+			template.IPAddresses = []net.IP{ip}
+		} else {
+			template.DNSNames = []string{subject.CommonName}
+		}
+	}
+	return template, nil
+}
+
+// leafKeyUsage returns the key usage of an end-entity certificate for pub.
+// Key encipherment only applies to RSA key exchange.
+func leafKeyUsage(pub crypto.PublicKey) x509.KeyUsage {
+	if isRSAPublicKey(pub) {
+		return x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment
+	}
+	return x509.KeyUsageDigitalSignature
+}
+
+func nameList(value string) []string {
+	if value == "" {
+		return nil
+	}
+	return []string{value}
+}
+
+// formatPEMOutput returns the PEM blocks as is or base64 encoded, depending
+// on the format option. Base64 is the default for compatibility with the
+// openssl based cert command.
+func formatPEMOutput(opts cmdOptions, blocks ...[]byte) ([]string, error) {
+	format := opts.get("format", OutputFormatBase64)
+	results := make([]string, len(blocks))
+	for i, block := range blocks {
+		switch format {
+		case OutputFormatBase64:
+			results[i] = base64.StdEncoding.EncodeToString(bytes.TrimSpace(block))
+		case OutputFormatPEM:
+			results[i] = string(bytes.TrimSpace(block))
+		default:
+			return nil, fmt.Errorf("Output format must be one of %q or %q", OutputFormatBase64, OutputFormatPEM)
+		}
+	}
+	return results, nil
+}
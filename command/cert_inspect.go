@@ -0,0 +1,193 @@
+package command
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+type certInfo struct {
+	Subject            string    `json:"subject"`
+	Issuer             string    `json:"issuer"`
+	SerialNumber       string    `json:"serial_number"`
+	DNSNames           []string  `json:"dns_names"`
+	IPAddresses        []string  `json:"ip_addresses"`
+	EmailAddresses     []string  `json:"email_addresses,omitempty"`
+	NotBefore          time.Time `json:"not_before"`
+	NotAfter           time.Time `json:"not_after"`
+	DaysUntilExpiry    int       `json:"days_until_expiry"`
+	Expired            bool      `json:"expired"`
+	IsCA               bool      `json:"is_ca"`
+	KeyType            string    `json:"key_type"`
+	KeySize            int       `json:"key_size"`
+	SignatureAlgorithm string    `json:"signature_algorithm"`
+}
+
+type certInspectResult struct {
+	certInfo
+	Chain         []certInfo `json:"chain,omitempty"`
+	KeyMatches    *bool      `json:"key_matches,omitempty"`
+	Hostname      string     `json:"hostname,omitempty"`
+	HostnameValid *bool      `json:"hostname_valid,omitempty"`
+	HostnameError string     `json:"hostname_error,omitempty"`
+	ChainValid    bool       `json:"chain_valid"`
+	ChainError    string     `json:"chain_error,omitempty"`
+}
+
+func certInspectCommand(c *GoCmd, args ...string) ([]string, error) {
+	// Should be:
+	// 0: cert: PEM or base64, may be followed by the chain
+	// 1: key: PEM or base64 (optional)
+	// Options:
+	// chain: intermediate certificates, PEM or base64
+	// ca: trusted CA bundle, PEM or base64. Defaults to the system pool.
+	// hostname: name to verify the certificate for
+	positional, opts := parseArgs(args)
+	if len(positional) < 1 {
+		return nil, ErrMissingArgs
+	}
+
+	certPEM, err := decodePEMInput(positional[0])
+	if err != nil {
+		return nil, err
+	}
+	certs, err := parseCertificates(certPEM)
+	if err != nil {
+		return nil, err
+	}
+	if chainInput := opts.get("chain", ""); chainInput != "" {
+		chainPEM, err := decodePEMInput(chainInput)
+		if err != nil {
+			return nil, err
+		}
+		chain, err := parseCertificates(chainPEM)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, chain...)
+	}
+	cert := certs[0]
+
+	result := certInspectResult{certInfo: describeCertificate(cert)}
+	for _, intermediate := range certs[1:] {
+		result.Chain = append(result.Chain, describeCertificate(intermediate))
+	}
+
+	if len(positional) > 1 && positional[1] != "" {
+		keyPEM, err := decodePEMInput(positional[1])
+		if err != nil {
+			return nil, err
+		}
+		key, err := parsePrivateKey(keyPEM)
+		if err != nil {
+			return nil, err
+		}
+		matches := publicKeysEqual(cert.PublicKey, key.Public())
+		result.KeyMatches = &matches
+	}
+
+	roots, err := certPoolFromOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	intermediates := x509.NewCertPool()
+	for _, intermediate := range certs[1:] {
+		intermediates.AddCert(intermediate)
+	}
+	verifyOpts := x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}
+	if _, err := cert.Verify(verifyOpts); err != nil {
+		result.ChainError = err.Error()
+	} else {
+		result.ChainValid = true
+	}
+
+	if hostname := opts.get("hostname", ""); hostname != "" {
+		result.Hostname = hostname
+		valid := true
+		if err := cert.VerifyHostname(hostname); err != nil {
+			valid = false
+			result.HostnameError = err.Error()
+		}
+		result.HostnameValid = &valid
+	}
+
+	return jsonResult(result)
+}
+
+func describeCertificate(cert *x509.Certificate) certInfo {
+	info := certInfo{
+		Subject:            cert.Subject.String(),
+		Issuer:             cert.Issuer.String(),
+		SerialNumber:       fmt.Sprintf("%x", cert.SerialNumber),
+		DNSNames:           cert.DNSNames,
+		IPAddresses:        []string{},
+		EmailAddresses:     cert.EmailAddresses,
+		NotBefore:          cert.NotBefore,
+		NotAfter:           cert.NotAfter,
+		DaysUntilExpiry:    int(time.Until(cert.NotAfter).Hours() / 24),
+		Expired:            time.Now().After(cert.NotAfter),
+		IsCA:               cert.IsCA,
+		SignatureAlgorithm: cert.SignatureAlgorithm.String(),
+	}
+	if info.DNSNames == nil {
+		info.DNSNames = []string{}
+	}
+	for _, ip := range cert.IPAddresses {
+		info.IPAddresses = append(info.IPAddresses, ip.String())
+	}
+	info.KeyType, info.KeySize = describePublicKey(cert.PublicKey)
+	return info
+}
+
+func describePublicKey(pub crypto.PublicKey) (string, int) {
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		return "RSA", k.N.BitLen()
+	case *ecdsa.PublicKey:
+		return "ECDSA", k.Curve.Params().BitSize
+	case ed25519.PublicKey:
+		return "Ed25519", 256
+	default:
+		return "unknown", 0
+	}
+}
+
+// certPoolFromOptions returns the pool of trusted roots from the ca option,
+// or the system pool if it is not set.
+func certPoolFromOptions(opts cmdOptions) (*x509.CertPool, error) {
+	caInput := opts.get("ca", "")
+	if caInput == "" {
+		return x509.SystemCertPool()
+	}
+	caPEM, err := decodePEMInput(caInput)
+	if err != nil {
+		return nil, err
+	}
+	cas, err := parseCertificates(caPEM)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	for _, ca := range cas {
+		pool.AddCert(ca)
+	}
+	return pool, nil
+}
+
+// jsonResult returns v encoded as a single JSON result.
+func jsonResult(v interface{}) ([]string, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return []string{string(b)}, nil
+}
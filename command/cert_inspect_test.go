@@ -0,0 +1,97 @@
+package command
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func inspectCert(t *testing.T, args ...string) certInspectResult {
+	t.Helper()
+	results, err := certInspectCommand(nil, args...)
+	if err != nil {
+		t.Fatalf("certInspectCommand: %v", err)
+	}
+	var result certInspectResult
+	if err := json.Unmarshal([]byte(results[0]), &result); err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func TestCertInspect(t *testing.T) {
+	caPEM, caKeyPEM, _ := createTestCA(t, "key=ecdsa-p256", "cn=Test CA")
+	leaf, err := certIssueCommand(nil, caPEM, caKeyPEM, "--", "key=rsa", "bits=1024", "cn=svc.example.com", "ip=10.0.0.1", "dns=svc.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := certCommand(nil, "--", "key=ed25519")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := inspectCert(t, leaf[1], leaf[0], "--", "chain="+caPEM, "ca="+caPEM, "hostname=svc.example.com")
+	if result.Subject != "CN=svc.example.com,O=Replicated,L=Los Angeles,ST=California,C=US" {
+		t.Errorf("subject = %q", result.Subject)
+	}
+	if result.KeyType != "RSA" || result.KeySize != 1024 {
+		t.Errorf("key = %s %d", result.KeyType, result.KeySize)
+	}
+	if len(result.IPAddresses) != 1 || result.IPAddresses[0] != "10.0.0.1" {
+		t.Errorf("ip addresses = %q", result.IPAddresses)
+	}
+	if result.IsCA || result.Expired || result.DaysUntilExpiry < 363 {
+		t.Errorf("is_ca = %v, expired = %v, days = %d", result.IsCA, result.Expired, result.DaysUntilExpiry)
+	}
+	if len(result.Chain) != 1 || !result.Chain[0].IsCA || result.Chain[0].KeyType != "ECDSA" || result.Chain[0].KeySize != 256 {
+		t.Errorf("chain = %+v", result.Chain)
+	}
+	if result.KeyMatches == nil || !*result.KeyMatches {
+		t.Error("key does not match")
+	}
+	if !result.ChainValid || result.ChainError != "" {
+		t.Errorf("chain invalid: %s", result.ChainError)
+	}
+	if result.HostnameValid == nil || !*result.HostnameValid {
+		t.Errorf("hostname invalid: %s", result.HostnameError)
+	}
+
+	// The chain returned by cert_issue already holds the leaf and the CA.
+	result = inspectCert(t, leaf[2], otherKey[0], "--", "ca="+caPEM, "hostname=other.example.com")
+	if len(result.Chain) != 1 || !result.ChainValid {
+		t.Errorf("chain = %d certificates, valid = %v", len(result.Chain), result.ChainValid)
+	}
+	if result.KeyMatches == nil || *result.KeyMatches {
+		t.Error("key of another certificate matches")
+	}
+	if result.HostnameValid == nil || *result.HostnameValid || result.HostnameError == "" {
+		t.Error("hostname other.example.com is valid")
+	}
+
+	// Without the CA the chain does not verify against the system pool.
+	result = inspectCert(t, leaf[1])
+	if result.ChainValid || result.ChainError == "" {
+		t.Error("chain is valid without its CA")
+	}
+	if result.KeyMatches != nil || result.HostnameValid != nil {
+		t.Error("key or hostname reported without being given")
+	}
+}
+
+func TestCertInspectErrors(t *testing.T) {
+	if _, err := certInspectCommand(nil); err != ErrMissingArgs {
+		t.Errorf("got %v, want ErrMissingArgs", err)
+	}
+	if _, err := certInspectCommand(nil, "not a certificate"); err == nil {
+		t.Error("inspected an invalid certificate")
+	}
+	cert, err := certCommand(nil, "--", "key=ed25519")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := certInspectCommand(nil, cert[1], "not a key"); err == nil {
+		t.Error("accepted an invalid key")
+	}
+	if _, err := certInspectCommand(nil, cert[1], "--", "ca=not a certificate"); err == nil {
+		t.Error("accepted an invalid CA bundle")
+	}
+}
@@ -0,0 +1,103 @@
+package command
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"testing"
+)
+
+// parseCertResult decodes the certificate returned in results[1].
+func parseCertResult(t *testing.T, result string, format string) *x509.Certificate {
+	t.Helper()
+	data := []byte(result)
+	if format != OutputFormatPEM {
+		var err error
+		if data, err = base64.StdEncoding.DecodeString(result); err != nil {
+			t.Fatalf("decode base64: %v", err)
+		}
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		t.Fatalf("no certificate in %q", data)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestCertCommand(t *testing.T) {
+	tests := []struct {
+		args     []string
+		keyType  string
+		keyUsage x509.KeyUsage
+	}{
+		{[]string{"1024"}, "RSA PRIVATE KEY", x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment},
+		{[]string{"--", "key=ecdsa-p256", "format=pem"}, "EC PRIVATE KEY", x509.KeyUsageDigitalSignature},
+		{[]string{"--", "key=ed25519", "format=pem"}, "PRIVATE KEY", x509.KeyUsageDigitalSignature},
+	}
+	for _, test := range tests {
+		_, opts := parseArgs(test.args)
+		format := opts.get("format", OutputFormatBase64)
+		results, err := certCommand(nil, test.args...)
+		if err != nil {
+			t.Fatalf("certCommand(%q): %v", test.args, err)
+		}
+		if len(results) != 2 {
+			t.Fatalf("certCommand(%q) returned %d results", test.args, len(results))
+		}
+
+		keyPEM := []byte(results[0])
+		if format != OutputFormatPEM {
+			keyPEM, _ = base64.StdEncoding.DecodeString(results[0])
+		}
+		if block, _ := pem.Decode(keyPEM); block == nil || block.Type != test.keyType {
+			t.Errorf("certCommand(%q) key block = %v, want %s", test.args, block, test.keyType)
+		}
+
+		cert := parseCertResult(t, results[1], format)
+		if cert.IsCA {
+			t.Errorf("certCommand(%q) created a CA certificate", test.args)
+		}
+		if cert.KeyUsage != test.keyUsage {
+			t.Errorf("certCommand(%q) key usage = %v, want %v", test.args, cert.KeyUsage, test.keyUsage)
+		}
+		if len(cert.DNSNames) != 1 || cert.DNSNames[0] != "example.com" {
+			t.Errorf("certCommand(%q) DNS names = %q", test.args, cert.DNSNames)
+		}
+
+		roots := x509.NewCertPool()
+		roots.AddCert(cert)
+		if _, err := cert.Verify(x509.VerifyOptions{DNSName: "example.com", Roots: roots}); err != nil {
+			t.Errorf("certCommand(%q) certificate does not verify: %v", test.args, err)
+		}
+	}
+}
+
+func TestCertCommandOptions(t *testing.T) {
+	results, err := certCommand(nil, "--", "key=ecdsa-p384", "cn=10.0.0.1", "days=2", "format=pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := parseCertResult(t, results[1], OutputFormatPEM)
+	if len(cert.IPAddresses) != 1 || cert.IPAddresses[0].String() != "10.0.0.1" {
+		t.Errorf("IP addresses = %v", cert.IPAddresses)
+	}
+	if d := cert.NotAfter.Sub(cert.NotBefore).Hours(); d < 47 || d > 49 {
+		t.Errorf("validity = %vh, want 48h", d)
+	}
+
+	for _, args := range [][]string{
+		{"--", "bits=1000"},
+		{"--", "key=dsa"},
+		{"--", "days=0"},
+		{"--", "ip=nope", "key=ed25519"},
+		{"--", "format=der", "key=ed25519"},
+	} {
+		if _, err := certCommand(nil, args...); err == nil {
+			t.Errorf("certCommand(%q) succeeded", args)
+		}
+	}
+}
@@ -5,8 +5,10 @@ import (
 )
 
 var (
-	ErrCommandNotFound = errors.New("command not found")
-	ErrMissingArgs     = errors.New("Missing required arguments")
+	ErrCommandNotFound  = errors.New("command not found")
+	ErrMissingArgs      = errors.New("Missing required arguments")
+	ErrCommandCancelled = errors.New("Command cancelled")
+	ErrShuttingDown     = errors.New("Runner is shutting down")
 )
 
 type ErrCommandResponse struct {
@@ -22,4 +24,11 @@ type CmdConfig struct {
 	DockerEndpoint      string
 	ContainerRepository string
 	ContainerTag        string
+	BuildContextDir     string
+	ImageArchive        string
+	PublicIPProviders   string
+	ProxyURL            string
+	ProxyUsername       string
+	ProxyPassword       string
+	NoProxy             string
 }
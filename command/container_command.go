@@ -10,7 +10,9 @@ import (
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"path"
 	"strings"
+	"sync"
 	"time"
 
 	log "github.com/Sirupsen/logrus"
@@ -19,60 +21,75 @@ import (
 
 var (
 	timeoutDuration = time.Second * 15
-
-	availableCommands = []string{
-		"cert",
-		"random",
-		"raw",
-	}
 )
 
 type ContainerCmd struct {
 	Op           string
 	Timeout      bool
+	command      ManifestCommand
 	config       CmdConfig
 	dockerClient *docker.Client
+	runner       *Runner
+
+	mu          sync.Mutex
+	containerID string
+	cancel      chan struct{}
+	cancelOnce  sync.Once
 }
 
 func NewContainerCmd(op string, config CmdConfig, dockerClient *docker.Client) (*ContainerCmd, error) {
-	exists := false
-	for _, o := range availableCommands {
-		if o == op {
-			exists = true
-			break
-		}
-	}
+	manifestCmd, exists := lookupContainerCommand(op)
 	if !exists {
 		return nil, ErrCommandNotFound
 	}
 	cmd := ContainerCmd{
 		Op:           op,
+		command:      manifestCmd,
 		config:       config,
 		dockerClient: dockerClient,
+		cancel:       make(chan struct{}),
 	}
 	return &cmd, nil
 }
 
 func (c *ContainerCmd) Run(args ...string) ([]string, error) {
-	cmdParts := []string{"bash", fmt.Sprintf("%s/%s.sh", c.config.CommandsDir, c.Op)}
+	if len(args) < c.command.RequiredArgs() {
+		return nil, ErrMissingArgs
+	}
+	if c.runner != nil {
+		if err := c.runner.track(c); err != nil {
+			return nil, err
+		}
+		defer c.runner.untrack(c)
+	}
+
+	cmdParts := []string{"bash", path.Join(c.config.CommandsDir, c.command.Script)}
 	cmdParts = append(cmdParts, args...)
 	container, err := createContainer(c.dockerClient, c.config.ContainerRepository, c.config.ContainerTag, cmdParts)
 	if err != nil {
 		return nil, err
 	}
 	defer removeContainer(c.dockerClient, container.ID)
+	c.setContainerID(container.ID)
 
+	if c.cancelled() {
+		return nil, ErrCommandCancelled
+	}
 	if err := startContainer(c.dockerClient, container.ID); err != nil {
 		return nil, err
 	}
 
 	exitCode := -1
-	exitCh := make(chan int)
+	exitCh := make(chan int, 1)
+	stopCh := make(chan struct{})
+	defer close(stopCh)
 
 	go func() {
 		for {
-			if c.Timeout {
+			select {
+			case <-stopCh:
 				return
+			default:
 			}
 			state, err := getContainerState(c.dockerClient, container.ID)
 			if err == nil && !state.FinishedAt.IsZero() {
@@ -86,6 +103,8 @@ func (c *ContainerCmd) Run(args ...string) ([]string, error) {
 	select {
 	case <-time.After(timeoutDuration):
 		c.Timeout = true
+	case <-c.cancel:
+		return nil, ErrCommandCancelled
 	case exitCode = <-exitCh:
 		break
 	}
@@ -103,6 +122,35 @@ func (c *ContainerCmd) Run(args ...string) ([]string, error) {
 	return []string{strings.TrimSpace(stderr)}, ErrCommandResponse{errMsg}
 }
 
+// Cancel stops a running command. Run returns ErrCommandCancelled and removes
+// the container.
+func (c *ContainerCmd) Cancel() {
+	c.cancelOnce.Do(func() {
+		close(c.cancel)
+	})
+}
+
+func (c *ContainerCmd) cancelled() bool {
+	select {
+	case <-c.cancel:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *ContainerCmd) setContainerID(containerID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.containerID = containerID
+}
+
+func (c *ContainerCmd) getContainerID() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.containerID
+}
+
 func PullImage(client *docker.Client, repository, tag string) error {
 	reader, writer := io.Pipe()
 	go func(reader io.Reader) {
@@ -155,6 +203,19 @@ func startContainer(client *docker.Client, containerID string) error {
 	return nil
 }
 
+func killContainer(client *docker.Client, containerID string) error {
+	log.Debugf("killing container %s", containerID)
+	opts := docker.KillContainerOptions{
+		ID: containerID,
+	}
+	if err := client.KillContainer(opts); err != nil {
+		log.Errorf(" -> error killing container %s: %s", containerID, err)
+		return err
+	}
+	log.Debugf(" -> container %s killed", containerID)
+	return nil
+}
+
 func removeContainer(client *docker.Client, containerID string) error {
 	log.Debugf("removing container %s", containerID)
 	opts := docker.RemoveContainerOptions{
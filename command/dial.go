@@ -0,0 +1,29 @@
+package command
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+// dial connects to address within timeout. The dial fails with
+// ErrCommandCancelled and the connection is closed if the command is
+// cancelled, which aborts reads and writes in progress.
+func (c *GoCmd) dial(ctx context.Context, network, address string, timeout time.Duration) (net.Conn, error) {
+	cmdCtx := c.context()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := context.AfterFunc(cmdCtx, cancel)
+	defer stop()
+
+	dialer := &net.Dialer{Timeout: timeout}
+	conn, err := dialer.DialContext(ctx, network, address)
+	if err != nil {
+		if cmdCtx.Err() != nil {
+			return nil, ErrCommandCancelled
+		}
+		return nil, err
+	}
+	context.AfterFunc(cmdCtx, func() { conn.Close() })
+	return conn, nil
+}
@@ -0,0 +1,394 @@
+package command
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	defaultDNSTimeout = 5 * time.Second
+	dnsUDPSize        = 4096
+	resolvConfPath    = "/etc/resolv.conf"
+)
+
+var dnsTypes = map[string]dnsmessage.Type{
+	"A":     dnsmessage.TypeA,
+	"AAAA":  dnsmessage.TypeAAAA,
+	"CNAME": dnsmessage.TypeCNAME,
+	"MX":    dnsmessage.TypeMX,
+	"NS":    dnsmessage.TypeNS,
+	"PTR":   dnsmessage.TypePTR,
+	"SOA":   dnsmessage.TypeSOA,
+	"SRV":   dnsmessage.TypeSRV,
+	"TXT":   dnsmessage.TypeTXT,
+	"CAA":   dnsmessage.Type(257),
+}
+
+type dnsAnswer struct {
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+	TTL   uint32 `json:"ttl"`
+	Value string `json:"value"`
+}
+
+type dnsServerResult struct {
+	Server        string      `json:"server"`
+	Protocol      string      `json:"protocol"`
+	RCode         string      `json:"rcode,omitempty"`
+	LatencyMS     float64     `json:"latency_ms"`
+	Authoritative bool        `json:"authoritative"`
+	Truncated     bool        `json:"truncated"`
+	Answers       []dnsAnswer `json:"answers"`
+	Error         string      `json:"error,omitempty"`
+}
+
+type dnsQueryResult struct {
+	Name       string            `json:"name"`
+	Type       string            `json:"type"`
+	Results    []dnsServerResult `json:"results"`
+	Consistent bool              `json:"consistent"`
+}
+
+func dnsQueryCommand(c *GoCmd, args ...string) ([]string, error) {
+	// Should be:
+	// 0: name
+	// 1: type (optional, defaults to A): A, AAAA, CNAME, MX, NS, PTR, SOA, SRV, TXT or CAA
+	// Options:
+	// servers: comma separated nameservers, defaults to those in /etc/resolv.conf
+	// protocol: "udp" or "tcp". Truncated udp responses are retried over tcp.
+	// timeout: per server timeout
+	positional, opts := parseArgs(args)
+	if len(positional) < 1 {
+		return nil, ErrMissingArgs
+	}
+	name := positional[0]
+	typeName := "A"
+	if len(positional) > 1 {
+		typeName = strings.ToUpper(positional[1])
+	}
+	qtype, ok := dnsTypes[typeName]
+	if !ok {
+		return nil, fmt.Errorf("Unsupported DNS record type: %q", typeName)
+	}
+	protocol := opts.get("protocol", "udp")
+	if protocol != "udp" && protocol != "tcp" {
+		return nil, errors.New("DNS protocol must be one of \"udp\" or \"tcp\"")
+	}
+	timeout, err := opts.getDuration("timeout", defaultDNSTimeout)
+	if err != nil {
+		return nil, err
+	}
+	servers, err := dnsServersFromOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	result := dnsQueryResult{
+		Name:    name,
+		Type:    typeName,
+		Results: make([]dnsServerResult, len(servers)),
+	}
+	var wg sync.WaitGroup
+	for i, server := range servers {
+		wg.Add(1)
+		go func(i int, server string) {
+			defer wg.Done()
+			result.Results[i] = c.dnsQuery(server, protocol, name, qtype, timeout)
+		}(i, server)
+	}
+	wg.Wait()
+
+	result.Consistent = true
+	succeeded := 0
+	var signature string
+	for _, r := range result.Results {
+		if r.Error != "" {
+			continue
+		}
+		succeeded++
+		if s := dnsResultSignature(r); succeeded == 1 {
+			signature = s
+		} else if s != signature {
+			result.Consistent = false
+		}
+	}
+
+	results, err := jsonResult(result)
+	if err != nil {
+		return nil, err
+	}
+	if succeeded == 0 {
+		return results, ErrCommandResponse{fmt.Sprintf("No DNS server answered the query for %s", name)}
+	}
+	return results, nil
+}
+
+func (c *GoCmd) dnsQuery(server, protocol, name string, qtype dnsmessage.Type, timeout time.Duration) dnsServerResult {
+	result := dnsServerResult{
+		Server:   server,
+		Protocol: protocol,
+		Answers:  []dnsAnswer{},
+	}
+
+	start := time.Now()
+	resp, protocol, err := c.dnsExchange(server, protocol, name, qtype, timeout)
+	result.Protocol = protocol
+	result.LatencyMS = float64(time.Since(start)) / float64(time.Millisecond)
+	if err != nil && c.context().Err() != nil {
+		err = ErrCommandCancelled
+	}
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	result.RCode = dnsRCodeName(resp.RCode)
+	result.Authoritative = resp.Authoritative
+	result.Truncated = resp.Truncated
+	for _, answer := range resp.Answers {
+		result.Answers = append(result.Answers, dnsAnswer{
+			Name:  answer.Header.Name.String(),
+			Type:  dnsTypeName(answer.Header.Type),
+			TTL:   answer.Header.TTL,
+			Value: dnsResourceValue(answer.Body),
+		})
+	}
+	return result
+}
+
+// dnsExchange queries server and returns the parsed response and the
+// protocol that answered. Truncated udp responses are retried over tcp.
+func (c *GoCmd) dnsExchange(server, protocol, name string, qtype dnsmessage.Type, timeout time.Duration) (*dnsmessage.Message, string, error) {
+	resp, err := c.dnsRoundTrip(server, protocol, name, qtype, timeout)
+	if err == nil && resp.Truncated && protocol == "udp" {
+		protocol = "tcp"
+		resp, err = c.dnsRoundTrip(server, protocol, name, qtype, timeout)
+	}
+	return resp, protocol, err
+}
+
+// dnsRoundTrip sends a single query to server and returns the response that
+// matches it. The connection is closed if the command is cancelled.
+func (c *GoCmd) dnsRoundTrip(server, protocol, name string, qtype dnsmessage.Type, timeout time.Duration) (*dnsmessage.Message, error) {
+	qname, err := dnsmessage.NewName(dnsFQDN(name))
+	if err != nil {
+		return nil, err
+	}
+	id, err := randIntn(1 << 16)
+	if err != nil {
+		return nil, err
+	}
+	msg := dnsmessage.Message{
+		Header: dnsmessage.Header{
+			ID:               uint16(id),
+			RecursionDesired: true,
+		},
+		Questions: []dnsmessage.Question{
+			{Name: qname, Type: qtype, Class: dnsmessage.ClassINET},
+		},
+	}
+	var opt dnsmessage.ResourceHeader
+	if err := opt.SetEDNS0(dnsUDPSize, dnsmessage.RCodeSuccess, false); err != nil {
+		return nil, err
+	}
+	msg.Additionals = []dnsmessage.Resource{{Header: opt, Body: &dnsmessage.OPTResource{}}}
+	query, err := msg.Pack()
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(timeout)
+	conn, err := c.dial(context.Background(), protocol, server, timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(deadline)
+
+	if protocol == "tcp" {
+		framed := make([]byte, 2+len(query))
+		binary.BigEndian.PutUint16(framed, uint16(len(query)))
+		copy(framed[2:], query)
+		if _, err := conn.Write(framed); err != nil {
+			return nil, err
+		}
+		var length [2]byte
+		if _, err := io.ReadFull(conn, length[:]); err != nil {
+			return nil, err
+		}
+		raw := make([]byte, binary.BigEndian.Uint16(length[:]))
+		if _, err := io.ReadFull(conn, raw); err != nil {
+			return nil, err
+		}
+		resp := &dnsmessage.Message{}
+		if err := resp.Unpack(raw); err != nil {
+			return nil, err
+		}
+		if !dnsResponseMatches(&msg, resp) {
+			return nil, errors.New("Invalid DNS response")
+		}
+		return resp, nil
+	}
+
+	if _, err := conn.Write(query); err != nil {
+		return nil, err
+	}
+	// Packets that do not answer this query, such as late responses to an
+	// earlier one or spoofed responses, are skipped until the deadline.
+	buf := make([]byte, dnsUDPSize)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		resp := &dnsmessage.Message{}
+		if err := resp.Unpack(buf[:n]); err == nil && dnsResponseMatches(&msg, resp) {
+			return resp, nil
+		}
+	}
+}
+
+// dnsResponseMatches reports whether resp answers query.
+func dnsResponseMatches(query, resp *dnsmessage.Message) bool {
+	if !resp.Response || resp.ID != query.ID || len(resp.Questions) != 1 {
+		return false
+	}
+	q, r := query.Questions[0], resp.Questions[0]
+	return r.Type == q.Type && r.Class == q.Class && strings.EqualFold(r.Name.String(), q.Name.String())
+}
+
+// dnsServersFromOptions returns the nameservers from the servers option as
+// host:port addresses, or those from /etc/resolv.conf.
+func dnsServersFromOptions(opts cmdOptions) ([]string, error) {
+	servers := opts.getList("servers")
+	if len(servers) == 0 {
+		var err error
+		servers, err = resolvConfServers(resolvConfPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for i, server := range servers {
+		servers[i] = dnsServerAddress(server)
+	}
+	return servers, nil
+}
+
+// dnsServerAddress adds the default port to a nameserver given without one,
+// such as "10.0.0.1", "::1" or "[::1]".
+func dnsServerAddress(server string) string {
+	if _, _, err := net.SplitHostPort(server); err == nil {
+		return server
+	}
+	return net.JoinHostPort(strings.Trim(server, "[]"), "53")
+}
+
+func resolvConfServers(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	servers := []string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) > 1 && fields[0] == "nameserver" {
+			servers = append(servers, fields[1])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(servers) == 0 {
+		return nil, fmt.Errorf("No nameservers found in %s", path)
+	}
+	return servers, nil
+}
+
+func dnsFQDN(name string) string {
+	if strings.HasSuffix(name, ".") {
+		return name
+	}
+	return name + "."
+}
+
+func dnsTypeName(t dnsmessage.Type) string {
+	for name, typ := range dnsTypes {
+		if typ == t {
+			return name
+		}
+	}
+	return fmt.Sprintf("TYPE%d", t)
+}
+
+func dnsRCodeName(rcode dnsmessage.RCode) string {
+	switch rcode {
+	case dnsmessage.RCodeSuccess:
+		return "NOERROR"
+	case dnsmessage.RCodeFormatError:
+		return "FORMERR"
+	case dnsmessage.RCodeServerFailure:
+		return "SERVFAIL"
+	case dnsmessage.RCodeNameError:
+		return "NXDOMAIN"
+	case dnsmessage.RCodeNotImplemented:
+		return "NOTIMP"
+	case dnsmessage.RCodeRefused:
+		return "REFUSED"
+	default:
+		return fmt.Sprintf("RCODE%d", rcode)
+	}
+}
+
+func dnsResourceValue(body dnsmessage.ResourceBody) string {
+	switch r := body.(type) {
+	case *dnsmessage.AResource:
+		return net.IP(r.A[:]).String()
+	case *dnsmessage.AAAAResource:
+		return net.IP(r.AAAA[:]).String()
+	case *dnsmessage.CNAMEResource:
+		return r.CNAME.String()
+	case *dnsmessage.MXResource:
+		return fmt.Sprintf("%d %s", r.Pref, r.MX)
+	case *dnsmessage.NSResource:
+		return r.NS.String()
+	case *dnsmessage.PTRResource:
+		return r.PTR.String()
+	case *dnsmessage.SOAResource:
+		return fmt.Sprintf("%s %s %d %d %d %d %d", r.NS, r.MBox, r.Serial, r.Refresh, r.Retry, r.Expire, r.MinTTL)
+	case *dnsmessage.SRVResource:
+		return fmt.Sprintf("%d %d %d %s", r.Priority, r.Weight, r.Port, r.Target)
+	case *dnsmessage.TXTResource:
+		return strings.Join(r.TXT, "")
+	case *dnsmessage.UnknownResource:
+		return fmt.Sprintf("\\# %d %s", len(r.Data), hex.EncodeToString(r.Data))
+	default:
+		return ""
+	}
+}
+
+// dnsResultSignature identifies a response by its rcode and answers,
+// ignoring order and TTLs, so that resolvers can be compared.
+func dnsResultSignature(r dnsServerResult) string {
+	values := []string{r.RCode}
+	for _, answer := range r.Answers {
+		values = append(values, fmt.Sprintf("%s %s %s", answer.Name, answer.Type, answer.Value))
+	}
+	sort.Strings(values[1:])
+	return strings.Join(values, "\n")
+}
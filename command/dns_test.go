@@ -0,0 +1,299 @@
+package command
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// dnsTestHandler returns the packets to send in reply to query. Over tcp
+// only the last one is sent.
+type dnsTestHandler func(query *dnsmessage.Message, tcp bool) [][]byte
+
+// serveDNS answers queries over udp and tcp on the same local port.
+func serveDNS(t *testing.T, handler dnsTestHandler) string {
+	t.Helper()
+	var l net.Listener
+	var pc net.PacketConn
+	for i := 0; pc == nil; i++ {
+		var err error
+		if l, err = net.Listen("tcp", "127.0.0.1:0"); err != nil {
+			t.Fatal(err)
+		}
+		if pc, err = net.ListenPacket("udp", l.Addr().String()); err != nil {
+			l.Close()
+			if i == 10 {
+				t.Fatal(err)
+			}
+		}
+	}
+	t.Cleanup(func() {
+		l.Close()
+		pc.Close()
+	})
+
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			query := &dnsmessage.Message{}
+			if err := query.Unpack(buf[:n]); err != nil {
+				continue
+			}
+			for _, packet := range handler(query, false) {
+				pc.WriteTo(packet, addr)
+			}
+		}
+	}()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				var length [2]byte
+				if _, err := io.ReadFull(conn, length[:]); err != nil {
+					return
+				}
+				raw := make([]byte, binary.BigEndian.Uint16(length[:]))
+				if _, err := io.ReadFull(conn, raw); err != nil {
+					return
+				}
+				query := &dnsmessage.Message{}
+				if err := query.Unpack(raw); err != nil {
+					return
+				}
+				packets := handler(query, true)
+				if len(packets) == 0 {
+					return
+				}
+				packet := packets[len(packets)-1]
+				binary.BigEndian.PutUint16(length[:], uint16(len(packet)))
+				conn.Write(append(length[:], packet...))
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+// dnsTestResponse packs a response to query with A records for ips.
+func dnsTestResponse(t *testing.T, query *dnsmessage.Message, ips ...string) []byte {
+	t.Helper()
+	resp := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: query.ID, Response: true, Authoritative: true},
+		Questions: query.Questions,
+	}
+	for _, ip := range ips {
+		var a [4]byte
+		copy(a[:], net.ParseIP(ip).To4())
+		resp.Answers = append(resp.Answers, dnsmessage.Resource{
+			Header: dnsmessage.ResourceHeader{Name: query.Questions[0].Name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: 60},
+			Body:   &dnsmessage.AResource{A: a},
+		})
+	}
+	packet, err := resp.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return packet
+}
+
+func runDNSQuery(t *testing.T, args ...string) (dnsQueryResult, error) {
+	t.Helper()
+	results, err := dnsQueryCommand(newTestCmd(t), args...)
+	var result dnsQueryResult
+	if len(results) > 0 {
+		if err := json.Unmarshal([]byte(results[0]), &result); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return result, err
+}
+
+func TestDNSQuerySkipsMismatchedResponses(t *testing.T) {
+	server := serveDNS(t, func(query *dnsmessage.Message, tcp bool) [][]byte {
+		wrongID := *query
+		wrongID.ID++
+		wrongQuestion := *query
+		wrongQuestion.Questions = []dnsmessage.Question{{Name: dnsmessage.MustNewName("other.example.com."), Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET}}
+		return [][]byte{
+			dnsTestResponse(t, &wrongID, "10.0.0.66"),
+			dnsTestResponse(t, &wrongQuestion, "10.0.0.67"),
+			[]byte("garbage"),
+			dnsTestResponse(t, query, "10.0.0.1"),
+		}
+	})
+
+	result, err := runDNSQuery(t, "Example.COM", "a", "--", "servers="+server, "timeout=2s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Results) != 1 {
+		t.Fatalf("results = %+v", result.Results)
+	}
+	r := result.Results[0]
+	want := []dnsAnswer{{Name: "Example.COM.", Type: "A", TTL: 60, Value: "10.0.0.1"}}
+	if r.Error != "" || r.Protocol != "udp" || r.RCode != "NOERROR" || !r.Authoritative || !reflect.DeepEqual(r.Answers, want) {
+		t.Errorf("result = %+v", r)
+	}
+}
+
+func TestDNSQueryTruncatedFallsBackToTCP(t *testing.T) {
+	server := serveDNS(t, func(query *dnsmessage.Message, tcp bool) [][]byte {
+		if !tcp {
+			resp := dnsmessage.Message{
+				Header:    dnsmessage.Header{ID: query.ID, Response: true, Truncated: true},
+				Questions: query.Questions,
+			}
+			packet, _ := resp.Pack()
+			return [][]byte{packet}
+		}
+		return [][]byte{dnsTestResponse(t, query, "10.0.0.1", "10.0.0.2")}
+	})
+
+	result, err := runDNSQuery(t, "example.com", "--", "servers="+server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := result.Results[0]
+	if r.Protocol != "tcp" || r.Truncated || len(r.Answers) != 2 {
+		t.Errorf("result = %+v", r)
+	}
+}
+
+func TestDNSQueryTCPMismatch(t *testing.T) {
+	server := serveDNS(t, func(query *dnsmessage.Message, tcp bool) [][]byte {
+		wrongID := *query
+		wrongID.ID++
+		return [][]byte{dnsTestResponse(t, &wrongID, "10.0.0.1")}
+	})
+
+	_, err := runDNSQuery(t, "example.com", "--", "servers="+server, "protocol=tcp")
+	if _, ok := err.(ErrCommandResponse); !ok {
+		t.Errorf("got %v, want ErrCommandResponse", err)
+	}
+}
+
+func TestDNSQueryConsistency(t *testing.T) {
+	answer := func(ips ...string) dnsTestHandler {
+		return func(query *dnsmessage.Message, tcp bool) [][]byte {
+			return [][]byte{dnsTestResponse(t, query, ips...)}
+		}
+	}
+	a := serveDNS(t, answer("10.0.0.1", "10.0.0.2"))
+	b := serveDNS(t, answer("10.0.0.2", "10.0.0.1"))
+	c := serveDNS(t, answer("10.0.0.3"))
+	silent := serveDNS(t, func(*dnsmessage.Message, bool) [][]byte { return nil })
+
+	result, err := runDNSQuery(t, "example.com", "--", "servers="+a+","+b+","+silent, "timeout=200ms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Consistent || result.Results[2].Error == "" {
+		t.Errorf("result = %+v", result)
+	}
+
+	result, err = runDNSQuery(t, "example.com", "--", "servers="+a+","+c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Consistent {
+		t.Error("different answers reported as consistent")
+	}
+
+	if _, err := runDNSQuery(t, "example.com", "--", "servers="+silent, "timeout=200ms"); err == nil {
+		t.Error("query succeeded without an answer")
+	} else if _, ok := err.(ErrCommandResponse); !ok {
+		t.Errorf("got %v, want ErrCommandResponse", err)
+	}
+}
+
+func TestDNSQueryCancel(t *testing.T) {
+	server := serveDNS(t, func(query *dnsmessage.Message, tcp bool) [][]byte {
+		return nil
+	})
+
+	c := newTestCmd(t)
+	time.AfterFunc(100*time.Millisecond, c.Cancel)
+	start := time.Now()
+	results, err := dnsQueryCommand(c, "example.com", "--", "servers="+server, "timeout=10s")
+	if _, ok := err.(ErrCommandResponse); !ok {
+		t.Fatalf("got %v, want ErrCommandResponse", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("cancelled query took %v", elapsed)
+	}
+	var result dnsQueryResult
+	if err := json.Unmarshal([]byte(results[0]), &result); err != nil {
+		t.Fatal(err)
+	}
+	if result.Results[0].Error != ErrCommandCancelled.Error() {
+		t.Errorf("result = %+v", result.Results[0])
+	}
+}
+
+func TestDNSQueryErrors(t *testing.T) {
+	for _, args := range [][]string{
+		{},
+		{"example.com", "HINFO"},
+		{"example.com", "--", "protocol=quic"},
+		{"example.com", "--", "timeout=soon"},
+	} {
+		if _, err := dnsQueryCommand(newTestCmd(t), args...); err == nil {
+			t.Errorf("dns_query(%q) succeeded", args)
+		}
+	}
+}
+
+func TestDNSServers(t *testing.T) {
+	_, opts := parseArgs([]string{"--", "servers=10.0.0.1, [::1], 10.0.0.2:5353, [fe80::1]:53"})
+	servers, err := dnsServersFromOptions(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"10.0.0.1:53", "[::1]:53", "10.0.0.2:5353", "[fe80::1]:53"}
+	if !reflect.DeepEqual(servers, want) {
+		t.Errorf("servers = %q, want %q", servers, want)
+	}
+
+	for server, want := range map[string]string{
+		"::1":          "[::1]:53",
+		"[::1]":        "[::1]:53",
+		"[::1]:5353":   "[::1]:5353",
+		"ns.local":     "ns.local:53",
+		"ns.local:853": "ns.local:853",
+	} {
+		if got := dnsServerAddress(server); got != want {
+			t.Errorf("dnsServerAddress(%q) = %q, want %q", server, got, want)
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "resolv.conf")
+	conf := "# comment\nsearch example.com\nnameserver 10.0.0.1\nnameserver ::1\noptions ndots:2\n"
+	if err := ioutil.WriteFile(path, []byte(conf), 0644); err != nil {
+		t.Fatal(err)
+	}
+	servers, err = resolvConfServers(path)
+	if err != nil || !reflect.DeepEqual(servers, []string{"10.0.0.1", "::1"}) {
+		t.Errorf("resolvConfServers = %q, %v", servers, err)
+	}
+	if err := ioutil.WriteFile(path, []byte("search example.com\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := resolvConfServers(path); err == nil {
+		t.Error("resolv.conf without nameservers accepted")
+	}
+}
@@ -0,0 +1,324 @@
+package command
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	GithubModeToken = "token"
+	GithubModeApp   = "app"
+	GithubModeOAuth = "oauth"
+
+	defaultGithubAPIURL = "https://api.github.com"
+)
+
+type githubAppInfo struct {
+	ID   int64  `json:"id"`
+	Slug string `json:"slug"`
+	Name string `json:"name"`
+}
+
+type githubInstallation struct {
+	ID      int64 `json:"id"`
+	Account struct {
+		Login string `json:"login"`
+	} `json:"account"`
+}
+
+type githubAuthResult struct {
+	Mode           string            `json:"mode"`
+	APIURL         string            `json:"api_url"`
+	Valid          bool              `json:"valid"`
+	Login          string            `json:"login,omitempty"`
+	Scopes         []string          `json:"scopes,omitempty"`
+	MissingScopes  []string          `json:"missing_scopes,omitempty"`
+	App            *githubAppInfo    `json:"app,omitempty"`
+	Installations  []string          `json:"installations,omitempty"`
+	TokenExpiresAt string            `json:"token_expires_at,omitempty"`
+	Permissions    map[string]string `json:"permissions,omitempty"`
+	Error          string            `json:"error,omitempty"`
+}
+
+func githubAuthCommand(c *GoCmd, args ...string) ([]string, error) {
+	// Should be:
+	// 0: mode: "token", "app" or "oauth"
+	// Options:
+	// url: GitHub or GitHub Enterprise url, defaults to https://api.github.com
+	// token: personal access token (token)
+	// scopes: comma separated scopes the token must have (token)
+	// app_id, private_key: GitHub App id and PEM private key (app)
+	// installation_id: exchanged for an installation token when set (app)
+	// client_id, client_secret: OAuth app credentials (oauth)
+	// ca, insecure, timeout: as for http_check
+	positional, opts := parseArgs(args)
+	if len(positional) < 1 {
+		return nil, ErrMissingArgs
+	}
+	mode := positional[0]
+	apiURL, err := githubAPIURL(opts.get("url", defaultGithubAPIURL))
+	if err != nil {
+		return nil, err
+	}
+	client, err := c.httpClientFromOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	result := githubAuthResult{
+		Mode:   mode,
+		APIURL: apiURL,
+	}
+	switch mode {
+	case GithubModeToken:
+		token := opts.get("token", "")
+		if token == "" {
+			return nil, ErrMissingArgs
+		}
+		err = githubTokenCheck(client, apiURL, token, opts.getList("scopes"), &result)
+
+	case GithubModeApp:
+		appID := opts.get("app_id", "")
+		keyInput := opts.get("private_key", "")
+		if appID == "" || keyInput == "" {
+			return nil, ErrMissingArgs
+		}
+		var jwt string
+		jwt, err = githubAppJWTFromPEM(appID, keyInput)
+		if err != nil {
+			return nil, err
+		}
+		err = githubAppCheck(client, apiURL, jwt, opts.get("installation_id", ""), &result)
+
+	case GithubModeOAuth:
+		clientID := opts.get("client_id", "")
+		clientSecret := opts.get("client_secret", "")
+		if clientID == "" || clientSecret == "" {
+			return nil, ErrMissingArgs
+		}
+		err = githubOAuthAppCheck(client, apiURL, clientID, clientSecret)
+		result.Valid = err == nil
+
+	default:
+		return nil, fmt.Errorf("GitHub auth mode must be one of %q, %q or %q", GithubModeToken, GithubModeApp, GithubModeOAuth)
+	}
+
+	if _, ok := err.(apiError); err != nil && !ok {
+		return nil, err
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	results, jsonErr := jsonResult(result)
+	if jsonErr != nil {
+		return nil, jsonErr
+	}
+	if !result.Valid {
+		errMsg := fmt.Sprintf("GitHub authentication failed: %s", result.Error)
+		return results, ErrCommandResponse{errMsg}
+	}
+	return results, nil
+}
+
+func githubAppAuthCommand(c *GoCmd, args ...string) ([]string, error) {
+	// Should be:
+	// 0: github_type: "github_type_public" or "github_type_enterprise"
+	// 1: github_enterprise_host: "github.replicated.com"
+	// 2: github_enterprise_protocol: "github_enterprise_protocol_http" or "github_enterprise_protocol_https"
+	// 3: github_client_id
+	// 4: github_client_secret
+	if len(args) < 5 {
+		return nil, ErrMissingArgs
+	}
+	githubType := args[0]
+	githubEnterpriseHost := args[1]
+	githubEnterpriseProtocol := args[2]
+	githubClientID := args[3]
+	githubClientSecret := args[4]
+
+	var rawURL string
+	switch githubType {
+	case "github_type_public":
+		rawURL = defaultGithubAPIURL
+	case "github_type_enterprise":
+		protocol := strings.TrimPrefix(githubEnterpriseProtocol, "github_enterprise_protocol_")
+		if protocol != "http" && protocol != "https" {
+			return nil, fmt.Errorf("Unknown github enterprise protocol: %s", githubEnterpriseProtocol)
+		}
+		rawURL = githubEnterpriseHost
+		if !strings.Contains(rawURL, "://") {
+			rawURL = fmt.Sprintf("%s://%s", protocol, rawURL)
+		}
+	default:
+		return nil, fmt.Errorf("Unknown github type: %s", githubType)
+	}
+	apiURL, err := githubAPIURL(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	err = githubOAuthAppCheck(c.httpClient(defaultHTTPTimeout), apiURL, githubClientID, githubClientSecret)
+	if ghErr, ok := err.(apiError); ok {
+		errMsg := "Github app authentication failed."
+		if ghErr.Message != "" {
+			errMsg = fmt.Sprintf("Github app authentication failed: %s", ghErr.Message)
+		}
+		return []string{"false"}, ErrCommandResponse{errMsg}
+	} else if err != nil {
+		return nil, err
+	}
+	return []string{"true"}, nil
+}
+
+// githubAPIURL returns the REST API root for a GitHub or GitHub Enterprise
+// url. Enterprise urls without a path get the /api/v3 prefix.
+func githubAPIURL(rawURL string) (string, error) {
+	u, err := apiBaseURL(rawURL)
+	if err != nil {
+		return "", err
+	}
+	switch strings.ToLower(u.Hostname()) {
+	case "github.com", "api.github.com":
+		return defaultGithubAPIURL, nil
+	}
+	if u.Path == "" {
+		u.Path = "/api/v3"
+	}
+	return u.String(), nil
+}
+
+func githubTokenCheck(client *http.Client, apiURL, token string, required []string, result *githubAuthResult) error {
+	var user struct {
+		Login string `json:"login"`
+	}
+	resp, err := githubRequest(client, "GET", apiURL+"/user", "token "+token, nil, &user)
+	if err != nil {
+		return err
+	}
+	result.Valid = true
+	result.Login = user.Login
+
+	// Fine-grained tokens do not report scopes.
+	if header := resp.Header.Get("X-OAuth-Scopes"); header != "" {
+		result.Scopes = splitList(header)
+	}
+	for _, scope := range required {
+		if !containsString(result.Scopes, scope) {
+			result.MissingScopes = append(result.MissingScopes, scope)
+		}
+	}
+	if len(result.MissingScopes) > 0 {
+		result.Valid = false
+		return apiError{Message: fmt.Sprintf("token is missing scopes %s", strings.Join(result.MissingScopes, ", "))}
+	}
+	return nil
+}
+
+func githubAppCheck(client *http.Client, apiURL, jwt, installationID string, result *githubAuthResult) error {
+	auth := "Bearer " + jwt
+	result.App = &githubAppInfo{}
+	if _, err := githubRequest(client, "GET", apiURL+"/app", auth, nil, result.App); err != nil {
+		result.App = nil
+		return err
+	}
+
+	if installationID == "" {
+		installations := []githubInstallation{}
+		if _, err := githubRequest(client, "GET", apiURL+"/app/installations", auth, nil, &installations); err != nil {
+			return err
+		}
+		result.Installations = []string{}
+		for _, installation := range installations {
+			result.Installations = append(result.Installations, fmt.Sprintf("%d:%s", installation.ID, installation.Account.Login))
+		}
+		result.Valid = true
+		return nil
+	}
+
+	var token struct {
+		ExpiresAt   string            `json:"expires_at"`
+		Permissions map[string]string `json:"permissions"`
+	}
+	tokenURL := fmt.Sprintf("%s/app/installations/%s/access_tokens", apiURL, url.PathEscape(installationID))
+	if _, err := githubRequest(client, "POST", tokenURL, auth, nil, &token); err != nil {
+		return err
+	}
+	result.Valid = true
+	result.TokenExpiresAt = token.ExpiresAt
+	result.Permissions = token.Permissions
+	return nil
+}
+
+// githubOAuthAppCheck validates OAuth app credentials by checking a token
+// that cannot exist. GitHub answers 404 when the client credentials are valid
+// and 401 when they are not.
+func githubOAuthAppCheck(client *http.Client, apiURL, clientID, clientSecret string) error {
+	checkURL := fmt.Sprintf("%s/applications/%s/token", apiURL, url.PathEscape(clientID))
+	basic := base64.StdEncoding.EncodeToString([]byte(clientID + ":" + clientSecret))
+	body := map[string]string{"access_token": "notatoken"}
+	_, err := githubRequest(client, "POST", checkURL, "Basic "+basic, body, nil)
+	if ghErr, ok := err.(apiError); ok && ghErr.StatusCode == http.StatusNotFound {
+		return nil
+	} else if err == nil {
+		return errors.New("Unexpected successful response checking an invalid token")
+	}
+	return err
+}
+
+func githubAppJWTFromPEM(appID, keyInput string) (string, error) {
+	keyPEM, err := decodePEMInput(keyInput)
+	if err != nil {
+		return "", err
+	}
+	key, err := parsePrivateKey(keyPEM)
+	if err != nil {
+		return "", err
+	}
+	return githubAppJWT(appID, key, time.Now())
+}
+
+// githubAppJWT returns the RS256 signed JWT a GitHub App authenticates with.
+// The issue time is backdated to allow for clock drift.
+func githubAppJWT(appID string, key crypto.Signer, now time.Time) (string, error) {
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return "", errors.New("GitHub App private key must be an RSA key")
+	}
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(map[string]interface{}{
+		"iat": now.Add(-60 * time.Second).Unix(),
+		"exp": now.Add(9 * time.Minute).Unix(),
+		"iss": appID,
+	})
+	if err != nil {
+		return "", err
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// githubRequest sends a request to the GitHub API.
+func githubRequest(client *http.Client, method, reqURL, authorization string, body, out interface{}) (*http.Response, error) {
+	header := http.Header{}
+	header.Set("Accept", "application/vnd.github+json")
+	header.Set("Authorization", authorization)
+	return apiRequest(client, method, reqURL, header, body, out)
+}
@@ -0,0 +1,270 @@
+package command
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeGithub serves the parts of the GitHub API used by the github commands
+// under /api/v3.
+func fakeGithub(t *testing.T, appKey *rsa.PublicKey) *httptest.Server {
+	t.Helper()
+	writeJSON := func(w http.ResponseWriter, status int, v interface{}) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(v)
+	}
+	validJWT := func(r *http.Request) bool {
+		parts := strings.Split(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "), ".")
+		if len(parts) != 3 {
+			return false
+		}
+		signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+		if err != nil {
+			return false
+		}
+		digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+		if rsa.VerifyPKCS1v15(appKey, crypto.SHA256, digest[:], signature) != nil {
+			return false
+		}
+		var claims struct {
+			Iss string `json:"iss"`
+			Iat int64  `json:"iat"`
+			Exp int64  `json:"exp"`
+		}
+		data, _ := base64.RawURLEncoding.DecodeString(parts[1])
+		json.Unmarshal(data, &claims)
+		now := time.Now().Unix()
+		return claims.Iss == "42" && claims.Iat < now && claims.Exp > now && claims.Exp-claims.Iat <= 600
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v3/user", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "token good" {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"message": "Bad credentials"})
+			return
+		}
+		w.Header().Set("X-OAuth-Scopes", "repo, read:org")
+		writeJSON(w, http.StatusOK, map[string]string{"login": "octocat"})
+	})
+	mux.HandleFunc("/api/v3/app", func(w http.ResponseWriter, r *http.Request) {
+		if !validJWT(r) {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"message": "A JSON web token could not be decoded"})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"id": 42, "slug": "test-app", "name": "Test App"})
+	})
+	mux.HandleFunc("/api/v3/app/installations", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, []map[string]interface{}{{"id": 7, "account": map[string]string{"login": "acme"}}})
+	})
+	mux.HandleFunc("/api/v3/app/installations/7/access_tokens", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || !validJWT(r) {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"message": "Bad credentials"})
+			return
+		}
+		writeJSON(w, http.StatusCreated, map[string]interface{}{
+			"token":       "ghs_x",
+			"expires_at":  "2030-01-01T00:00:00Z",
+			"permissions": map[string]string{"contents": "read"},
+		})
+	})
+	mux.HandleFunc("/api/v3/applications/client/token", func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		json.NewDecoder(r.Body).Decode(&body)
+		if user, password, ok := r.BasicAuth(); !ok || user != "client" || password != "secret" || body["access_token"] == "" {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"message": "Requires authentication"})
+			return
+		}
+		writeJSON(w, http.StatusNotFound, map[string]string{"message": "Not Found"})
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func newGithubAppKey(t *testing.T) (*rsa.PrivateKey, string) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	return key, string(keyPEM)
+}
+
+func runGithubAuth(t *testing.T, args ...string) (githubAuthResult, error) {
+	t.Helper()
+	results, err := githubAuthCommand(newTestCmd(t), args...)
+	var result githubAuthResult
+	if len(results) > 0 {
+		if err := json.Unmarshal([]byte(results[0]), &result); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return result, err
+}
+
+func TestGithubAuthToken(t *testing.T) {
+	server := fakeGithub(t, nil)
+	apiURL := server.URL + "/api/v3"
+
+	result, err := runGithubAuth(t, "token", "--", "url="+apiURL, "token=good", "scopes=repo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Valid || result.Login != "octocat" || len(result.Scopes) != 2 || result.APIURL != apiURL {
+		t.Errorf("result = %+v", result)
+	}
+
+	result, err = runGithubAuth(t, "token", "--", "url="+apiURL, "token=good", "scopes=repo,admin:org,workflow")
+	if _, ok := err.(ErrCommandResponse); !ok {
+		t.Fatalf("got %v, want ErrCommandResponse", err)
+	}
+	if result.Valid || strings.Join(result.MissingScopes, ",") != "admin:org,workflow" {
+		t.Errorf("result = %+v", result)
+	}
+
+	result, err = runGithubAuth(t, "token", "--", "url="+apiURL, "token=bad")
+	if _, ok := err.(ErrCommandResponse); !ok {
+		t.Fatalf("got %v, want ErrCommandResponse", err)
+	}
+	if result.Valid || result.Error != "HTTP status code 401: Bad credentials" {
+		t.Errorf("result = %+v", result)
+	}
+}
+
+func TestGithubAuthApp(t *testing.T) {
+	key, keyPEM := newGithubAppKey(t)
+	server := fakeGithub(t, &key.PublicKey)
+	apiURL := server.URL + "/api/v3"
+
+	result, err := runGithubAuth(t, "app", "--", "url="+apiURL, "app_id=42", "private_key="+keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Valid || result.App == nil || result.App.Slug != "test-app" || strings.Join(result.Installations, ",") != "7:acme" {
+		t.Errorf("result = %+v", result)
+	}
+
+	result, err = runGithubAuth(t, "app", "--", "url="+apiURL, "app_id=42", "private_key="+keyPEM, "installation_id=7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Valid || result.TokenExpiresAt != "2030-01-01T00:00:00Z" || result.Permissions["contents"] != "read" {
+		t.Errorf("result = %+v", result)
+	}
+
+	// The JWT is signed for app 42, so another app id fails.
+	result, err = runGithubAuth(t, "app", "--", "url="+apiURL, "app_id=43", "private_key="+keyPEM)
+	if _, ok := err.(ErrCommandResponse); !ok || result.Valid || result.App != nil {
+		t.Errorf("wrong app id: %v, %+v", err, result)
+	}
+
+	ecKey, err := certCommand(nil, "--", "key=ecdsa-p256", "format=pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := runGithubAuth(t, "app", "--", "url="+apiURL, "app_id=42", "private_key="+ecKey[0]); err == nil {
+		t.Error("accepted an ECDSA app key")
+	}
+}
+
+func TestGithubAuthOAuth(t *testing.T) {
+	server := fakeGithub(t, nil)
+	apiURL := server.URL + "/api/v3"
+
+	if result, err := runGithubAuth(t, "oauth", "--", "url="+apiURL, "client_id=client", "client_secret=secret"); err != nil || !result.Valid {
+		t.Errorf("valid credentials: %v, %+v", err, result)
+	}
+	result, err := runGithubAuth(t, "oauth", "--", "url="+apiURL, "client_id=client", "client_secret=wrong")
+	if _, ok := err.(ErrCommandResponse); !ok || result.Valid {
+		t.Errorf("wrong secret: %v, %+v", err, result)
+	}
+}
+
+func TestGithubAuthErrors(t *testing.T) {
+	for _, args := range [][]string{
+		{},
+		{"token"},
+		{"app", "--", "app_id=42"},
+		{"oauth", "--", "client_id=client"},
+		{"password"},
+		{"token", "--", "token=x", "url=ftp://github.example.com"},
+	} {
+		if _, err := githubAuthCommand(newTestCmd(t), args...); err == nil {
+			t.Errorf("github_auth(%q) succeeded", args)
+		}
+	}
+}
+
+func TestGithubAppAuth(t *testing.T) {
+	server := fakeGithub(t, nil)
+	u, _ := url.Parse(server.URL)
+
+	results, err := githubAppAuthCommand(newTestCmd(t), "github_type_enterprise", u.Host, "github_enterprise_protocol_http", "client", "secret")
+	if err != nil || len(results) != 1 || results[0] != "true" {
+		t.Errorf("valid credentials: %q, %v", results, err)
+	}
+
+	results, err = githubAppAuthCommand(newTestCmd(t), "github_type_enterprise", u.Host, "github_enterprise_protocol_http", "client", "wrong")
+	if err == nil || err.Error() != "Github app authentication failed: Requires authentication" || results[0] != "false" {
+		t.Errorf("wrong secret: %q, %v", results, err)
+	}
+
+	for _, args := range [][]string{
+		{"github_type_public", "", "", "client"},
+		{"github_type_cloud", "", "", "client", "secret"},
+		{"github_type_enterprise", u.Host, "github_enterprise_protocol_ftp", "client", "secret"},
+	} {
+		if _, err := githubAppAuthCommand(newTestCmd(t), args...); err == nil {
+			t.Errorf("github_app_auth(%q) succeeded", args)
+		}
+	}
+}
+
+func TestGithubAPIURL(t *testing.T) {
+	for raw, want := range map[string]string{
+		"https://github.com":                     defaultGithubAPIURL,
+		"api.github.com":                         defaultGithubAPIURL,
+		"github.example.com":                     "https://github.example.com/api/v3",
+		"http://github.example.com/":             "http://github.example.com/api/v3",
+		"https://example.com/github/api/v3/":     "https://example.com/github/api/v3",
+		"https://github.example.com:8443/api/v3": "https://github.example.com:8443/api/v3",
+	} {
+		got, err := githubAPIURL(raw)
+		if err != nil || got != want {
+			t.Errorf("githubAPIURL(%q) = %q, %v, want %q", raw, got, err, want)
+		}
+	}
+}
+
+func TestGithubAppJWT(t *testing.T) {
+	key, _ := newGithubAppKey(t)
+	now := time.Unix(1700000000, 0)
+	jwt, err := githubAppJWT("42", key, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(jwt, ".")
+	if len(parts) != 3 {
+		t.Fatalf("jwt = %q", jwt)
+	}
+	claims, _ := base64.RawURLEncoding.DecodeString(parts[1])
+	want := fmt.Sprintf(`{"exp":%d,"iat":%d,"iss":"42"}`, now.Unix()+540, now.Unix()-60)
+	if string(claims) != want {
+		t.Errorf("claims = %s, want %s", claims, want)
+	}
+}
@@ -0,0 +1,99 @@
+package command
+
+import (
+	"fmt"
+	"net/http"
+)
+
+const (
+	GitlabModeToken = "token"
+	GitlabModeOAuth = "oauth"
+
+	defaultGitlabURL = "https://gitlab.com"
+)
+
+func gitlabAuthCommand(c *GoCmd, args ...string) ([]string, error) {
+	// Should be:
+	// 0: mode: "token" for personal, project or group access tokens, or "oauth" for OAuth access tokens
+	// Options:
+	// url: GitLab url, defaults to https://gitlab.com
+	// token: access token
+	// scopes: comma separated scopes the token must have
+	// ca, insecure, timeout: as for http_check
+	positional, opts := parseArgs(args)
+	if len(positional) < 1 {
+		return nil, ErrMissingArgs
+	}
+	mode := positional[0]
+	token := opts.get("token", "")
+	if token == "" {
+		return nil, ErrMissingArgs
+	}
+	base, err := apiBaseURL(opts.get("url", defaultGitlabURL))
+	if err != nil {
+		return nil, err
+	}
+	client, err := c.httpClientFromOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	header := http.Header{}
+	switch mode {
+	case GitlabModeToken:
+		header.Set("PRIVATE-TOKEN", token)
+	case GitlabModeOAuth:
+		header.Set("Authorization", "Bearer "+token)
+	default:
+		return nil, fmt.Errorf("GitLab auth mode must be one of %q or %q", GitlabModeToken, GitlabModeOAuth)
+	}
+
+	result := scmAuthResult{
+		Provider: "GitLab",
+		APIURL:   base.String() + "/api/v4",
+	}
+	err = gitlabTokenCheck(client, base.String(), mode, header, opts.getList("scopes"), &result)
+	return scmAuthResponse(&result, err)
+}
+
+func gitlabTokenCheck(client *http.Client, baseURL, mode string, header http.Header, required []string, result *scmAuthResult) error {
+	var user struct {
+		Username string `json:"username"`
+		Name     string `json:"name"`
+	}
+	if _, err := apiRequest(client, "GET", result.APIURL+"/user", header, nil, &user); err != nil {
+		return err
+	}
+	result.Valid = true
+	result.Login = user.Username
+	result.Name = user.Name
+
+	if mode == GitlabModeOAuth {
+		var info struct {
+			Scope []string `json:"scope"`
+		}
+		if _, err := apiRequest(client, "GET", baseURL+"/oauth/token/info", header, nil, &info); err != nil {
+			return err
+		}
+		result.Scopes = info.Scope
+	} else {
+		var token struct {
+			Name      string   `json:"name"`
+			Scopes    []string `json:"scopes"`
+			ExpiresAt string   `json:"expires_at"`
+		}
+		// GitLab older than 15.5 has no self endpoint, so scopes are only
+		// reported when it exists.
+		_, err := apiRequest(client, "GET", result.APIURL+"/personal_access_tokens/self", header, nil, &token)
+		if apiErr, ok := err.(apiError); ok && apiErr.StatusCode == http.StatusNotFound && len(required) == 0 {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		result.TokenName = token.Name
+		result.Scopes = token.Scopes
+		result.ExpiresAt = token.ExpiresAt
+	}
+
+	return requireValues(result, result.Scopes, required, "scopes")
+}
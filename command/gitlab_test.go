@@ -0,0 +1,187 @@
+package command
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// fakeGitlab serves the parts of the GitLab API used by gitlab_auth. The
+// personal access token is "good" with the api and read_user scopes and the
+// OAuth token is "oauth-good" with the read_user scope. Without selfEndpoint
+// it behaves like GitLab older than 15.5.
+func fakeGitlab(selfEndpoint bool) http.Handler {
+	writeJSON := func(w http.ResponseWriter, status int, v interface{}) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(v)
+	}
+	authorized := func(r *http.Request) bool {
+		return r.Header.Get("PRIVATE-TOKEN") == "good" || r.Header.Get("Authorization") == "Bearer oauth-good"
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v4/user", func(w http.ResponseWriter, r *http.Request) {
+		if !authorized(r) {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"message": "401 Unauthorized"})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"username": "jdoe", "name": "Jane Doe"})
+	})
+	mux.HandleFunc("/api/v4/personal_access_tokens/self", func(w http.ResponseWriter, r *http.Request) {
+		if !selfEndpoint {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "404 Not Found"})
+			return
+		}
+		if r.Header.Get("PRIVATE-TOKEN") != "good" {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"message": "401 Unauthorized"})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"name":       "deploy",
+			"scopes":     []string{"api", "read_user"},
+			"expires_at": "2030-01-01",
+		})
+	})
+	mux.HandleFunc("/oauth/token/info", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer oauth-good" {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_token", "error_description": "The access token is invalid"})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"scope": []string{"read_user"}})
+	})
+	return mux
+}
+
+func runGitlabAuth(t *testing.T, c *GoCmd, args ...string) (scmAuthResult, error) {
+	t.Helper()
+	results, err := gitlabAuthCommand(c, args...)
+	var result scmAuthResult
+	if len(results) > 0 {
+		if err := json.Unmarshal([]byte(results[0]), &result); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return result, err
+}
+
+func TestGitlabAuthToken(t *testing.T) {
+	server := httptest.NewServer(fakeGitlab(true))
+	defer server.Close()
+
+	result, err := runGitlabAuth(t, newTestCmd(t), "token", "--", "url="+server.URL, "token=good", "scopes=api")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Valid || result.Provider != "GitLab" || result.APIURL != server.URL+"/api/v4" ||
+		result.Login != "jdoe" || result.Name != "Jane Doe" || result.TokenName != "deploy" ||
+		strings.Join(result.Scopes, ",") != "api,read_user" || result.ExpiresAt != "2030-01-01" {
+		t.Errorf("result = %+v", result)
+	}
+
+	result, err = runGitlabAuth(t, newTestCmd(t), "token", "--", "url="+server.URL, "token=good", "scopes=api,write_repository,sudo")
+	if _, ok := err.(ErrCommandResponse); !ok {
+		t.Fatalf("got %v, want ErrCommandResponse", err)
+	}
+	if result.Valid || strings.Join(result.Missing, ",") != "write_repository,sudo" {
+		t.Errorf("result = %+v", result)
+	}
+
+	result, err = runGitlabAuth(t, newTestCmd(t), "token", "--", "url="+server.URL, "token=bad")
+	if _, ok := err.(ErrCommandResponse); !ok {
+		t.Fatalf("got %v, want ErrCommandResponse", err)
+	}
+	if result.Valid || result.Error != "HTTP status code 401: 401 Unauthorized" {
+		t.Errorf("result = %+v", result)
+	}
+}
+
+func TestGitlabAuthTokenWithoutSelfEndpoint(t *testing.T) {
+	server := httptest.NewServer(fakeGitlab(false))
+	defer server.Close()
+
+	// Without required scopes the user lookup is enough.
+	result, err := runGitlabAuth(t, newTestCmd(t), "token", "--", "url="+server.URL, "token=good")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Valid || result.Login != "jdoe" || len(result.Scopes) != 0 {
+		t.Errorf("result = %+v", result)
+	}
+
+	// Required scopes cannot be checked, so the check fails.
+	result, err = runGitlabAuth(t, newTestCmd(t), "token", "--", "url="+server.URL, "token=good", "scopes=api")
+	if _, ok := err.(ErrCommandResponse); !ok {
+		t.Fatalf("got %v, want ErrCommandResponse", err)
+	}
+	if result.Valid || !strings.HasPrefix(result.Error, "HTTP status code 404") {
+		t.Errorf("result = %+v", result)
+	}
+}
+
+func TestGitlabAuthOAuth(t *testing.T) {
+	server := httptest.NewServer(fakeGitlab(true))
+	defer server.Close()
+
+	result, err := runGitlabAuth(t, newTestCmd(t), "oauth", "--", "url="+server.URL, "token=oauth-good", "scopes=read_user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Valid || result.Login != "jdoe" || strings.Join(result.Scopes, ",") != "read_user" || result.TokenName != "" {
+		t.Errorf("result = %+v", result)
+	}
+
+	result, err = runGitlabAuth(t, newTestCmd(t), "oauth", "--", "url="+server.URL, "token=oauth-good", "scopes=api")
+	if _, ok := err.(ErrCommandResponse); !ok {
+		t.Fatalf("got %v, want ErrCommandResponse", err)
+	}
+	if result.Valid || strings.Join(result.Missing, ",") != "api" {
+		t.Errorf("result = %+v", result)
+	}
+
+	// A personal access token is not an OAuth token.
+	result, err = runGitlabAuth(t, newTestCmd(t), "oauth", "--", "url="+server.URL, "token=good")
+	if _, ok := err.(ErrCommandResponse); !ok {
+		t.Fatalf("got %v, want ErrCommandResponse", err)
+	}
+	if result.Valid {
+		t.Errorf("result = %+v", result)
+	}
+}
+
+func TestGitlabAuthDefaultURL(t *testing.T) {
+	pki := newTestPKI(t)
+	proxy := serveInterceptingProxy(t, pki, fakeGitlab(true), "gitlab.com")
+	c := newProxiedCmd(t, CmdConfig{ProxyURL: proxy.URL})
+
+	result, err := runGitlabAuth(t, c, "token", "--", "token=good", "ca="+pki.caPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Valid || result.APIURL != "https://gitlab.com/api/v4" || result.Login != "jdoe" {
+		t.Errorf("result = %+v", result)
+	}
+	if len(proxy.connected()) == 0 {
+		t.Error("no tunnel was opened through the proxy")
+	}
+	for _, target := range proxy.connected() {
+		if target != "gitlab.com:443" {
+			t.Errorf("connected to %s, want gitlab.com:443", target)
+		}
+	}
+}
+
+func TestGitlabAuthErrors(t *testing.T) {
+	c := newTestCmd(t)
+	if _, err := gitlabAuthCommand(c); err != ErrMissingArgs {
+		t.Errorf("no mode: got %v, want ErrMissingArgs", err)
+	}
+	if _, err := gitlabAuthCommand(c, "token"); err != ErrMissingArgs {
+		t.Errorf("no token: got %v, want ErrMissingArgs", err)
+	}
+	if _, err := gitlabAuthCommand(c, "password", "--", "token=x"); err == nil || !strings.Contains(err.Error(), "auth mode") {
+		t.Errorf("bad mode: got %v", err)
+	}
+}
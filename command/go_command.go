@@ -1,46 +1,51 @@
 package command
 
 import (
-	"encoding/base64"
-	"encoding/json"
-	"errors"
+	"context"
 	"fmt"
-	"io/ioutil"
-	"math/rand"
 	"net"
 	"net/http"
 	"strconv"
 	"strings"
+	"sync"
 
-	"github.com/awslabs/aws-sdk-go/aws"
-	"github.com/awslabs/aws-sdk-go/aws/credentials"
-	"github.com/awslabs/aws-sdk-go/service/ec2"
-	"github.com/awslabs/aws-sdk-go/service/s3"
-	"github.com/awslabs/aws-sdk-go/service/sqs"
 	"github.com/fsouza/go-dockerclient"
 )
 
 type goCommandFunc func(c *GoCmd, args ...string) ([]string, error)
 
-const (
-	AWSServiceEC2 = "ec2"
-	AWSServiceS3  = "s3"
-	AWSServiceSQS = "sqs"
-)
-
 var (
-	randCharset = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ_-0123456789")
-
 	goCommands = map[string]goCommandFunc{
-		"cert":             certCommand,
-		"random":           randomCommand,
-		"echo":             echoCommand,
-		"publicip":         publicIPCommand,
-		"github_app_auth":  githubAppAuthCommand,
-		"aws_auth":         awsAuthCommand,
-		"resolve_host":     resolveHostCommand,
-		"tcp_port_accept":  tcpPortAccept,
-		"http_status_code": httpStatusCode,
+		"cert":              certCommand,
+		"ca_create":         caCreateCommand,
+		"cert_issue":        certIssueCommand,
+		"cert_sign_csr":     certSignCSRCommand,
+		"cert_inspect":      certInspectCommand,
+		"ssh_keygen":        sshKeygenCommand,
+		"random":            randomCommand,
+		"echo":              echoCommand,
+		"publicip":          publicIPCommand,
+		"github_app_auth":   githubAppAuthCommand,
+		"github_auth":       githubAuthCommand,
+		"gitlab_auth":       gitlabAuthCommand,
+		"bitbucket_auth":    bitbucketAuthCommand,
+		"aws_auth":          awsAuthCommand,
+		"s3_bucket_check":   s3BucketCheckCommand,
+		"sns_publish_check": snsPublishCheckCommand,
+		"registry_auth":     registryAuthCommand,
+		"resolve_host":      resolveHostCommand,
+		"dns_query":         dnsQueryCommand,
+		"reverse_dns":       reverseDNSCommand,
+		"tcp_port_accept":   tcpPortAccept,
+		"tcp_check":         tcpCheckCommand,
+		"port_available":    portAvailableCommand,
+		"http_status_code":  httpStatusCode,
+		"http_check":        httpCheckCommand,
+		"proxy_check":       proxyCheckCommand,
+		"ldap_check":        ldapCheckCommand,
+		"smtp_check":        smtpCheckCommand,
+		"tls_check":         tlsCheckCommand,
+		"local_ips":         localIPsCommand,
 	}
 )
 
@@ -48,56 +53,47 @@ type GoCmd struct {
 	Fn           goCommandFunc
 	config       CmdConfig
 	dockerClient *docker.Client
+	runner       *Runner
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu         sync.Mutex
+	transports []*http.Transport
 }
 
+// Run runs the command. Its context is done and the idle connections of its
+// HTTP transports are closed once Run returns.
 func (c *GoCmd) Run(args ...string) ([]string, error) {
+	defer c.closeTransports()
+	defer c.Cancel()
 	return c.Fn(c, args...)
 }
 
-func NewGoCmd(op string, config CmdConfig, dockerClient *docker.Client) (*GoCmd, error) {
-	fn, exists := goCommands[op]
-	if !exists {
-		return nil, ErrCommandNotFound
+// Cancel aborts the command. Connections made through c.dial are closed and
+// new ones fail with ErrCommandCancelled.
+func (c *GoCmd) Cancel() {
+	if c.cancel != nil {
+		c.cancel()
 	}
-	return &GoCmd{fn, config, dockerClient}, nil
 }
 
-func certCommand(c *GoCmd, args ...string) ([]string, error) {
-	cmd, err := NewContainerCmd("cert", c.config, c.dockerClient)
-	if err != nil {
-		return nil, err
+// context returns the context of the command, which is done when it is
+// cancelled.
+func (c *GoCmd) context() context.Context {
+	if c.ctx == nil {
+		return context.Background()
 	}
-	result, err := cmd.Run(args...)
-	if err != nil {
-		return result, err
-	}
-	results := strings.SplitAfter(result[0], "-----END RSA PRIVATE KEY-----")
-	for i, result := range results {
-		result := strings.TrimSpace(result)
-		results[i] = base64.StdEncoding.EncodeToString([]byte(result))
-	}
-	return results, nil
-}
-
-func randomCommand(c *GoCmd, args ...string) ([]string, error) {
-	length := 16
-	if len(args) > 0 {
-		var err error
-		length, err = strconv.Atoi(args[0])
-		if err != nil {
-			return nil, err
-		}
-	}
-	str := randSeq(length)
-	return []string{str}, nil
+	return c.ctx
 }
 
-func randSeq(length int) string {
-	b := make([]rune, length)
-	for i := range b {
-		b[i] = randCharset[rand.Intn(len(randCharset))]
+func NewGoCmd(op string, config CmdConfig, dockerClient *docker.Client) (*GoCmd, error) {
+	fn, exists := goCommands[op]
+	if !exists {
+		return nil, ErrCommandNotFound
 	}
-	return string(b)
+	ctx, cancel := context.WithCancel(context.Background())
+	return &GoCmd{Fn: fn, config: config, dockerClient: dockerClient, ctx: ctx, cancel: cancel}, nil
 }
 
 func echoCommand(c *GoCmd, args ...string) ([]string, error) {
@@ -105,159 +101,6 @@ func echoCommand(c *GoCmd, args ...string) ([]string, error) {
 	return []string{result}, nil
 }
 
-func publicIPCommand(c *GoCmd, args ...string) ([]string, error) {
-	urls := []string{
-		"http://ipecho.net/plain",
-		"http://ip.appspot.com",
-		"http://whatismyip.akamai.com",
-	}
-
-	done := make(chan string, len(urls))
-	errs := make(chan bool, len(urls))
-	for _, url := range urls {
-		go func(url string) {
-			resp, err := http.Get(url)
-			if err != nil {
-				errs <- true
-				return
-			}
-
-			body, err := ioutil.ReadAll(resp.Body)
-			if err != nil {
-				errs <- true
-				return
-			}
-
-			ipStr := strings.TrimSpace(string(body))
-			if ip := net.ParseIP(ipStr); ip != nil {
-				done <- ipStr
-			} else {
-				errs <- true
-			}
-		}(url)
-	}
-
-	errCount := 0
-	for {
-		select {
-		case result := <-done:
-			return []string{result}, nil
-		case <-errs:
-			errCount++
-			if errCount == len(urls) {
-				return nil, errors.New("Error contacting publicip servers.")
-			}
-		}
-	}
-}
-
-func githubAppAuthCommand(c *GoCmd, args ...string) ([]string, error) {
-	// Should be:
-	// 0: github_type: "github_type_public" or "github_type_enterprise"
-	// 1: github_enterprise_host: "github.replicated.com"
-	// 2: github_enterprise_protocol: "github_enterprise_protocol_http" or "github_enterprise_protocol_https"
-	// 3: github_client_id
-	// 4: github_client_secret
-	if len(args) < 5 {
-		return nil, ErrMissingArgs
-	}
-	githubType := args[0]
-	githubEnterpriseHost := args[1]
-	githubEnterpriseProtocol := args[2]
-	githubClientID := args[3]
-	githubClientSecret := args[4]
-
-	var protocol, endpoint string
-	switch githubType {
-	case "github_type_public":
-		protocol = "https"
-		endpoint = "api.github.com"
-	case "github_type_enterprise":
-		protocol = strings.Split(githubEnterpriseProtocol, "_")[3]
-		cleanedHost := strings.Split(githubEnterpriseHost, "/")[0]
-		endpoint = fmt.Sprintf("%s/api/v3", cleanedHost)
-	default:
-		return nil, fmt.Errorf("Unknown github type: %s", githubType)
-	}
-
-	testURL := fmt.Sprintf("%s://%s/applications/%s/tokens/notatoken", protocol, endpoint, githubClientID)
-	req, err := http.NewRequest("GET", testURL, nil)
-	if err != nil {
-		return nil, err
-	}
-	req.SetBasicAuth(githubClientID, githubClientSecret)
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode == 404 {
-		// Yes, 404 means it's working.
-		return []string{"true"}, nil
-	}
-
-	errMsg := "Github app authentication failed."
-
-	if body, err := ioutil.ReadAll(resp.Body); err == nil {
-		var data map[string]interface{}
-		if err := json.Unmarshal(body, &data); err == nil {
-			if msg, ok := data["message"]; ok {
-				errMsg = fmt.Sprintf("Github app authentication failed: %s", msg)
-			}
-		}
-	}
-
-	return []string{"false"}, ErrCommandResponse{errMsg}
-}
-
-func awsAuthCommand(c *GoCmd, args ...string) ([]string, error) {
-	// Should be:
-	// 0: aws_access_key_id
-	// 1: aws_secret_access_key
-	// 2: aws_service
-	if len(args) < 3 {
-		return nil, ErrMissingArgs
-	}
-	awsAccessKeyID := args[0]
-	awsSecretAccessKey := args[1]
-	awsService := args[2]
-
-	creds := credentials.NewStaticCredentials(awsAccessKeyID, awsSecretAccessKey, "")
-	config := &aws.Config{
-		Region:      "us-east-1",
-		Credentials: creds,
-	}
-
-	var err error
-
-	switch awsService {
-	case AWSServiceEC2:
-		svc := ec2.New(config)
-		_, err = svc.DescribeRegions(nil)
-
-	case AWSServiceS3:
-		svc := s3.New(config)
-		_, err = svc.ListBuckets(nil)
-
-	case AWSServiceSQS:
-		svc := sqs.New(config)
-		_, err = svc.ListQueues(nil)
-
-	default:
-		return nil, errors.New("AWS service must be one of \"ec2\", \"s3\" or \"sqs\"")
-	}
-
-	if awserr := aws.Error(err); awserr != nil {
-		errMsg := fmt.Sprintf("AWS authentication failed: %v", awserr)
-		return []string{"false"}, ErrCommandResponse{errMsg}
-	} else if err != nil {
-		return nil, err
-	}
-
-	return []string{"true"}, nil
-}
-
 func resolveHostCommand(c *GoCmd, args ...string) ([]string, error) {
 	if len(args) < 1 {
 		return nil, ErrMissingArgs
@@ -279,12 +122,28 @@ func resolveHostCommand(c *GoCmd, args ...string) ([]string, error) {
 }
 
 func tcpPortAccept(c *GoCmd, args ...string) ([]string, error) {
-	if len(args) < 2 {
+	// Should be:
+	// 0: host
+	// 1: port
+	// Options:
+	// timeout: connect timeout
+	// proxy: connect through the configured proxy, defaults to false
+	positional, opts := parseArgs(args)
+	if len(positional) < 2 {
 		return nil, ErrMissingArgs
 	}
+	timeout, err := opts.getDuration("timeout", defaultDialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	proxy, err := opts.getBool("proxy", false)
+	if err != nil {
+		return nil, err
+	}
 
-	if _, err := net.Dial("tcp", fmt.Sprintf("%s:%s", args[0], args[1])); err != nil {
-		return []string{"false"}, ErrCommandResponse{err.Error()}
+	result := c.tcpCheck(net.JoinHostPort(positional[0], positional[1]), timeout, proxy, false, 0)
+	if !result.Connected {
+		return []string{"false"}, ErrCommandResponse{result.Error}
 	}
 	return []string{"true"}, nil
 }
@@ -298,7 +157,7 @@ func httpStatusCode(c *GoCmd, args ...string) ([]string, error) {
 	if err != nil {
 		return nil, err
 	}
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := c.httpClient(defaultHTTPTimeout).Do(req)
 	if err != nil {
 		return nil, err
 	}
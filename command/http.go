@@ -0,0 +1,40 @@
+package command
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+)
+
+// httpTransport returns a transport that dials over network through the
+// configured proxy. Its idle connections are closed when the command ends.
+func (c *GoCmd) httpTransport(network string) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = c.proxyFunc()
+	transport.DialContext = func(ctx context.Context, _, addr string) (net.Conn, error) {
+		return c.dial(ctx, network, addr, defaultDialTimeout)
+	}
+	c.mu.Lock()
+	c.transports = append(c.transports, transport)
+	c.mu.Unlock()
+	return transport
+}
+
+func (c *GoCmd) closeTransports() {
+	c.mu.Lock()
+	transports := c.transports
+	c.transports = nil
+	c.mu.Unlock()
+	for _, transport := range transports {
+		transport.CloseIdleConnections()
+	}
+}
+
+// httpClient returns a client for commands that do not need a custom transport.
+func (c *GoCmd) httpClient(timeout time.Duration) *http.Client {
+	return &http.Client{
+		Transport: c.httpTransport("tcp"),
+		Timeout:   timeout,
+	}
+}
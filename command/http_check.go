@@ -0,0 +1,329 @@
+package command
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptrace"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultHTTPTimeout      = 15 * time.Second
+	defaultHTTPMaxRedirects = 10
+	maxHTTPBodySize         = 1 << 20
+)
+
+type httpTiming struct {
+	DNSMS       float64 `json:"dns_ms"`
+	ConnectMS   float64 `json:"connect_ms"`
+	TLSMS       float64 `json:"tls_ms"`
+	FirstByteMS float64 `json:"first_byte_ms"`
+	TotalMS     float64 `json:"total_ms"`
+}
+
+type httpAssertion struct {
+	Name    string `json:"name"`
+	Passed  bool   `json:"passed"`
+	Message string `json:"message,omitempty"`
+}
+
+type httpCheckResult struct {
+	URL        string          `json:"url"`
+	FinalURL   string          `json:"final_url"`
+	StatusCode int             `json:"status_code"`
+	Redirects  []string        `json:"redirects"`
+	Timing     httpTiming      `json:"timing"`
+	Assertions []httpAssertion `json:"assertions"`
+	Passed     bool            `json:"passed"`
+}
+
+func httpCheckCommand(c *GoCmd, args ...string) ([]string, error) {
+	// Should be:
+	// 0: url
+	// 1: expected status (optional, deprecated in favor of status=)
+	// Options:
+	// method, body: request method and body
+	// header: "Name: value", may be repeated
+	// user, password: basic auth
+	// bearer: bearer token
+	// max_redirects: redirects to follow, 0 to not follow any
+	// ca: trusted CA bundle, PEM or base64
+	// insecure: skip TLS verification
+	// timeout: overall request timeout
+	// status: comma separated codes, ranges ("200-204") or classes ("2xx")
+	// body_contains, body_regex: body assertions
+	// json_path, json_value: asserts the value at a dotted path such as "items.0.name"
+	positional, opts := parseArgs(args)
+	if len(positional) < 1 {
+		return nil, ErrMissingArgs
+	}
+	rawURL := positional[0]
+	if len(positional) > 1 {
+		opts.setDefault("status", positional[1])
+	}
+
+	client, err := c.httpClientFromOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	maxRedirects, err := opts.getInt("max_redirects", defaultHTTPMaxRedirects)
+	if err != nil {
+		return nil, err
+	}
+
+	var body io.Reader
+	if b := opts.get("body", ""); b != "" {
+		body = strings.NewReader(b)
+	}
+	req, err := http.NewRequest(strings.ToUpper(opts.get("method", "GET")), rawURL, body)
+	if err != nil {
+		return nil, err
+	}
+	for _, header := range opts.getAll("header") {
+		parts := strings.SplitN(header, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("Invalid header: %q", header)
+		}
+		req.Header.Add(strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]))
+	}
+	if user := opts.get("user", ""); user != "" {
+		req.SetBasicAuth(user, opts.get("password", ""))
+	}
+	if bearer := opts.get("bearer", ""); bearer != "" {
+		req.Header.Set("Authorization", "Bearer "+bearer)
+	}
+
+	result := httpCheckResult{
+		URL:        rawURL,
+		Redirects:  []string{},
+		Assertions: []httpAssertion{},
+	}
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if len(via) > maxRedirects {
+			return http.ErrUseLastResponse
+		}
+		result.Redirects = append(result.Redirects, req.URL.String())
+		return nil
+	}
+
+	start := time.Now()
+	var dnsStart, connectStart, tlsStart time.Time
+	trace := &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) { dnsStart = time.Now() },
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			result.Timing.DNSMS = msSince(dnsStart)
+		},
+		ConnectStart: func(string, string) { connectStart = time.Now() },
+		ConnectDone: func(string, string, error) {
+			result.Timing.ConnectMS = msSince(connectStart)
+		},
+		TLSHandshakeStart: func() { tlsStart = time.Now() },
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			result.Timing.TLSMS = msSince(tlsStart)
+		},
+		GotFirstResponseByte: func() {
+			result.Timing.FirstByteMS = msSince(start)
+		},
+	}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		errMsg := fmt.Sprintf("HTTP request failed: %v", err)
+		return []string{"false"}, ErrCommandResponse{errMsg}
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxHTTPBodySize))
+	if err != nil {
+		return nil, err
+	}
+	result.Timing.TotalMS = msSince(start)
+	result.StatusCode = resp.StatusCode
+	result.FinalURL = resp.Request.URL.String()
+
+	result.Assertions, err = httpAssertions(opts, resp.StatusCode, respBody)
+	if err != nil {
+		return nil, err
+	}
+	result.Passed = true
+	failed := []string{}
+	for _, assertion := range result.Assertions {
+		if !assertion.Passed {
+			result.Passed = false
+			failed = append(failed, assertion.Message)
+		}
+	}
+
+	results, err := jsonResult(result)
+	if err != nil {
+		return nil, err
+	}
+	if !result.Passed {
+		errMsg := fmt.Sprintf("HTTP check failed: %s", strings.Join(failed, "; "))
+		return results, ErrCommandResponse{errMsg}
+	}
+	return results, nil
+}
+
+// httpClientFromOptions returns a client honouring the timeout, ca and
+// insecure options.
+func (c *GoCmd) httpClientFromOptions(opts cmdOptions) (*http.Client, error) {
+	timeout, err := opts.getDuration("timeout", defaultHTTPTimeout)
+	if err != nil {
+		return nil, err
+	}
+	insecure, err := opts.getBool("insecure", false)
+	if err != nil {
+		return nil, err
+	}
+	transport := c.httpTransport("tcp")
+	transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: insecure}
+	if _, ok := opts["ca"]; ok {
+		roots, err := certPoolFromOptions(opts)
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig.RootCAs = roots
+	}
+	return &http.Client{
+		Transport: transport,
+		Timeout:   timeout,
+	}, nil
+}
+
+func httpAssertions(opts cmdOptions, statusCode int, body []byte) ([]httpAssertion, error) {
+	assertions := []httpAssertion{}
+
+	if status, ok := opts.lookup("status"); ok {
+		passed, err := statusMatches(status, statusCode)
+		if err != nil {
+			return nil, err
+		}
+		assertion := httpAssertion{Name: "status", Passed: passed}
+		if !passed {
+			assertion.Message = fmt.Sprintf("HTTP status code %d, expected %s", statusCode, status)
+		}
+		assertions = append(assertions, assertion)
+	}
+
+	if substr, ok := opts.lookup("body_contains"); ok {
+		assertion := httpAssertion{Name: "body_contains", Passed: strings.Contains(string(body), substr)}
+		if !assertion.Passed {
+			assertion.Message = fmt.Sprintf("body does not contain %q", substr)
+		}
+		assertions = append(assertions, assertion)
+	}
+
+	if expr, ok := opts.lookup("body_regex"); ok {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid value for body_regex: %v", err)
+		}
+		assertion := httpAssertion{Name: "body_regex", Passed: re.Match(body)}
+		if !assertion.Passed {
+			assertion.Message = fmt.Sprintf("body does not match %q", expr)
+		}
+		assertions = append(assertions, assertion)
+	}
+
+	if path, ok := opts.lookup("json_path"); ok {
+		assertion := httpAssertion{Name: "json_path"}
+		value, err := jsonPathValue(body, path)
+		if err != nil {
+			assertion.Message = err.Error()
+		} else if expected, ok := opts.lookup("json_value"); ok && value != expected {
+			assertion.Message = fmt.Sprintf("%s is %s, expected %s", path, value, expected)
+		} else {
+			assertion.Passed = true
+		}
+		assertions = append(assertions, assertion)
+	}
+
+	return assertions, nil
+}
+
+// statusMatches checks a status code against a comma separated list of
+// codes ("200"), ranges ("200-204") and classes ("2xx").
+func statusMatches(spec string, statusCode int) (bool, error) {
+	for _, item := range splitList(spec) {
+		lower := strings.ToLower(item)
+		switch {
+		case len(lower) == 3 && strings.HasSuffix(lower, "xx"):
+			class, err := strconv.Atoi(lower[:1])
+			if err != nil {
+				return false, fmt.Errorf("Invalid status: %q", item)
+			}
+			if statusCode/100 == class {
+				return true, nil
+			}
+		case strings.Contains(item, "-"):
+			parts := strings.SplitN(item, "-", 2)
+			from, err1 := strconv.Atoi(parts[0])
+			to, err2 := strconv.Atoi(parts[1])
+			if err1 != nil || err2 != nil {
+				return false, fmt.Errorf("Invalid status: %q", item)
+			}
+			if statusCode >= from && statusCode <= to {
+				return true, nil
+			}
+		default:
+			code, err := strconv.Atoi(item)
+			if err != nil {
+				return false, fmt.Errorf("Invalid status: %q", item)
+			}
+			if statusCode == code {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// jsonPathValue returns the value at a dotted path in a JSON document. Strings
+// are returned as is and other values JSON encoded.
+func jsonPathValue(body []byte, path string) (string, error) {
+	var doc interface{}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return "", fmt.Errorf("body is not JSON: %v", err)
+	}
+	for _, key := range strings.Split(path, ".") {
+		switch node := doc.(type) {
+		case map[string]interface{}:
+			value, ok := node[key]
+			if !ok {
+				return "", fmt.Errorf("%s not found", path)
+			}
+			doc = value
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(node) {
+				return "", fmt.Errorf("%s not found", path)
+			}
+			doc = node[i]
+		default:
+			return "", fmt.Errorf("%s not found", path)
+		}
+	}
+	if s, ok := doc.(string); ok {
+		return s, nil
+	}
+	b, err := json.Marshal(doc)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func msSince(t time.Time) float64 {
+	if t.IsZero() {
+		return 0
+	}
+	return float64(time.Since(t)) / float64(time.Millisecond)
+}
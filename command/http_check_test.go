@@ -0,0 +1,287 @@
+package command
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func runHTTPCheck(t *testing.T, args ...string) (httpCheckResult, error) {
+	t.Helper()
+	results, err := httpCheckCommand(newTestCmd(t), args...)
+	if _, ok := err.(ErrCommandResponse); err != nil && !ok {
+		t.Fatalf("http_check: %v", err)
+	}
+	var result httpCheckResult
+	if len(results) != 1 {
+		t.Fatalf("got %d results", len(results))
+	}
+	if err := json.Unmarshal([]byte(results[0]), &result); err != nil {
+		t.Fatal(err)
+	}
+	return result, err
+}
+
+func TestStatusMatches(t *testing.T) {
+	tests := []struct {
+		spec   string
+		status int
+		match  bool
+	}{
+		{"200", 200, true},
+		{"200", 201, false},
+		{"200,204", 204, true},
+		{" 200 , 301 ", 301, true},
+		{"200-204", 200, true},
+		{"200-204", 204, true},
+		{"200-204", 205, false},
+		{"2xx", 299, true},
+		{"2XX", 200, true},
+		{"2xx", 301, false},
+		{"404,5xx", 503, true},
+		{"3xx,401-403", 402, true},
+		{"3xx,401-403", 404, false},
+		{"", 200, false},
+	}
+	for _, test := range tests {
+		match, err := statusMatches(test.spec, test.status)
+		if err != nil {
+			t.Errorf("statusMatches(%q, %d): %v", test.spec, test.status, err)
+		} else if match != test.match {
+			t.Errorf("statusMatches(%q, %d) = %v, want %v", test.spec, test.status, match, test.match)
+		}
+	}
+	for _, spec := range []string{"ok", "axx", "200-", "-204", "2x", "200-2xx"} {
+		if _, err := statusMatches(spec, 200); err == nil {
+			t.Errorf("statusMatches(%q) accepted", spec)
+		}
+	}
+}
+
+func TestJSONPathValue(t *testing.T) {
+	body := []byte(`{"status":"ok","count":3,"ready":true,"meta":null,
+		"items":[{"name":"a","tags":["x","y"]},{"name":"b","size":{"w":1}}]}`)
+	tests := []struct {
+		path  string
+		value string
+	}{
+		{"status", "ok"},
+		{"count", "3"},
+		{"ready", "true"},
+		{"meta", "null"},
+		{"items.0.name", "a"},
+		{"items.1.name", "b"},
+		{"items.0.tags.1", "y"},
+		{"items.1.size", `{"w":1}`},
+		{"items.0.tags", `["x","y"]`},
+	}
+	for _, test := range tests {
+		value, err := jsonPathValue(body, test.path)
+		if err != nil {
+			t.Errorf("jsonPathValue(%q): %v", test.path, err)
+		} else if value != test.value {
+			t.Errorf("jsonPathValue(%q) = %q, want %q", test.path, value, test.value)
+		}
+	}
+	for _, path := range []string{"missing", "items.2", "items.-1", "items.first", "status.length", "items.0.tags.5", "meta.x", ""} {
+		if value, err := jsonPathValue(body, path); err == nil || !strings.HasSuffix(err.Error(), "not found") {
+			t.Errorf("jsonPathValue(%q) = %q, %v, want not found", path, value, err)
+		}
+	}
+	if _, err := jsonPathValue([]byte("<html>"), "status"); err == nil || !strings.HasPrefix(err.Error(), "body is not JSON") {
+		t.Errorf("got %v, want a not JSON error", err)
+	}
+}
+
+func TestHTTPCheck(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		user, pass, _ := r.BasicAuth()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"method": r.Method,
+			"body":   string(body),
+			"header": r.Header.Get("X-Check"),
+			"auth":   user + ":" + pass,
+			"items":  []string{"first", "second"},
+		})
+	}))
+	defer server.Close()
+
+	result, err := runHTTPCheck(t, server.URL, "--",
+		"method=post", "body=payload", "header=X-Check: yes", "user=u", "password=p",
+		"status=2xx", "body_contains=payload", `body_regex="method":"POST"`,
+		"json_path=items.1", "json_value=second")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Passed || result.StatusCode != 200 || result.FinalURL != server.URL || len(result.Redirects) != 0 {
+		t.Errorf("unexpected result %+v", result)
+	}
+	names := []string{}
+	for _, assertion := range result.Assertions {
+		names = append(names, assertion.Name)
+	}
+	if want := []string{"status", "body_contains", "body_regex", "json_path"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("assertions %q, want %q", names, want)
+	}
+	if result.Timing.TotalMS <= 0 || result.Timing.FirstByteMS <= 0 || result.Timing.FirstByteMS > result.Timing.TotalMS {
+		t.Errorf("unexpected timing %+v", result.Timing)
+	}
+	if result.Timing.TLSMS != 0 || result.Timing.DNSMS != 0 {
+		t.Errorf("TLS or DNS time for a plain request to an IP: %+v", result.Timing)
+	}
+
+	for _, check := range [][]string{
+		{"header=X-Check: yes", "json_path=header", "json_value=yes"},
+		{"user=u", "password=p", "json_path=auth", "json_value=u:p"},
+		{"json_path=method", "json_value=GET"},
+	} {
+		if _, err := runHTTPCheck(t, append([]string{server.URL, "--"}, check...)...); err != nil {
+			t.Errorf("%q: %v", check, err)
+		}
+	}
+}
+
+func TestHTTPCheckFailedAssertions(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprint(w, `{"status":"down"}`)
+	}))
+	defer server.Close()
+
+	result, err := runHTTPCheck(t, server.URL, "--", "status=200-299", "body_contains=up", "json_path=status", "json_value=up")
+	want := "HTTP check failed: HTTP status code 503, expected 200-299; body does not contain \"up\"; status is down, expected up"
+	if err == nil || err.Error() != want {
+		t.Errorf("got error %v, want %q", err, want)
+	}
+	if result.Passed || result.StatusCode != 503 {
+		t.Errorf("unexpected result %+v", result)
+	}
+
+	// The deprecated positional status still works.
+	if _, err := runHTTPCheck(t, server.URL, "503"); err != nil {
+		t.Errorf("positional status: %v", err)
+	}
+	if _, err := runHTTPCheck(t, server.URL, "200"); err == nil {
+		t.Error("positional status not checked")
+	}
+	// Without assertions any response passes.
+	if _, err := runHTTPCheck(t, server.URL); err != nil {
+		t.Errorf("no assertions: %v", err)
+	}
+
+	results, err := httpCheckCommand(newTestCmd(t), "http://127.0.0.1:1", "--", "timeout=1s")
+	if err == nil || !strings.HasPrefix(err.Error(), "HTTP request failed") || !reflect.DeepEqual(results, []string{"false"}) {
+		t.Errorf("connection refused: %q, %v", results, err)
+	}
+}
+
+func TestHTTPCheckRedirects(t *testing.T) {
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "authorization=%q", r.Header.Get("Authorization"))
+	}))
+	defer other.Close()
+	// A different host name, so that the redirect leaves the host.
+	otherURL := strings.Replace(other.URL, "127.0.0.1", "localhost", 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/a":
+			http.Redirect(w, r, "/b", http.StatusFound)
+		case "/b":
+			http.Redirect(w, r, "/c", http.StatusMovedPermanently)
+		case "/c":
+			fmt.Fprint(w, "done")
+		case "/away":
+			http.Redirect(w, r, otherURL+"/landing", http.StatusFound)
+		}
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		maxRedirects string
+		status       int
+		finalPath    string
+		redirects    []string
+	}{
+		{"", 200, "/c", []string{server.URL + "/b", server.URL + "/c"}},
+		{"2", 200, "/c", []string{server.URL + "/b", server.URL + "/c"}},
+		{"1", 301, "/b", []string{server.URL + "/b"}},
+		{"0", 302, "/a", []string{}},
+	}
+	for _, test := range tests {
+		args := []string{server.URL + "/a", "--"}
+		if test.maxRedirects != "" {
+			args = append(args, "max_redirects="+test.maxRedirects)
+		}
+		result, err := runHTTPCheck(t, args...)
+		if err != nil {
+			t.Errorf("max_redirects=%s: %v", test.maxRedirects, err)
+			continue
+		}
+		if result.StatusCode != test.status || result.FinalURL != server.URL+test.finalPath {
+			t.Errorf("max_redirects=%s: status %d at %s, want %d at %s", test.maxRedirects, result.StatusCode, result.FinalURL, test.status, test.finalPath)
+		}
+		if !reflect.DeepEqual(result.Redirects, test.redirects) {
+			t.Errorf("max_redirects=%s: redirects %q, want %q", test.maxRedirects, result.Redirects, test.redirects)
+		}
+	}
+
+	// Credentials are not sent on to another host.
+	result, err := runHTTPCheck(t, server.URL+"/away", "--", "bearer=secret", `body_contains=authorization=""`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.FinalURL != otherURL+"/landing" || !reflect.DeepEqual(result.Redirects, []string{otherURL + "/landing"}) {
+		t.Errorf("unexpected result %+v", result)
+	}
+}
+
+func TestHTTPCheckTLS(t *testing.T) {
+	pki := newTestPKI(t)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.Config.ErrorLog = log.New(ioutil.Discard, "", 0)
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{pki.tlsCertificate(t, "localhost")}}
+	server.StartTLS()
+	defer server.Close()
+
+	result, err := runHTTPCheck(t, server.URL, "--", "ca="+pki.caPEM, "status=200")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Timing.TLSMS <= 0 || result.Timing.ConnectMS <= 0 {
+		t.Errorf("unexpected timing %+v", result.Timing)
+	}
+	if _, err := httpCheckCommand(newTestCmd(t), server.URL); err == nil || !strings.HasPrefix(err.Error(), "HTTP request failed") {
+		t.Errorf("untrusted certificate: got %v", err)
+	}
+	if _, err := runHTTPCheck(t, server.URL, "--", "insecure=true"); err != nil {
+		t.Errorf("insecure: %v", err)
+	}
+}
+
+func TestHTTPCheckErrors(t *testing.T) {
+	for _, args := range [][]string{
+		{"http://127.0.0.1:1", "--", "header=novalue"},
+		{"http://127.0.0.1:1", "--", "max_redirects=many"},
+		{"http://127.0.0.1:1", "--", "timeout=soon"},
+		{"http://127.0.0.1:1", "--", "insecure=maybe"},
+		{"http://127.0.0.1:1", "--", "method=GET POST"},
+	} {
+		if _, err := httpCheckCommand(newTestCmd(t), args...); err == nil {
+			t.Errorf("http_check(%q) succeeded", args)
+		} else if _, ok := err.(ErrCommandResponse); ok {
+			t.Errorf("http_check(%q): %v was not rejected before the request", args, err)
+		}
+	}
+	if _, err := httpCheckCommand(newTestCmd(t)); err != ErrMissingArgs {
+		t.Errorf("got %v, want ErrMissingArgs", err)
+	}
+}
@@ -0,0 +1,168 @@
+package command
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/fsouza/go-dockerclient"
+)
+
+const (
+	buildDockerfile  = "Dockerfile"
+	buildCommandsDir = "root/commands"
+	buildTagLength   = 12
+)
+
+// BuildImage builds the command image from the Dockerfile and root/commands
+// directory found in contextDir. The image is tagged with a hash of those
+// files so that it is only rebuilt when they change. The tag is returned.
+func BuildImage(client *docker.Client, contextDir, repository string) (string, error) {
+	files, err := buildContextFiles(contextDir)
+	if err != nil {
+		return "", err
+	}
+	tag, err := buildContextHash(contextDir, files)
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := client.InspectImage(fmt.Sprintf("%s:%s", repository, tag)); err == nil {
+		log.Debugf("image %s:%s is up to date", repository, tag)
+		return tag, nil
+	} else if err != docker.ErrNoSuchImage {
+		return "", err
+	}
+
+	context, err := buildContextTar(contextDir, files)
+	if err != nil {
+		return "", err
+	}
+
+	reader, writer := io.Pipe()
+	defer writer.Close()
+	go func(reader io.Reader) {
+		scanner := bufio.NewScanner(reader)
+		for scanner.Scan() {
+			line := scanner.Text()
+			log.Debugf(" -> %s", line)
+		}
+	}(reader)
+	opts := docker.BuildImageOptions{
+		Name:           fmt.Sprintf("%s:%s", repository, tag),
+		RmTmpContainer: true,
+		InputStream:    context,
+		OutputStream:   writer,
+	}
+	log.Debugf("building image %s:%s from %s", repository, tag, contextDir)
+	if err := client.BuildImage(opts); err != nil {
+		return "", err
+	}
+	log.Debugf(" -> building image %s:%s complete", repository, tag)
+	return tag, nil
+}
+
+// LoadImage loads the command image from a tarball created with docker save.
+// The tarball must contain the image tagged as repository:tag.
+func LoadImage(client *docker.Client, archivePath, repository, tag string) error {
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	log.Debugf("loading image %s:%s from %s", repository, tag, archivePath)
+	if err := client.LoadImage(docker.LoadImageOptions{InputStream: f}); err != nil {
+		return err
+	}
+	if _, err := client.InspectImage(fmt.Sprintf("%s:%s", repository, tag)); err != nil {
+		return fmt.Errorf("Image %s:%s not found in %s: %v", repository, tag, archivePath, err)
+	}
+	log.Debugf(" -> loading image %s:%s complete", repository, tag)
+	return nil
+}
+
+// buildContextFiles lists the files, relative to contextDir, that make up the
+// image build context.
+func buildContextFiles(contextDir string) ([]string, error) {
+	files := []string{buildDockerfile}
+	root := filepath.Join(contextDir, buildCommandsDir)
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(contextDir, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// buildContextHash hashes the names, permissions and contents of files, which
+// is everything buildContextTar puts in the context other than times.
+func buildContextHash(contextDir string, files []string) (string, error) {
+	h := sha256.New()
+	for _, name := range files {
+		path := filepath.Join(contextDir, name)
+		info, err := os.Stat(path)
+		if err != nil {
+			return "", err
+		}
+		contents, err := ioutil.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "%s\x00%o\x00%d\x00", name, info.Mode().Perm(), len(contents))
+		h.Write(contents)
+	}
+	return hex.EncodeToString(h.Sum(nil))[:buildTagLength], nil
+}
+
+func buildContextTar(contextDir string, files []string) (io.Reader, error) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, name := range files {
+		path := filepath.Join(contextDir, name)
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		contents, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		hdr := &tar.Header{
+			Name:    name,
+			Mode:    int64(info.Mode().Perm()),
+			Size:    int64(len(contents)),
+			ModTime: info.ModTime(),
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return nil, err
+		}
+		if _, err := tw.Write(contents); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	return &buf, nil
+}
@@ -0,0 +1,174 @@
+package command
+
+import (
+	"archive/tar"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// writeBuildContext creates a build context with a Dockerfile and the given
+// files under root/commands.
+func writeBuildContext(t *testing.T, scripts map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	writeBuildFile(t, dir, buildDockerfile, "FROM alpine\nCOPY root /\n", 0644)
+	for name, contents := range scripts {
+		writeBuildFile(t, dir, filepath.Join(buildCommandsDir, name), contents, 0755)
+	}
+	return dir
+}
+
+func writeBuildFile(t *testing.T, dir, name, contents string, mode os.FileMode) {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte(contents), mode); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func buildHash(t *testing.T, dir string) string {
+	t.Helper()
+	files, err := buildContextFiles(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, err := buildContextHash(dir, files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hash
+}
+
+func TestBuildContextFiles(t *testing.T) {
+	dir := writeBuildContext(t, map[string]string{
+		"ping.sh":        "#!/bin/sh\nping -c 1 \"$1\"\n",
+		"net/resolve.sh": "#!/bin/sh\ngetent hosts \"$1\"\n",
+	})
+	// Files outside root/commands are not part of the context.
+	writeBuildFile(t, dir, "README.md", "not in the image", 0644)
+
+	files, err := buildContextFiles(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"Dockerfile", "root/commands/net/resolve.sh", "root/commands/ping.sh"}
+	if !reflect.DeepEqual(files, want) {
+		t.Errorf("files = %q, want %q", files, want)
+	}
+
+	if _, err := buildContextFiles(t.TempDir()); err == nil {
+		t.Error("a context without root/commands succeeded")
+	}
+}
+
+func TestBuildContextHash(t *testing.T) {
+	scripts := map[string]string{"ping.sh": "#!/bin/sh\nping -c 1 \"$1\"\n"}
+	dir := writeBuildContext(t, scripts)
+
+	hash := buildHash(t, dir)
+	if len(hash) != buildTagLength {
+		t.Errorf("hash %q is not %d characters", hash, buildTagLength)
+	}
+
+	// The hash depends only on the files, not on where or when they were
+	// written.
+	if other := buildHash(t, writeBuildContext(t, scripts)); other != hash {
+		t.Errorf("same context hashed to %s and %s", hash, other)
+	}
+	path := filepath.Join(dir, buildCommandsDir, "ping.sh")
+	if err := os.Chtimes(path, time.Unix(0, 0), time.Unix(0, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if again := buildHash(t, dir); again != hash {
+		t.Errorf("touching a script changed the hash from %s to %s", hash, again)
+	}
+
+	changes := []struct {
+		name   string
+		change func()
+	}{
+		{"script contents", func() {
+			writeBuildFile(t, dir, filepath.Join(buildCommandsDir, "ping.sh"), "#!/bin/sh\nping -c 3 \"$1\"\n", 0755)
+		}},
+		{"script mode", func() {
+			if err := os.Chmod(path, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}},
+		{"new script", func() {
+			writeBuildFile(t, dir, filepath.Join(buildCommandsDir, "trace.sh"), "#!/bin/sh\n", 0755)
+		}},
+		{"Dockerfile", func() {
+			writeBuildFile(t, dir, buildDockerfile, "FROM alpine:3\nCOPY root /\n", 0644)
+		}},
+	}
+	for _, c := range changes {
+		c.change()
+		next := buildHash(t, dir)
+		if next == hash {
+			t.Errorf("changing the %s kept the hash %s", c.name, hash)
+		}
+		hash = next
+	}
+}
+
+func TestBuildContextTar(t *testing.T) {
+	scripts := map[string]string{
+		"ping.sh":        "#!/bin/sh\nping -c 1 \"$1\"\n",
+		"net/resolve.sh": "#!/bin/sh\ngetent hosts \"$1\"\n",
+	}
+	dir := writeBuildContext(t, scripts)
+	files, err := buildContextFiles(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	context, err := buildContextTar(dir, files)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type entry struct {
+		mode     int64
+		contents string
+	}
+	got := map[string]entry{}
+	names := []string{}
+	tr := tar.NewReader(context)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		contents, err := ioutil.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, hdr.Name)
+		got[hdr.Name] = entry{hdr.Mode, string(contents)}
+	}
+
+	if !reflect.DeepEqual(names, files) {
+		t.Errorf("entries = %q, want %q", names, files)
+	}
+	want := map[string]entry{
+		"Dockerfile":                   {0644, "FROM alpine\nCOPY root /\n"},
+		"root/commands/ping.sh":        {0755, scripts["ping.sh"]},
+		"root/commands/net/resolve.sh": {0755, scripts["net/resolve.sh"]},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("entries = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,751 @@
+package command
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	LDAPScopeBase = "base"
+	LDAPScopeOne  = "one"
+	LDAPScopeSub  = "sub"
+
+	ldapTagBindRequest           = 0x60
+	ldapTagBindResponse          = 0x61
+	ldapTagUnbindRequest         = 0x42
+	ldapTagSearchRequest         = 0x63
+	ldapTagSearchResultEntry     = 0x64
+	ldapTagSearchResultDone      = 0x65
+	ldapTagSearchResultReference = 0x73
+	ldapTagExtendedRequest       = 0x77
+	ldapTagExtendedResponse      = 0x78
+
+	ldapTagSimpleAuth          = 0x80
+	ldapTagExtendedRequestName = 0x80
+
+	ldapTagFilterAnd         = 0xa0
+	ldapTagFilterOr          = 0xa1
+	ldapTagFilterNot         = 0xa2
+	ldapTagFilterEquality    = 0xa3
+	ldapTagFilterSubstrings  = 0xa4
+	ldapTagFilterGreaterOrEq = 0xa5
+	ldapTagFilterLessOrEq    = 0xa6
+	ldapTagFilterPresent     = 0x87
+	ldapTagFilterApprox      = 0xa8
+
+	ldapTagSubstringInitial = 0x80
+	ldapTagSubstringAny     = 0x81
+	ldapTagSubstringFinal   = 0x82
+
+	ldapResultSuccess           = 0
+	ldapResultSizeLimitExceeded = 4
+
+	ldapVersion     = 3
+	ldapStartTLSOID = "1.3.6.1.4.1.1466.20037"
+
+	defaultLDAPFilter     = "(objectClass=person)"
+	defaultLDAPUserFilter = "(&(objectClass=person)(|(uid={username})(sAMAccountName={username})))"
+	defaultLDAPSizeLimit  = 10
+	defaultLDAPTimeout    = 30 * time.Second
+)
+
+var ldapScopes = map[string]int64{
+	LDAPScopeBase: 0,
+	LDAPScopeOne:  1,
+	LDAPScopeSub:  2,
+}
+
+// ldapResultNames are the result codes of RFC 4511.
+var ldapResultNames = map[int]string{
+	0:  "success",
+	1:  "operationsError",
+	2:  "protocolError",
+	3:  "timeLimitExceeded",
+	4:  "sizeLimitExceeded",
+	7:  "authMethodNotSupported",
+	8:  "strongerAuthRequired",
+	10: "referral",
+	11: "adminLimitExceeded",
+	12: "unavailableCriticalExtension",
+	13: "confidentialityRequired",
+	14: "saslBindInProgress",
+	16: "noSuchAttribute",
+	17: "undefinedAttributeType",
+	18: "inappropriateMatching",
+	21: "invalidAttributeSyntax",
+	32: "noSuchObject",
+	34: "invalidDNSyntax",
+	48: "inappropriateAuthentication",
+	49: "invalidCredentials",
+	50: "insufficientAccessRights",
+	51: "busy",
+	52: "unavailable",
+	53: "unwillingToPerform",
+	54: "loopDetect",
+	80: "other",
+}
+
+// adBindErrors are the sub-codes Active Directory puts in the diagnostic
+// message of a failed bind, e.g. "AcceptSecurityContext error, data 52e".
+var adBindErrors = map[string]string{
+	"525": "user not found",
+	"52e": "invalid credentials",
+	"530": "not permitted to log on at this time",
+	"531": "not permitted to log on at this workstation",
+	"532": "password expired",
+	"533": "account disabled",
+	"568": "too many security IDs",
+	"701": "account expired",
+	"773": "user must reset password",
+	"775": "account locked out",
+}
+
+var adDataCodeRegexp = regexp.MustCompile(`\bdata ([0-9a-fA-F]{3,4})\b`)
+
+var errMalformedLDAP = errors.New("Malformed LDAP response")
+
+type ldapStep struct {
+	Name       string `json:"name"`
+	ResultCode *int   `json:"result_code,omitempty"`
+	ResultName string `json:"result_name,omitempty"`
+	MatchedDN  string `json:"matched_dn,omitempty"`
+	Message    string `json:"message,omitempty"`
+	ADError    string `json:"ad_error,omitempty"`
+	Passed     bool   `json:"passed"`
+}
+
+type ldapEntry struct {
+	DN         string              `json:"dn"`
+	Attributes map[string][]string `json:"attributes"`
+}
+
+type ldapCheckResult struct {
+	URL        string      `json:"url"`
+	StartTLS   bool        `json:"start_tls"`
+	TLSVersion string      `json:"tls_version,omitempty"`
+	BindDN     string      `json:"bind_dn"`
+	BaseDN     string      `json:"base_dn,omitempty"`
+	Filter     string      `json:"filter,omitempty"`
+	Entries    []ldapEntry `json:"entries"`
+	Referrals  []string    `json:"referrals,omitempty"`
+	Steps      []ldapStep  `json:"steps"`
+	Passed     bool        `json:"passed"`
+}
+
+// ldapResult is the LDAPResult of a response. It is an error when the
+// operation did not succeed.
+type ldapResult struct {
+	Code      int
+	MatchedDN string
+	Message   string
+}
+
+func (r ldapResult) Error() string {
+	return fmt.Sprintf("%d (%s) %s", r.Code, ldapResultName(r.Code), r.Message)
+}
+
+// ldapAttribute maps an LDAP attribute to the name it is reported as.
+type ldapAttribute struct {
+	Name      string
+	Attribute string
+}
+
+type ldapSearch struct {
+	BaseDN     string
+	Scope      int64
+	SizeLimit  int
+	Filter     []byte
+	Attributes []ldapAttribute
+}
+
+type ldapConn struct {
+	conn      net.Conn
+	reader    *bufio.Reader
+	messageID int64
+	result    *ldapCheckResult
+}
+
+func ldapCheckCommand(c *GoCmd, args ...string) ([]string, error) {
+	// Should be:
+	// 0: url: ldap://host[:port] or ldaps://host[:port]
+	// Options:
+	// bind_dn, bind_password: the service account, binds anonymously when
+	//   bind_dn is unset. bind_password is required with bind_dn.
+	// start_tls: upgrade an ldap:// connection with StartTLS
+	// base_dn: runs a test search under this DN when set
+	// filter: search filter, defaults to (objectClass=person), or to a uid or
+	//   sAMAccountName match with username. {username} is replaced with the
+	//   escaped username option.
+	// username: user to look up with the filter, requires base_dn
+	// scope: "base", "one" or "sub", defaults to sub
+	// size_limit: maximum number of entries returned, defaults to 10
+	// attribute: "name=ldapAttribute" mapping reported for each entry, may be
+	//   repeated. All attributes are returned under their own names when unset.
+	// server_name: TLS server name, defaults to host
+	// ca, insecure: as for http_check
+	// timeout: overall timeout
+	// The search fails when no entries match.
+	positional, opts := parseArgs(args)
+	if len(positional) < 1 {
+		return nil, ErrMissingArgs
+	}
+	u, err := url.Parse(positional[0])
+	if err != nil {
+		return nil, fmt.Errorf("Invalid LDAP URL: %v", err)
+	}
+	port := "389"
+	switch u.Scheme {
+	case "ldap":
+	case "ldaps":
+		port = "636"
+	default:
+		return nil, fmt.Errorf("LDAP URL must start with ldap:// or ldaps://: %q", positional[0])
+	}
+	if u.Port() != "" {
+		port = u.Port()
+	}
+	startTLS, err := opts.getBool("start_tls", false)
+	if err != nil {
+		return nil, err
+	}
+	if startTLS && u.Scheme == "ldaps" {
+		return nil, errors.New("StartTLS cannot be used with ldaps://")
+	}
+	timeout, err := opts.getDuration("timeout", defaultLDAPTimeout)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig, err := tlsConfigFromOptions(u.Hostname(), opts)
+	if err != nil {
+		return nil, err
+	}
+
+	result := ldapCheckResult{
+		URL:      positional[0],
+		StartTLS: startTLS,
+		BindDN:   opts.get("bind_dn", ""),
+		BaseDN:   opts.get("base_dn", ""),
+		Entries:  []ldapEntry{},
+		Steps:    []ldapStep{},
+	}
+	// A simple bind with a DN and no password is an unauthenticated bind
+	// (RFC 4513 5.1.2), which servers may accept without checking the DN.
+	password := opts.get("bind_password", "")
+	if result.BindDN != "" && password == "" {
+		return nil, errors.New("bind_password is required with bind_dn")
+	}
+	var search *ldapSearch
+	if result.BaseDN != "" {
+		result.Filter, err = ldapFilterFromOptions(opts)
+		if err != nil {
+			return nil, err
+		}
+		if search, err = ldapSearchFromOptions(result.BaseDN, result.Filter, opts); err != nil {
+			return nil, err
+		}
+	} else if _, ok := opts.lookup("username"); ok {
+		return nil, errors.New("username requires base_dn")
+	}
+
+	address := net.JoinHostPort(u.Hostname(), port)
+	result.Passed = c.ldapCheck(&result, address, u.Scheme == "ldaps", tlsConfig, timeout, password, search) == nil
+
+	results, err := jsonResult(result)
+	if err != nil {
+		return nil, err
+	}
+	if !result.Passed {
+		failed := result.Steps[len(result.Steps)-1]
+		errMsg := fmt.Sprintf("LDAP %s failed: %s", failed.Name, failed.Message)
+		if failed.ResultCode != nil {
+			errMsg = fmt.Sprintf("LDAP %s failed with %d (%s)", failed.Name, *failed.ResultCode, failed.ResultName)
+			if failed.Message != "" {
+				errMsg = fmt.Sprintf("%s: %s", errMsg, failed.Message)
+			}
+		}
+		if failed.ADError != "" {
+			errMsg = fmt.Sprintf("%s (%s)", errMsg, failed.ADError)
+		}
+		return results, ErrCommandResponse{errMsg}
+	}
+	return results, nil
+}
+
+// ldapFilterFromOptions returns the search filter with the username filled
+// in. A username the filter doesn't use is an error rather than ignored.
+func ldapFilterFromOptions(opts cmdOptions) (string, error) {
+	username := opts.get("username", "")
+	if username == "" {
+		filter := opts.get("filter", defaultLDAPFilter)
+		if strings.Contains(filter, "{username}") {
+			return "", errors.New("LDAP filter uses {username} but username is not set")
+		}
+		return filter, nil
+	}
+	filter := opts.get("filter", defaultLDAPUserFilter)
+	if !strings.Contains(filter, "{username}") {
+		return "", fmt.Errorf("LDAP filter %q does not use {username}", filter)
+	}
+	return strings.Replace(filter, "{username}", ldapEscapeFilter(username), -1), nil
+}
+
+func ldapSearchFromOptions(baseDN, filter string, opts cmdOptions) (*ldapSearch, error) {
+	scope, ok := ldapScopes[opts.get("scope", LDAPScopeSub)]
+	if !ok {
+		return nil, fmt.Errorf("LDAP scope must be one of %q, %q or %q", LDAPScopeBase, LDAPScopeOne, LDAPScopeSub)
+	}
+	sizeLimit, err := opts.getInt("size_limit", defaultLDAPSizeLimit)
+	if err != nil {
+		return nil, err
+	}
+	compiled, err := compileLDAPFilter(filter)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid LDAP filter %q: %v", filter, err)
+	}
+	search := &ldapSearch{
+		BaseDN:    baseDN,
+		Scope:     scope,
+		SizeLimit: sizeLimit,
+		Filter:    compiled,
+	}
+	for _, spec := range opts.getAll("attribute") {
+		parts := strings.SplitN(spec, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("Invalid attribute mapping: %q", spec)
+		}
+		search.Attributes = append(search.Attributes, ldapAttribute{Name: parts[0], Attribute: parts[1]})
+	}
+	return search, nil
+}
+
+// ldapCheck connects through the configured proxy, binds and searches.
+// Every failure is recorded as the last step of result before it is
+// returned.
+func (c *GoCmd) ldapCheck(result *ldapCheckResult, address string, ldaps bool, tlsConfig *tls.Config, timeout time.Duration, password string, search *ldapSearch) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	conn, err := c.dialTCP(ctx, address, timeout)
+	if err != nil {
+		result.Steps = append(result.Steps, ldapStep{Name: "connect", Message: err.Error()})
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+	if ldaps {
+		tlsConn := tls.Client(conn, tlsConfig)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			result.Steps = append(result.Steps, ldapStep{Name: "connect", Message: err.Error()})
+			return err
+		}
+		conn = tlsConn
+	}
+
+	l := &ldapConn{conn: conn, reader: bufio.NewReader(conn), result: result}
+	l.recordTLS()
+	if result.StartTLS {
+		op := berConstructed(ldapTagExtendedRequest, berString(ldapTagExtendedRequestName, ldapStartTLSOID))
+		if err := l.request("start_tls", op, ldapTagExtendedResponse); err != nil {
+			return err
+		}
+		tlsConn := tls.Client(conn, tlsConfig)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			return l.fail("tls_handshake", err)
+		}
+		l.conn = tlsConn
+		l.reader = bufio.NewReader(tlsConn)
+		l.recordTLS()
+	}
+
+	bind := berConstructed(ldapTagBindRequest,
+		berInt(berTagInteger, ldapVersion),
+		berString(berTagOctetString, result.BindDN),
+		berString(ldapTagSimpleAuth, password))
+	if err := l.request("bind", bind, ldapTagBindResponse); err != nil {
+		return err
+	}
+
+	if search != nil {
+		if err := l.search(search, int(timeout/time.Second)); err != nil {
+			return err
+		}
+	}
+
+	l.send(berEncode(ldapTagUnbindRequest, nil))
+	return nil
+}
+
+func (l *ldapConn) recordTLS() {
+	if tlsConn, ok := l.conn.(*tls.Conn); ok {
+		l.result.TLSVersion = tls.VersionName(tlsConn.ConnectionState().Version)
+	}
+}
+
+func (l *ldapConn) search(search *ldapSearch, timeLimit int) error {
+	attributes := [][]byte{}
+	for _, attr := range search.Attributes {
+		attributes = append(attributes, berString(berTagOctetString, attr.Attribute))
+	}
+	op := berConstructed(ldapTagSearchRequest,
+		berString(berTagOctetString, search.BaseDN),
+		berInt(berTagEnumerated, search.Scope),
+		berInt(berTagEnumerated, 0), // neverDerefAliases
+		berInt(berTagInteger, int64(search.SizeLimit)),
+		berInt(berTagInteger, int64(timeLimit)),
+		berBool(berTagBoolean, false),
+		search.Filter,
+		berConstructed(berTagSequence, attributes...))
+	id, err := l.send(op)
+	if err != nil {
+		return l.fail("search", err)
+	}
+
+	for {
+		response, err := l.receive(id)
+		if err != nil {
+			return l.fail("search", err)
+		}
+		switch response.Tag {
+		case ldapTagSearchResultEntry:
+			entry, err := parseLDAPEntry(response, search.Attributes)
+			if err != nil {
+				return l.fail("search", err)
+			}
+			l.result.Entries = append(l.result.Entries, entry)
+		case ldapTagSearchResultReference:
+			uris, err := response.children()
+			if err != nil {
+				return l.fail("search", err)
+			}
+			for _, uri := range uris {
+				l.result.Referrals = append(l.result.Referrals, string(uri.Value))
+			}
+		case ldapTagSearchResultDone:
+			res, err := parseLDAPResult(response)
+			if err != nil {
+				return l.fail("search", err)
+			}
+			// Hitting size_limit still shows the search works.
+			ok := res.Code == ldapResultSuccess || res.Code == ldapResultSizeLimitExceeded
+			if err := l.record("search", res, ok); err != nil {
+				return err
+			}
+			if len(l.result.Entries) == 0 {
+				return l.fail("match", errors.New("No entries matched the filter"))
+			}
+			return nil
+		default:
+			return l.fail("search", errMalformedLDAP)
+		}
+	}
+}
+
+// request sends op and records the LDAPResult of its response as a step.
+func (l *ldapConn) request(name string, op []byte, responseTag byte) error {
+	id, err := l.send(op)
+	if err != nil {
+		return l.fail(name, err)
+	}
+	response, err := l.receive(id)
+	if err != nil {
+		return l.fail(name, err)
+	}
+	if response.Tag != responseTag {
+		return l.fail(name, errMalformedLDAP)
+	}
+	res, err := parseLDAPResult(response)
+	if err != nil {
+		return l.fail(name, err)
+	}
+	return l.record(name, res, res.Code == ldapResultSuccess)
+}
+
+func (l *ldapConn) send(op []byte) (int64, error) {
+	l.messageID++
+	message := berConstructed(berTagSequence, berInt(berTagInteger, l.messageID), op)
+	_, err := l.conn.Write(message)
+	return l.messageID, err
+}
+
+// receive returns the protocol op of the next response to message id.
+func (l *ldapConn) receive(id int64) (*berPacket, error) {
+	for {
+		packet, err := readBERPacket(l.reader)
+		if err != nil {
+			return nil, err
+		}
+		if packet.Tag != berTagSequence {
+			return nil, errMalformedLDAP
+		}
+		children, err := packet.children()
+		if err != nil || len(children) < 2 || children[0].Tag != berTagInteger {
+			return nil, errMalformedLDAP
+		}
+		switch children[0].int() {
+		case id:
+			return children[1], nil
+		case 0:
+			// An unsolicited notification, such as a notice of disconnection.
+			res, err := parseLDAPResult(children[1])
+			if err != nil {
+				return nil, err
+			}
+			return nil, fmt.Errorf("Server sent an unsolicited notification: %v", res)
+		}
+	}
+}
+
+func (l *ldapConn) record(name string, res ldapResult, ok bool) error {
+	code := res.Code
+	l.result.Steps = append(l.result.Steps, ldapStep{
+		Name:       name,
+		ResultCode: &code,
+		ResultName: ldapResultName(code),
+		MatchedDN:  res.MatchedDN,
+		Message:    res.Message,
+		ADError:    adBindError(res.Message),
+		Passed:     ok,
+	})
+	if !ok {
+		return res
+	}
+	return nil
+}
+
+func (l *ldapConn) fail(name string, err error) error {
+	l.result.Steps = append(l.result.Steps, ldapStep{Name: name, Message: err.Error()})
+	return err
+}
+
+func parseLDAPResult(op *berPacket) (ldapResult, error) {
+	fields, err := op.children()
+	if err != nil || len(fields) < 3 || fields[0].Tag != berTagEnumerated {
+		return ldapResult{}, errMalformedLDAP
+	}
+	return ldapResult{
+		Code:      int(fields[0].int()),
+		MatchedDN: string(fields[1].Value),
+		Message:   string(fields[2].Value),
+	}, nil
+}
+
+// parseLDAPEntry parses a SearchResultEntry, reporting mapped attributes
+// under their names. LDAP attribute names are case insensitive.
+func parseLDAPEntry(op *berPacket, mapping []ldapAttribute) (ldapEntry, error) {
+	fields, err := op.children()
+	if err != nil || len(fields) < 2 {
+		return ldapEntry{}, errMalformedLDAP
+	}
+	entry := ldapEntry{DN: string(fields[0].Value), Attributes: map[string][]string{}}
+	attributes, err := fields[1].children()
+	if err != nil {
+		return ldapEntry{}, err
+	}
+	for _, attribute := range attributes {
+		parts, err := attribute.children()
+		if err != nil || len(parts) < 2 {
+			return ldapEntry{}, errMalformedLDAP
+		}
+		vals, err := parts[1].children()
+		if err != nil {
+			return ldapEntry{}, err
+		}
+		values := []string{}
+		for _, val := range vals {
+			values = append(values, string(val.Value))
+		}
+		attrType := string(parts[0].Value)
+		if len(mapping) == 0 {
+			entry.Attributes[attrType] = values
+		}
+		for _, m := range mapping {
+			if strings.EqualFold(m.Attribute, attrType) {
+				entry.Attributes[m.Name] = values
+			}
+		}
+	}
+	return entry, nil
+}
+
+func ldapResultName(code int) string {
+	if name, ok := ldapResultNames[code]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+func adBindError(message string) string {
+	match := adDataCodeRegexp.FindStringSubmatch(message)
+	if match == nil {
+		return ""
+	}
+	return adBindErrors[strings.ToLower(match[1])]
+}
+
+// ldapEscapeFilter escapes a value for use in a search filter (RFC 4515).
+func ldapEscapeFilter(value string) string {
+	var buf strings.Builder
+	for i := 0; i < len(value); i++ {
+		switch b := value[i]; b {
+		case '*', '(', ')', '\\', 0:
+			fmt.Fprintf(&buf, "\\%02x", b)
+		default:
+			buf.WriteByte(b)
+		}
+	}
+	return buf.String()
+}
+
+// compileLDAPFilter encodes a string filter (RFC 4515). Extensible matches
+// are not supported.
+func compileLDAPFilter(filter string) ([]byte, error) {
+	compiled, rest, err := parseLDAPFilter(strings.TrimSpace(filter))
+	if err != nil {
+		return nil, err
+	}
+	if rest != "" {
+		return nil, fmt.Errorf("Unexpected %q after filter", rest)
+	}
+	return compiled, nil
+}
+
+func parseLDAPFilter(s string) ([]byte, string, error) {
+	if !strings.HasPrefix(s, "(") || len(s) < 2 {
+		return nil, "", errors.New("Filter must be enclosed in parentheses")
+	}
+	s = s[1:]
+	switch s[0] {
+	case '&', '|':
+		tag := byte(ldapTagFilterAnd)
+		if s[0] == '|' {
+			tag = ldapTagFilterOr
+		}
+		s = s[1:]
+		children := [][]byte{}
+		for strings.HasPrefix(s, "(") {
+			child, rest, err := parseLDAPFilter(s)
+			if err != nil {
+				return nil, "", err
+			}
+			children = append(children, child)
+			s = rest
+		}
+		if !strings.HasPrefix(s, ")") {
+			return nil, "", errors.New("Missing closing parenthesis")
+		}
+		return berConstructed(tag, children...), s[1:], nil
+	case '!':
+		child, rest, err := parseLDAPFilter(s[1:])
+		if err != nil {
+			return nil, "", err
+		}
+		if !strings.HasPrefix(rest, ")") {
+			return nil, "", errors.New("Missing closing parenthesis")
+		}
+		return berConstructed(ldapTagFilterNot, child), rest[1:], nil
+	}
+	end := strings.IndexByte(s, ')')
+	if end < 0 {
+		return nil, "", errors.New("Missing closing parenthesis")
+	}
+	item, err := parseLDAPFilterItem(s[:end])
+	if err != nil {
+		return nil, "", err
+	}
+	return item, s[end+1:], nil
+}
+
+func parseLDAPFilterItem(item string) ([]byte, error) {
+	eq := strings.IndexByte(item, '=')
+	if eq < 1 {
+		return nil, fmt.Errorf("Invalid filter item %q", item)
+	}
+	attr, value := item[:eq], item[eq+1:]
+	tag := byte(ldapTagFilterEquality)
+	switch attr[len(attr)-1] {
+	case '~':
+		tag = ldapTagFilterApprox
+	case '>':
+		tag = ldapTagFilterGreaterOrEq
+	case '<':
+		tag = ldapTagFilterLessOrEq
+	case ':':
+		return nil, fmt.Errorf("Extensible match is not supported: %q", item)
+	}
+	if tag != ldapTagFilterEquality {
+		attr = attr[:len(attr)-1]
+	}
+	if attr == "" {
+		return nil, fmt.Errorf("Invalid filter item %q", item)
+	}
+
+	if tag == ldapTagFilterEquality && value == "*" {
+		return berString(ldapTagFilterPresent, attr), nil
+	}
+	if tag == ldapTagFilterEquality && strings.Contains(value, "*") {
+		parts := strings.Split(value, "*")
+		substrings := [][]byte{}
+		for i, part := range parts {
+			if part == "" {
+				continue
+			}
+			unescaped, err := ldapUnescapeFilter(part)
+			if err != nil {
+				return nil, err
+			}
+			partTag := byte(ldapTagSubstringAny)
+			if i == 0 {
+				partTag = ldapTagSubstringInitial
+			} else if i == len(parts)-1 {
+				partTag = ldapTagSubstringFinal
+			}
+			substrings = append(substrings, berString(partTag, unescaped))
+		}
+		// SubstringFilter needs at least one substring (RFC 4511).
+		if len(substrings) == 0 {
+			return nil, fmt.Errorf("Substring filter %q has no substrings", item)
+		}
+		return berConstructed(ldapTagFilterSubstrings,
+			berString(berTagOctetString, attr),
+			berConstructed(berTagSequence, substrings...)), nil
+	}
+
+	unescaped, err := ldapUnescapeFilter(value)
+	if err != nil {
+		return nil, err
+	}
+	return berConstructed(tag,
+		berString(berTagOctetString, attr),
+		berString(berTagOctetString, unescaped)), nil
+}
+
+func ldapUnescapeFilter(value string) (string, error) {
+	if !strings.Contains(value, "\\") {
+		return value, nil
+	}
+	var buf strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] != '\\' {
+			buf.WriteByte(value[i])
+			continue
+		}
+		if i+2 >= len(value) {
+			return "", fmt.Errorf("Invalid escape in filter value %q", value)
+		}
+		b, err := hex.DecodeString(value[i+1 : i+3])
+		if err != nil {
+			return "", fmt.Errorf("Invalid escape in filter value %q", value)
+		}
+		buf.Write(b)
+		i += 2
+	}
+	return buf.String(), nil
+}
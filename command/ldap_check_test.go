@@ -0,0 +1,733 @@
+package command
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"io"
+	"net"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+const (
+	testLDAPAdminDN   = "cn=admin,dc=example,dc=com"
+	testLDAPPeopleDN  = "ou=people,dc=example,dc=com"
+	testLDAPPartnerDN = "ou=partners,dc=example,dc=com"
+	testLDAPMovedDN   = "ou=moved,dc=example,dc=com"
+)
+
+var testLDAPPeople = []ldapEntry{
+	{DN: "uid=jdoe," + testLDAPPeopleDN, Attributes: map[string][]string{
+		"objectClass": {"top", "person"},
+		"uid":         {"jdoe"},
+		"cn":          {"John Doe"},
+		"mail":        {"jdoe@example.com"},
+	}},
+	{DN: "cn=Ann Smith," + testLDAPPeopleDN, Attributes: map[string][]string{
+		"objectClass":    {"top", "person"},
+		"sAMAccountName": {"asmith"},
+		"cn":             {"Ann Smith"},
+		"mail":           {"asmith@example.com", "ann@example.com"},
+	}},
+}
+
+// fakeLDAP is an LDAP stand-in. It accepts anonymous binds and the admin DN
+// with password "secret", and answers searches under testLDAPPeopleDN from
+// testLDAPPeople. A search under testLDAPPartnerDN also returns a referral
+// and one under testLDAPMovedDN fails with a referral result.
+type fakeLDAP struct {
+	addr       string
+	tlsConfig  *tls.Config
+	implicit   bool
+	requireTLS bool
+}
+
+func serveFakeLDAP(t *testing.T, pki *testPKI, implicit, requireTLS bool) *fakeLDAP {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	s := &fakeLDAP{
+		addr:       l.Addr().String(),
+		tlsConfig:  &tls.Config{Certificates: []tls.Certificate{pki.tlsCertificate(t, "ldap.example.com")}},
+		implicit:   implicit,
+		requireTLS: requireTLS,
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *fakeLDAP) url() string {
+	if s.implicit {
+		return "ldaps://" + s.addr
+	}
+	return "ldap://" + s.addr
+}
+
+func ldapTestResult(tag byte, code int, matchedDN, message string) []byte {
+	return berConstructed(tag,
+		berInt(berTagEnumerated, int64(code)),
+		berString(berTagOctetString, matchedDN),
+		berString(berTagOctetString, message))
+}
+
+func (s *fakeLDAP) serve(conn net.Conn) {
+	defer func() { conn.Close() }()
+	secure := s.implicit
+	if secure {
+		conn = tls.Server(conn, s.tlsConfig)
+	}
+	reader := bufio.NewReader(conn)
+	for {
+		packet, err := readBERPacket(reader)
+		if err != nil {
+			return
+		}
+		message, err := packet.children()
+		if err != nil || len(message) < 2 {
+			return
+		}
+		id, op := message[0].int(), message[1]
+		reply := func(op []byte) {
+			conn.Write(berConstructed(berTagSequence, berInt(berTagInteger, id), op))
+		}
+		fields, _ := op.children()
+
+		switch op.Tag {
+		case ldapTagExtendedRequest:
+			if len(fields) == 0 || string(fields[0].Value) != ldapStartTLSOID || secure {
+				reply(ldapTestResult(ldapTagExtendedResponse, 2, "", "unsupported extended operation"))
+				continue
+			}
+			reply(ldapTestResult(ldapTagExtendedResponse, ldapResultSuccess, "", ""))
+			conn = tls.Server(conn, s.tlsConfig)
+			reader = bufio.NewReader(conn)
+			secure = true
+
+		case ldapTagBindRequest:
+			dn, password := string(fields[1].Value), string(fields[2].Value)
+			switch {
+			case s.requireTLS && !secure:
+				reply(ldapTestResult(ldapTagBindResponse, 13, "", "TLS confidentiality required"))
+			case dn == "" && password == "", dn == testLDAPAdminDN && password == "secret":
+				reply(ldapTestResult(ldapTagBindResponse, ldapResultSuccess, "", ""))
+			default:
+				reply(ldapTestResult(ldapTagBindResponse, 49, "", "80090308: LdapErr: DSID-0C09044E, comment: AcceptSecurityContext error, data 52e, v4563"))
+			}
+
+		case ldapTagSearchRequest:
+			baseDN, sizeLimit, filter := string(fields[0].Value), int(fields[3].int()), fields[6]
+			requested, _ := fields[7].children()
+			switch baseDN {
+			case testLDAPPeopleDN, testLDAPPartnerDN:
+				if baseDN == testLDAPPartnerDN {
+					reply(berConstructed(ldapTagSearchResultReference,
+						berString(berTagOctetString, "ldap://partners.example.com/"+testLDAPPartnerDN)))
+				}
+				code, sent := ldapResultSuccess, 0
+				for _, entry := range testLDAPPeople {
+					if !ldapTestMatch(filter, entry) {
+						continue
+					}
+					if sent == sizeLimit {
+						code = ldapResultSizeLimitExceeded
+						break
+					}
+					reply(ldapTestEntry(entry, requested))
+					sent++
+				}
+				reply(ldapTestResult(ldapTagSearchResultDone, code, "", ""))
+			case testLDAPMovedDN:
+				reply(ldapTestResult(ldapTagSearchResultDone, 10, "", "Referral to another server"))
+			default:
+				reply(ldapTestResult(ldapTagSearchResultDone, 32, "dc=example,dc=com", "No such object"))
+			}
+
+		case ldapTagUnbindRequest:
+			return
+		}
+	}
+}
+
+// ldapTestMatch evaluates the parts of a compiled filter the tests use.
+func ldapTestMatch(filter *berPacket, entry ldapEntry) bool {
+	values := func(attr string) []string {
+		for name, values := range entry.Attributes {
+			if strings.EqualFold(name, attr) {
+				return values
+			}
+		}
+		return nil
+	}
+	children, _ := filter.children()
+	switch filter.Tag {
+	case ldapTagFilterAnd:
+		for _, child := range children {
+			if !ldapTestMatch(child, entry) {
+				return false
+			}
+		}
+		return true
+	case ldapTagFilterOr:
+		for _, child := range children {
+			if ldapTestMatch(child, entry) {
+				return true
+			}
+		}
+		return false
+	case ldapTagFilterNot:
+		return !ldapTestMatch(children[0], entry)
+	case ldapTagFilterPresent:
+		return len(values(string(filter.Value))) > 0
+	case ldapTagFilterEquality:
+		for _, value := range values(string(children[0].Value)) {
+			if strings.EqualFold(value, string(children[1].Value)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ldapTestEntry encodes a SearchResultEntry with the requested attributes,
+// or all of them when none are requested.
+func ldapTestEntry(entry ldapEntry, requested []*berPacket) []byte {
+	names := []string{}
+	for name := range entry.Attributes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	attributes := [][]byte{}
+	for _, name := range names {
+		include := len(requested) == 0
+		for _, r := range requested {
+			include = include || strings.EqualFold(string(r.Value), name)
+		}
+		if !include {
+			continue
+		}
+		values := [][]byte{}
+		for _, value := range entry.Attributes[name] {
+			values = append(values, berString(berTagOctetString, value))
+		}
+		attributes = append(attributes, berConstructed(berTagSequence,
+			berString(berTagOctetString, name),
+			berConstructed(berTagSet, values...)))
+	}
+	return berConstructed(ldapTagSearchResultEntry,
+		berString(berTagOctetString, entry.DN),
+		berConstructed(berTagSequence, attributes...))
+}
+
+func runLDAPCheck(t *testing.T, c *GoCmd, args ...string) (ldapCheckResult, error) {
+	t.Helper()
+	results, err := ldapCheckCommand(c, append(args, "timeout=5s")...)
+	if _, ok := err.(ErrCommandResponse); err != nil && !ok {
+		t.Fatalf("ldap_check: %v", err)
+	}
+	var result ldapCheckResult
+	if len(results) != 1 {
+		t.Fatalf("got %d results", len(results))
+	}
+	if err := json.Unmarshal([]byte(results[0]), &result); err != nil {
+		t.Fatal(err)
+	}
+	return result, err
+}
+
+func ldapStepNames(result ldapCheckResult) []string {
+	names := []string{}
+	for _, s := range result.Steps {
+		names = append(names, s.Name)
+	}
+	return names
+}
+
+func TestLDAPCheckBind(t *testing.T) {
+	s := serveFakeLDAP(t, newTestPKI(t), false, false)
+
+	for _, opts := range [][]string{
+		{},
+		{"bind_dn=" + testLDAPAdminDN, "bind_password=secret"},
+	} {
+		result, err := runLDAPCheck(t, newTestCmd(t), append([]string{s.url(), "--"}, opts...)...)
+		if err != nil {
+			t.Errorf("%q: %v", opts, err)
+			continue
+		}
+		if !result.Passed || !reflect.DeepEqual(ldapStepNames(result), []string{"bind"}) || result.TLSVersion != "" {
+			t.Errorf("%q: unexpected result %+v", opts, result)
+		}
+	}
+
+	result, err := runLDAPCheck(t, newTestCmd(t), s.url(), "--", "bind_dn="+testLDAPAdminDN, "bind_password=wrong")
+	want := "LDAP bind failed with 49 (invalidCredentials): 80090308: LdapErr: DSID-0C09044E, comment: AcceptSecurityContext error, data 52e, v4563 (invalid credentials)"
+	if err == nil || err.Error() != want {
+		t.Errorf("got error %v, want %q", err, want)
+	}
+	if result.Passed || len(result.Steps) != 1 {
+		t.Fatalf("unexpected result %+v", result)
+	}
+	step := result.Steps[0]
+	if step.ResultCode == nil || *step.ResultCode != 49 || step.ResultName != "invalidCredentials" || step.ADError != "invalid credentials" || step.Passed {
+		t.Errorf("unexpected bind step %+v", step)
+	}
+}
+
+func TestLDAPCheckBindWithoutPassword(t *testing.T) {
+	s := serveFakeLDAP(t, newTestPKI(t), false, false)
+	for _, opts := range [][]string{
+		{"bind_dn=" + testLDAPAdminDN},
+		{"bind_dn=" + testLDAPAdminDN, "bind_password="},
+	} {
+		_, err := ldapCheckCommand(newTestCmd(t), append([]string{s.url(), "--"}, opts...)...)
+		if err == nil || !strings.Contains(err.Error(), "bind_password is required") {
+			t.Errorf("%q: got %v, want a missing password error", opts, err)
+		}
+	}
+}
+
+func TestLDAPCheckTLS(t *testing.T) {
+	pki := newTestPKI(t)
+	tlsOpts := []string{"ca=" + pki.caPEM, "server_name=ldap.example.com", "bind_dn=" + testLDAPAdminDN, "bind_password=secret"}
+
+	s := serveFakeLDAP(t, pki, false, true)
+	result, err := runLDAPCheck(t, newTestCmd(t), append([]string{s.url(), "--", "start_tls=true"}, tlsOpts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.StartTLS || result.TLSVersion != "TLS 1.3" || !reflect.DeepEqual(ldapStepNames(result), []string{"start_tls", "bind"}) {
+		t.Errorf("unexpected result %+v", result)
+	}
+
+	_, err = runLDAPCheck(t, newTestCmd(t), append([]string{s.url(), "--"}, tlsOpts...)...)
+	if err == nil || !strings.HasPrefix(err.Error(), "LDAP bind failed with 13 (confidentialityRequired)") {
+		t.Errorf("bind without TLS: got %v", err)
+	}
+
+	result, err = runLDAPCheck(t, newTestCmd(t), s.url(), "--", "start_tls=true", "server_name=ldap.example.com")
+	if err == nil || ldapStepNames(result)[len(result.Steps)-1] != "tls_handshake" {
+		t.Errorf("untrusted certificate: got %v, steps %q", err, ldapStepNames(result))
+	}
+
+	s = serveFakeLDAP(t, pki, true, true)
+	result, err = runLDAPCheck(t, newTestCmd(t), append([]string{s.url(), "--"}, tlsOpts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.StartTLS || result.TLSVersion != "TLS 1.3" || !reflect.DeepEqual(ldapStepNames(result), []string{"bind"}) {
+		t.Errorf("ldaps: unexpected result %+v", result)
+	}
+	if _, err := ldapCheckCommand(newTestCmd(t), s.url(), "--", "start_tls=true"); err == nil {
+		t.Error("start_tls accepted with ldaps://")
+	}
+}
+
+func TestLDAPCheckSearch(t *testing.T) {
+	s := serveFakeLDAP(t, newTestPKI(t), false, false)
+	jdoe := ldapEntry{DN: testLDAPPeople[0].DN, Attributes: map[string][]string{
+		"email": {"jdoe@example.com"},
+		"name":  {"John Doe"},
+	}}
+	asmith := ldapEntry{DN: testLDAPPeople[1].DN, Attributes: map[string][]string{
+		"email": {"asmith@example.com", "ann@example.com"},
+		"name":  {"Ann Smith"},
+	}}
+	mapping := []string{"attribute=email=MAIL", "attribute=name=cn"}
+
+	tests := []struct {
+		name      string
+		opts      []string
+		filter    string
+		entries   []ldapEntry
+		referrals []string
+		code      int
+	}{
+		{
+			name:    "uid",
+			opts:    append([]string{"base_dn=" + testLDAPPeopleDN, "username=jdoe"}, mapping...),
+			filter:  "(&(objectClass=person)(|(uid=jdoe)(sAMAccountName=jdoe)))",
+			entries: []ldapEntry{jdoe},
+		},
+		{
+			name:    "sAMAccountName",
+			opts:    append([]string{"base_dn=" + testLDAPPeopleDN, "username=asmith"}, mapping...),
+			filter:  "(&(objectClass=person)(|(uid=asmith)(sAMAccountName=asmith)))",
+			entries: []ldapEntry{asmith},
+		},
+		{
+			name:    "custom filter",
+			opts:    append([]string{"base_dn=" + testLDAPPeopleDN, "username=John Doe", "filter=(&(cn={username})(!(uid=other)))"}, mapping...),
+			filter:  "(&(cn=John Doe)(!(uid=other)))",
+			entries: []ldapEntry{jdoe},
+		},
+		{
+			name:    "all attributes",
+			opts:    []string{"base_dn=" + testLDAPPeopleDN, "filter=(uid=*)"},
+			filter:  "(uid=*)",
+			entries: []ldapEntry{testLDAPPeople[0]},
+		},
+		{
+			name:    "size limit",
+			opts:    append([]string{"base_dn=" + testLDAPPeopleDN, "size_limit=1"}, mapping...),
+			filter:  defaultLDAPFilter,
+			entries: []ldapEntry{jdoe},
+			code:    ldapResultSizeLimitExceeded,
+		},
+		{
+			name:      "referral",
+			opts:      append([]string{"base_dn=" + testLDAPPartnerDN, "username=asmith"}, mapping...),
+			filter:    "(&(objectClass=person)(|(uid=asmith)(sAMAccountName=asmith)))",
+			entries:   []ldapEntry{asmith},
+			referrals: []string{"ldap://partners.example.com/" + testLDAPPartnerDN},
+		},
+	}
+	for _, test := range tests {
+		result, err := runLDAPCheck(t, newTestCmd(t), append([]string{s.url(), "--"}, test.opts...)...)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if result.Filter != test.filter {
+			t.Errorf("%s: filter %q, want %q", test.name, result.Filter, test.filter)
+		}
+		if !reflect.DeepEqual(result.Entries, test.entries) {
+			t.Errorf("%s: entries %+v, want %+v", test.name, result.Entries, test.entries)
+		}
+		if !reflect.DeepEqual(result.Referrals, test.referrals) {
+			t.Errorf("%s: referrals %q, want %q", test.name, result.Referrals, test.referrals)
+		}
+		search := result.Steps[len(result.Steps)-1]
+		if search.Name != "search" || !search.Passed || *search.ResultCode != test.code || search.ResultName != ldapResultName(test.code) {
+			t.Errorf("%s: unexpected search step %+v", test.name, search)
+		}
+	}
+}
+
+func TestLDAPCheckSearchFailures(t *testing.T) {
+	s := serveFakeLDAP(t, newTestPKI(t), false, false)
+	tests := []struct {
+		name   string
+		opts   []string
+		step   string
+		errMsg string
+	}{
+		{
+			name:   "no match",
+			opts:   []string{"base_dn=" + testLDAPPeopleDN, "username=j*"},
+			step:   "match",
+			errMsg: "LDAP match failed: No entries matched the filter",
+		},
+		{
+			name:   "referral result",
+			opts:   []string{"base_dn=" + testLDAPMovedDN},
+			step:   "search",
+			errMsg: "LDAP search failed with 10 (referral): Referral to another server",
+		},
+		{
+			name:   "no such object",
+			opts:   []string{"base_dn=ou=missing,dc=example,dc=com"},
+			step:   "search",
+			errMsg: "LDAP search failed with 32 (noSuchObject): No such object",
+		},
+	}
+	for _, test := range tests {
+		result, err := runLDAPCheck(t, newTestCmd(t), append([]string{s.url(), "--"}, test.opts...)...)
+		if err == nil || err.Error() != test.errMsg {
+			t.Errorf("%s: got error %v, want %q", test.name, err, test.errMsg)
+		}
+		if failed := result.Steps[len(result.Steps)-1]; result.Passed || failed.Name != test.step || failed.Passed {
+			t.Errorf("%s: unexpected result %+v", test.name, result)
+		}
+		if test.name == "no such object" && result.Steps[len(result.Steps)-1].MatchedDN != "dc=example,dc=com" {
+			t.Errorf("%s: matched DN not reported: %+v", test.name, result.Steps)
+		}
+	}
+}
+
+func TestLDAPCheckThroughProxy(t *testing.T) {
+	s := serveFakeLDAP(t, newTestPKI(t), false, false)
+	proxy := serveTestProxy(t, true, "")
+	c := newProxiedCmd(t, CmdConfig{ProxyURL: proxy.url("socks5")})
+	result, err := runLDAPCheck(t, c, s.url(), "--", "base_dn="+testLDAPPeopleDN, "username=jdoe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Entries) != 1 {
+		t.Errorf("unexpected result %+v", result)
+	}
+	if proxy.count() != 1 {
+		t.Errorf("%d proxied connections, want 1", proxy.count())
+	}
+}
+
+func TestLDAPCheckErrors(t *testing.T) {
+	for _, args := range [][]string{
+		{"ldap://127.0.0.1", "--", "username=jdoe"},
+		{"ldap://127.0.0.1", "--", "base_dn=" + testLDAPPeopleDN, "username=jdoe", "filter=(objectClass=person)"},
+		{"ldap://127.0.0.1", "--", "base_dn=" + testLDAPPeopleDN, "filter=(uid={username})"},
+		{"ldap://127.0.0.1", "--", "base_dn=" + testLDAPPeopleDN, "filter=(cn=**)"},
+		{"ldap://127.0.0.1", "--", "base_dn=" + testLDAPPeopleDN, "scope=subtree"},
+		{"ldap://127.0.0.1", "--", "base_dn=" + testLDAPPeopleDN, "attribute=mail"},
+		{"ldap://127.0.0.1", "--", "start_tls=maybe"},
+		{"http://127.0.0.1"},
+	} {
+		if _, err := ldapCheckCommand(newTestCmd(t), args...); err == nil {
+			t.Errorf("ldap_check(%q) succeeded", args)
+		} else if _, ok := err.(ErrCommandResponse); ok {
+			t.Errorf("ldap_check(%q): %v was not rejected before connecting", args, err)
+		}
+	}
+	if _, err := ldapCheckCommand(newTestCmd(t)); err != ErrMissingArgs {
+		t.Errorf("got %v, want ErrMissingArgs", err)
+	}
+}
+
+func TestCompileLDAPFilter(t *testing.T) {
+	octet := func(s string) []byte { return berString(berTagOctetString, s) }
+	item := func(tag byte, attr, value string) []byte { return berConstructed(tag, octet(attr), octet(value)) }
+	substrings := func(attr string, parts ...[]byte) []byte {
+		return berConstructed(ldapTagFilterSubstrings, octet(attr), berConstructed(berTagSequence, parts...))
+	}
+
+	tests := []struct {
+		filter string
+		want   []byte
+	}{
+		{"(cn=John Doe)", item(ldapTagFilterEquality, "cn", "John Doe")},
+		{"  (cn=x)  ", item(ldapTagFilterEquality, "cn", "x")},
+		{"(uid=*)", berString(ldapTagFilterPresent, "uid")},
+		{"(age>=21)", item(ldapTagFilterGreaterOrEq, "age", "21")},
+		{"(age<=65)", item(ldapTagFilterLessOrEq, "age", "65")},
+		{"(cn~=jon)", item(ldapTagFilterApprox, "cn", "jon")},
+		{"(cn=a\\2ab\\29)", item(ldapTagFilterEquality, "cn", "a*b)")},
+		{"(cn=jo*)", substrings("cn", berString(ldapTagSubstringInitial, "jo"))},
+		{"(cn=*doe)", substrings("cn", berString(ldapTagSubstringFinal, "doe"))},
+		{"(cn=*oh*)", substrings("cn", berString(ldapTagSubstringAny, "oh"))},
+		{"(cn=j*o*h*n)", substrings("cn",
+			berString(ldapTagSubstringInitial, "j"),
+			berString(ldapTagSubstringAny, "o"),
+			berString(ldapTagSubstringAny, "h"),
+			berString(ldapTagSubstringFinal, "n"))},
+		{"(cn=\\2a*)", substrings("cn", berString(ldapTagSubstringInitial, "*"))},
+		{"(&(objectClass=person)(uid=jdoe))", berConstructed(ldapTagFilterAnd,
+			item(ldapTagFilterEquality, "objectClass", "person"),
+			item(ldapTagFilterEquality, "uid", "jdoe"))},
+		{"(|(uid=a)(uid=b)(mail=*))", berConstructed(ldapTagFilterOr,
+			item(ldapTagFilterEquality, "uid", "a"),
+			item(ldapTagFilterEquality, "uid", "b"),
+			berString(ldapTagFilterPresent, "mail"))},
+		{"(!(uid=a))", berConstructed(ldapTagFilterNot, item(ldapTagFilterEquality, "uid", "a"))},
+		{"(&(a=1)(!(|(b=2)(c=*))))", berConstructed(ldapTagFilterAnd,
+			item(ldapTagFilterEquality, "a", "1"),
+			berConstructed(ldapTagFilterNot, berConstructed(ldapTagFilterOr,
+				item(ldapTagFilterEquality, "b", "2"),
+				berString(ldapTagFilterPresent, "c"))))},
+		{"(&)", berConstructed(ldapTagFilterAnd)},
+	}
+	for _, test := range tests {
+		got, err := compileLDAPFilter(test.filter)
+		if err != nil {
+			t.Errorf("compileLDAPFilter(%q): %v", test.filter, err)
+			continue
+		}
+		if !bytes.Equal(got, test.want) {
+			t.Errorf("compileLDAPFilter(%q) = %x, want %x", test.filter, got, test.want)
+		}
+	}
+
+	for _, filter := range []string{
+		"",
+		"cn=x",
+		"(cn=x",
+		"(cn=x))",
+		"(=x)",
+		"(cn)",
+		"(>=1)",
+		"(cn:dn:=x)",
+		"(cn=**)",
+		"(cn=***)",
+		"(cn=a\\2)",
+		"(cn=a\\zz)",
+		"(&(cn=x)",
+		"(!(cn=x)(cn=y))",
+		"(|(cn=x)cn=y)",
+	} {
+		if got, err := compileLDAPFilter(filter); err == nil {
+			t.Errorf("compileLDAPFilter(%q) = %x, want an error", filter, got)
+		}
+	}
+}
+
+func TestLDAPEscapeFilter(t *testing.T) {
+	tests := []struct {
+		value   string
+		escaped string
+	}{
+		{"jdoe", "jdoe"},
+		{"j*", "j\\2a"},
+		{"a(b)c\\", "a\\28b\\29c\\5c"},
+		{"nul\x00", "nul\\00"},
+		{"Jörg", "Jörg"},
+	}
+	for _, test := range tests {
+		escaped := ldapEscapeFilter(test.value)
+		if escaped != test.escaped {
+			t.Errorf("ldapEscapeFilter(%q) = %q, want %q", test.value, escaped, test.escaped)
+		}
+		if value, err := ldapUnescapeFilter(escaped); err != nil || value != test.value {
+			t.Errorf("ldapUnescapeFilter(%q) = %q, %v, want %q", escaped, value, err, test.value)
+		}
+	}
+}
+
+func TestLDAPUnescapeFilter(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"plain", "plain"},
+		{"\\2a", "*"},
+		{"a\\2A\\28b", "a*(b"},
+		{"\\c3\\b6", "ö"},
+		{"\\5c\\5c", "\\\\"},
+	}
+	for _, test := range tests {
+		if got, err := ldapUnescapeFilter(test.value); err != nil || got != test.want {
+			t.Errorf("ldapUnescapeFilter(%q) = %q, %v, want %q", test.value, got, err, test.want)
+		}
+	}
+	for _, value := range []string{"\\", "a\\", "a\\2", "\\g0", "\\2g", "\\ 2a"} {
+		if got, err := ldapUnescapeFilter(value); err == nil {
+			t.Errorf("ldapUnescapeFilter(%q) = %q, want an error", value, got)
+		}
+	}
+}
+
+func TestBERInt(t *testing.T) {
+	tests := []struct {
+		n    int64
+		want []byte
+	}{
+		{0, []byte{0x02, 0x01, 0x00}},
+		{1, []byte{0x02, 0x01, 0x01}},
+		{127, []byte{0x02, 0x01, 0x7f}},
+		{128, []byte{0x02, 0x02, 0x00, 0x80}},
+		{255, []byte{0x02, 0x02, 0x00, 0xff}},
+		{256, []byte{0x02, 0x02, 0x01, 0x00}},
+		{-1, []byte{0x02, 0x01, 0xff}},
+		{-128, []byte{0x02, 0x01, 0x80}},
+		{-129, []byte{0x02, 0x02, 0xff, 0x7f}},
+		{1 << 31, []byte{0x02, 0x05, 0x00, 0x80, 0x00, 0x00, 0x00}},
+		{-1 << 40, []byte{0x02, 0x06, 0xff, 0x00, 0x00, 0x00, 0x00, 0x00}},
+	}
+	for _, test := range tests {
+		encoded := berInt(berTagInteger, test.n)
+		if !bytes.Equal(encoded, test.want) {
+			t.Errorf("berInt(%d) = %x, want %x", test.n, encoded, test.want)
+		}
+		packet, err := readBERPacket(bytes.NewReader(encoded))
+		if err != nil {
+			t.Errorf("readBERPacket(%x): %v", encoded, err)
+			continue
+		}
+		if packet.Tag != berTagInteger || packet.int() != test.n {
+			t.Errorf("round trip of %d: tag %#x value %d", test.n, packet.Tag, packet.int())
+		}
+	}
+}
+
+func TestBEREncode(t *testing.T) {
+	tests := []struct {
+		length int
+		header []byte
+	}{
+		{0, []byte{0x04, 0x00}},
+		{127, []byte{0x04, 0x7f}},
+		{128, []byte{0x04, 0x81, 0x80}},
+		{255, []byte{0x04, 0x81, 0xff}},
+		{256, []byte{0x04, 0x82, 0x01, 0x00}},
+		{65535, []byte{0x04, 0x82, 0xff, 0xff}},
+		{70000, []byte{0x04, 0x83, 0x01, 0x11, 0x70}},
+	}
+	for _, test := range tests {
+		value := bytes.Repeat([]byte{'x'}, test.length)
+		encoded := berEncode(berTagOctetString, value)
+		if !bytes.HasPrefix(encoded, test.header) || len(encoded) != len(test.header)+test.length {
+			t.Errorf("berEncode of %d bytes: header %x, want %x", test.length, encoded[:len(test.header)], test.header)
+			continue
+		}
+		// Packets follow each other on a connection, so the reader must
+		// stop at the end of the first one.
+		r := bytes.NewReader(append(encoded, berBool(berTagBoolean, true)...))
+		packet, err := readBERPacket(r)
+		if err != nil {
+			t.Errorf("readBERPacket of %d bytes: %v", test.length, err)
+			continue
+		}
+		if packet.Tag != berTagOctetString || !bytes.Equal(packet.Value, value) {
+			t.Errorf("round trip of %d bytes: tag %#x, %d bytes", test.length, packet.Tag, len(packet.Value))
+		}
+		next, err := readBERPacket(r)
+		if err != nil || next.Tag != berTagBoolean || !bytes.Equal(next.Value, []byte{0xff}) {
+			t.Errorf("packet after %d bytes: %+v, %v", test.length, next, err)
+		}
+	}
+
+	nested := berConstructed(berTagSequence,
+		berInt(berTagInteger, 7),
+		berConstructed(berTagSet, berString(berTagOctetString, strings.Repeat("v", 200))),
+		berBool(berTagBoolean, false))
+	packet, err := readBERPacket(bytes.NewReader(nested))
+	if err != nil {
+		t.Fatal(err)
+	}
+	children, err := packet.children()
+	if err != nil || len(children) != 3 {
+		t.Fatalf("children: %d, %v", len(children), err)
+	}
+	set, err := children[1].children()
+	if children[0].int() != 7 || err != nil || len(set) != 1 || len(set[0].Value) != 200 || children[2].Value[0] != 0 {
+		t.Errorf("unexpected children %+v", children)
+	}
+}
+
+func TestReadBERPacketErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		err  error
+	}{
+		{"empty", nil, io.EOF},
+		{"short header", []byte{0x04}, io.ErrUnexpectedEOF},
+		{"indefinite length", []byte{0x30, 0x80, 0x00, 0x00}, errMalformedBER},
+		{"length of length too long", []byte{0x04, 0x85, 0x01, 0x00, 0x00, 0x00, 0x00}, errMalformedBER},
+		{"over the size limit", []byte{0x04, 0x84, 0x01, 0x00, 0x00, 0x01}, errMalformedBER},
+		{"truncated length", []byte{0x04, 0x82, 0x01}, io.ErrUnexpectedEOF},
+		{"truncated value", []byte{0x04, 0x05, 'a', 'b'}, io.ErrUnexpectedEOF},
+	}
+	for _, test := range tests {
+		if _, err := readBERPacket(bytes.NewReader(test.data)); err != test.err {
+			t.Errorf("%s: got %v, want %v", test.name, err, test.err)
+		}
+	}
+
+	packet := &berPacket{Tag: berTagSequence, Value: []byte{0x04, 0x05, 'a'}}
+	if _, err := packet.children(); err != errMalformedBER {
+		t.Errorf("children of a truncated value: got %v, want errMalformedBER", err)
+	}
+}
@@ -0,0 +1,139 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+)
+
+const defaultOutboundTarget = "8.8.8.8:53"
+
+var dockerInterfacePrefixes = []string{"docker", "veth", "br-"}
+
+type interfaceInfo struct {
+	Name         string   `json:"name"`
+	Index        int      `json:"index"`
+	MTU          int      `json:"mtu"`
+	HardwareAddr string   `json:"hardware_addr,omitempty"`
+	Flags        []string `json:"flags"`
+	Addresses    []string `json:"addresses"`
+	Outbound     bool     `json:"outbound"`
+}
+
+type outboundInfo struct {
+	Target    string `json:"target"`
+	Interface string `json:"interface,omitempty"`
+	Address   string `json:"address,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+type localIPsResult struct {
+	Interfaces []interfaceInfo `json:"interfaces"`
+	Outbound   outboundInfo    `json:"outbound"`
+}
+
+func localIPsCommand(c *GoCmd, args ...string) ([]string, error) {
+	// Options:
+	// target: host:port used to find the outbound address, no traffic is sent
+	// skip_loopback: omit loopback interfaces
+	// skip_docker: omit docker0, veth and docker bridge interfaces
+	// exclude: comma separated interface name prefixes to omit
+	_, opts := parseArgs(args)
+	skipLoopback, err := opts.getBool("skip_loopback", false)
+	if err != nil {
+		return nil, err
+	}
+	skipDocker, err := opts.getBool("skip_docker", false)
+	if err != nil {
+		return nil, err
+	}
+	excludes := opts.getList("exclude")
+	if skipDocker {
+		excludes = append(excludes, dockerInterfacePrefixes...)
+	}
+	target := opts.get("target", defaultOutboundTarget)
+	if _, _, err := net.SplitHostPort(target); err != nil {
+		return nil, fmt.Errorf("Invalid target %q: %v", target, err)
+	}
+
+	result := localIPsResult{
+		Outbound: outboundInfo{Target: target},
+	}
+	outboundIP, err := c.outboundAddress(target)
+	if err != nil {
+		result.Outbound.Error = err.Error()
+	} else {
+		result.Outbound.Address = outboundIP.String()
+	}
+
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	infos := []interfaceInfo{}
+	for _, iface := range ifaces {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			return nil, err
+		}
+		info := interfaceInfo{
+			Name:         iface.Name,
+			Index:        iface.Index,
+			MTU:          iface.MTU,
+			HardwareAddr: iface.HardwareAddr.String(),
+			Flags:        []string{},
+			Addresses:    []string{},
+		}
+		if iface.Flags != 0 {
+			info.Flags = strings.Split(iface.Flags.String(), "|")
+		}
+		for _, addr := range addrs {
+			info.Addresses = append(info.Addresses, addr.String())
+			if ipNet, ok := addr.(*net.IPNet); ok && outboundIP != nil && ipNet.IP.Equal(outboundIP) {
+				info.Outbound = true
+				result.Outbound.Interface = iface.Name
+			}
+		}
+		infos = append(infos, info)
+	}
+	result.Interfaces = filterInterfaces(infos, skipLoopback, excludes)
+
+	return jsonResult(result)
+}
+
+// filterInterfaces omits loopback interfaces when skipLoopback is set and
+// interfaces whose names start with one of excludes.
+func filterInterfaces(infos []interfaceInfo, skipLoopback bool, excludes []string) []interfaceInfo {
+	filtered := []interfaceInfo{}
+	for _, info := range infos {
+		if skipLoopback && containsString(info.Flags, net.FlagLoopback.String()) {
+			continue
+		}
+		if hasAnyPrefix(info.Name, excludes) {
+			continue
+		}
+		filtered = append(filtered, info)
+	}
+	return filtered
+}
+
+// outboundAddress returns the local address the kernel would use to reach
+// target. Connecting a UDP socket selects the route without sending packets.
+func (c *GoCmd) outboundAddress(target string) (net.IP, error) {
+	conn, err := c.dial(context.Background(), "udp", target, defaultDialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP, nil
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
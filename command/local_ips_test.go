@@ -0,0 +1,99 @@
+package command
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestFilterInterfaces(t *testing.T) {
+	infos := []interfaceInfo{
+		{Name: "lo", Flags: []string{"up", "loopback"}},
+		{Name: "eth0", Flags: []string{"up", "broadcast", "multicast"}},
+		{Name: "docker0", Flags: []string{"up", "broadcast", "multicast"}},
+		{Name: "veth1a2b3c", Flags: []string{"up", "broadcast", "multicast"}},
+		{Name: "br-0123456789ab", Flags: []string{"up", "broadcast", "multicast"}},
+		{Name: "bridge0", Flags: []string{"up", "broadcast", "multicast"}},
+		{Name: "wg0", Flags: []string{"up", "pointtopoint"}},
+	}
+	names := func(infos []interfaceInfo) []string {
+		names := []string{}
+		for _, info := range infos {
+			names = append(names, info.Name)
+		}
+		return names
+	}
+
+	tests := []struct {
+		skipLoopback bool
+		excludes     []string
+		want         []string
+	}{
+		{false, nil, []string{"lo", "eth0", "docker0", "veth1a2b3c", "br-0123456789ab", "bridge0", "wg0"}},
+		{true, nil, []string{"eth0", "docker0", "veth1a2b3c", "br-0123456789ab", "bridge0", "wg0"}},
+		{false, dockerInterfacePrefixes, []string{"lo", "eth0", "bridge0", "wg0"}},
+		{true, append([]string{"wg"}, dockerInterfacePrefixes...), []string{"eth0", "bridge0"}},
+		{false, []string{"eth0", "lo"}, []string{"docker0", "veth1a2b3c", "br-0123456789ab", "bridge0", "wg0"}},
+	}
+	for _, test := range tests {
+		got := names(filterInterfaces(infos, test.skipLoopback, test.excludes))
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("filterInterfaces(%v, %q) = %q, want %q", test.skipLoopback, test.excludes, got, test.want)
+		}
+	}
+}
+
+func TestLocalIPs(t *testing.T) {
+	results, err := localIPsCommand(newTestCmd(t), "--", "target=127.0.0.1:53")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var result localIPsResult
+	if err := json.Unmarshal([]byte(results[0]), &result); err != nil {
+		t.Fatal(err)
+	}
+	if result.Outbound.Address != "127.0.0.1" || result.Outbound.Interface == "" || result.Outbound.Error != "" {
+		t.Errorf("outbound = %+v", result.Outbound)
+	}
+	found := false
+	for _, info := range result.Interfaces {
+		if info.Name == result.Outbound.Interface {
+			found = info.Outbound
+		}
+	}
+	if !found {
+		t.Errorf("interface %s is not marked outbound in %+v", result.Outbound.Interface, result.Interfaces)
+	}
+
+	// The loopback interface is still reported as outbound when it is omitted.
+	results, err = localIPsCommand(newTestCmd(t), "--", "target=127.0.0.1:53", "skip_loopback=true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	result = localIPsResult{}
+	if err := json.Unmarshal([]byte(results[0]), &result); err != nil {
+		t.Fatal(err)
+	}
+	for _, info := range result.Interfaces {
+		if containsString(info.Flags, "loopback") {
+			t.Errorf("loopback interface %s was not omitted", info.Name)
+		}
+	}
+	if result.Outbound.Address != "127.0.0.1" || result.Outbound.Interface == "" {
+		t.Errorf("outbound = %+v", result.Outbound)
+	}
+}
+
+func TestLocalIPsErrors(t *testing.T) {
+	for _, args := range [][]string{
+		{"--", "target=8.8.8.8"},
+		{"--", "target=dns.google"},
+		{"--", "target=[::1"},
+		{"--", "skip_loopback=maybe"},
+		{"--", "skip_docker=maybe"},
+	} {
+		if _, err := localIPsCommand(newTestCmd(t), args...); err == nil {
+			t.Errorf("local_ips(%q) succeeded", args)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package command
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/fsouza/go-dockerclient"
+)
+
+const (
+	// ManifestVersion is the command protocol version this library speaks.
+	// It must match the version in the manifest baked into the image.
+	ManifestVersion = 1
+
+	manifestFile = "manifest.json"
+)
+
+// ErrManifestNotFound is returned by ReadManifest for images built before
+// the manifest was introduced.
+var ErrManifestNotFound = errors.New("Command image has no manifest")
+
+var (
+	// containerCommands is populated from the image manifest by
+	// RegisterContainerCommands.
+	containerCommands   = manifestCommands(DefaultManifest())
+	containerCommandsMu sync.RWMutex
+)
+
+// Manifest describes the container commands available in a command image.
+type Manifest struct {
+	Version  int               `json:"version"`
+	Commands []ManifestCommand `json:"commands"`
+}
+
+type ManifestCommand struct {
+	Name   string        `json:"name"`
+	Script string        `json:"script"`
+	Args   []ManifestArg `json:"args"`
+}
+
+type ManifestArg struct {
+	Name     string `json:"name"`
+	Required bool   `json:"required"`
+}
+
+// RequiredArgs returns the number of leading required arguments.
+func (c ManifestCommand) RequiredArgs() int {
+	n := 0
+	for i, arg := range c.Args {
+		if arg.Required {
+			n = i + 1
+		}
+	}
+	return n
+}
+
+// ReadManifest reads the manifest from the command image without starting a
+// container.
+func ReadManifest(client *docker.Client, config CmdConfig) (*Manifest, error) {
+	container, err := createContainer(client, config.ContainerRepository, config.ContainerTag, []string{"true"})
+	if err != nil {
+		return nil, err
+	}
+	defer removeContainer(client, container.ID)
+
+	resource := path.Join(config.CommandsDir, manifestFile)
+	log.Debugf("reading manifest %s from container %s", resource, container.ID)
+	var buf bytes.Buffer
+	opts := docker.CopyFromContainerOptions{
+		OutputStream: &buf,
+		Container:    container.ID,
+		Resource:     resource,
+	}
+	if err := client.CopyFromContainer(opts); err != nil {
+		// The container was just created, so a missing container means a
+		// missing resource.
+		if _, ok := err.(*docker.NoSuchContainer); ok {
+			return nil, ErrManifestNotFound
+		}
+		return nil, fmt.Errorf("Error reading command manifest %s: %v", resource, err)
+	}
+
+	tr := tar.NewReader(&buf)
+	if _, err := tr.Next(); err != nil {
+		return nil, fmt.Errorf("Error reading command manifest %s: %v", resource, err)
+	}
+	manifest := &Manifest{}
+	if err := json.NewDecoder(tr).Decode(manifest); err != nil {
+		return nil, fmt.Errorf("Error parsing command manifest %s: %v", resource, err)
+	}
+	log.Debugf(" -> manifest version %d with %d commands read", manifest.Version, len(manifest.Commands))
+	return manifest, nil
+}
+
+// Validate checks that the manifest is compatible with this library.
+func (m *Manifest) Validate() error {
+	if m.Version != ManifestVersion {
+		return fmt.Errorf("Command image protocol version %d does not match libcmd protocol version %d", m.Version, ManifestVersion)
+	}
+	for _, cmd := range m.Commands {
+		if cmd.Name == "" || cmd.Script == "" {
+			return fmt.Errorf("Command manifest entry %q is missing a name or script", cmd.Name)
+		}
+	}
+	return nil
+}
+
+// DefaultManifest describes the scripts shipped in images that have no
+// manifest.
+func DefaultManifest() *Manifest {
+	return &Manifest{
+		Version: ManifestVersion,
+		Commands: []ManifestCommand{
+			{Name: "cert", Script: "cert.sh", Args: []ManifestArg{{Name: "bits"}}},
+			{Name: "random", Script: "random.sh", Args: []ManifestArg{{Name: "length"}, {Name: "charset"}}},
+			{Name: "raw", Script: "raw.sh", Args: []ManifestArg{{Name: "command", Required: true}}},
+		},
+	}
+}
+
+// RegisterContainerCommands replaces the set of container commands with the
+// commands listed in the manifest.
+func RegisterContainerCommands(m *Manifest) {
+	commands := manifestCommands(m)
+	containerCommandsMu.Lock()
+	defer containerCommandsMu.Unlock()
+	containerCommands = commands
+}
+
+func lookupContainerCommand(name string) (ManifestCommand, bool) {
+	containerCommandsMu.RLock()
+	defer containerCommandsMu.RUnlock()
+	cmd, ok := containerCommands[name]
+	return cmd, ok
+}
+
+func manifestCommands(m *Manifest) map[string]ManifestCommand {
+	commands := make(map[string]ManifestCommand, len(m.Commands))
+	for _, cmd := range m.Commands {
+		commands[cmd.Name] = cmd
+	}
+	return commands
+}
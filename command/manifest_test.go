@@ -0,0 +1,83 @@
+package command
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestDefaultManifestIsValid(t *testing.T) {
+	if err := DefaultManifest().Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestDefaultManifestMatchesImage checks that the manifest shipped in the
+// command image and the one used for images without a manifest agree, and
+// that every script it names is in the image.
+func TestDefaultManifestMatchesImage(t *testing.T) {
+	commandsDir := filepath.Join("..", buildCommandsDir)
+	data, err := ioutil.ReadFile(filepath.Join(commandsDir, manifestFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		t.Fatal(err)
+	}
+	if want := DefaultManifest(); !reflect.DeepEqual(&manifest, want) {
+		t.Errorf("%s/%s = %+v, DefaultManifest() = %+v", buildCommandsDir, manifestFile, manifest, *want)
+	}
+	for _, cmd := range manifest.Commands {
+		if _, err := os.Stat(filepath.Join(commandsDir, cmd.Script)); err != nil {
+			t.Errorf("%s: %v", cmd.Name, err)
+		}
+	}
+}
+
+func TestManifestValidate(t *testing.T) {
+	m := &Manifest{Version: ManifestVersion + 1}
+	if err := m.Validate(); err == nil {
+		t.Error("expected a version mismatch error")
+	}
+	m = &Manifest{Version: ManifestVersion, Commands: []ManifestCommand{{Name: "x"}}}
+	if err := m.Validate(); err == nil {
+		t.Error("expected an error for a command without a script")
+	}
+}
+
+func TestRequiredArgs(t *testing.T) {
+	cmd := ManifestCommand{Args: []ManifestArg{{Name: "a"}, {Name: "b", Required: true}, {Name: "c"}}}
+	if n := cmd.RequiredArgs(); n != 2 {
+		t.Errorf("RequiredArgs() = %d, want 2", n)
+	}
+}
+
+func TestRegisterContainerCommands(t *testing.T) {
+	defer RegisterContainerCommands(DefaultManifest())
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			NewContainerCmd("raw", CmdConfig{}, nil)
+		}()
+	}
+	RegisterContainerCommands(&Manifest{
+		Version:  ManifestVersion,
+		Commands: []ManifestCommand{{Name: "hello", Script: "hello.sh"}},
+	})
+	wg.Wait()
+
+	if _, err := NewContainerCmd("hello", CmdConfig{}, nil); err != nil {
+		t.Errorf("hello: %v", err)
+	}
+	if _, err := NewContainerCmd("raw", CmdConfig{}, nil); err != ErrCommandNotFound {
+		t.Errorf("raw: got %v, want ErrCommandNotFound", err)
+	}
+}
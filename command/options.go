@@ -0,0 +1,113 @@
+package command
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cmdOptions holds the key=value arguments passed to a go command. Options
+// may be repeated, so every value is kept in order.
+type cmdOptions map[string][]string
+
+// parseArgs splits args into positional arguments and key=value options.
+// Options follow a "--" argument, so positional arguments are never taken
+// for options whatever they contain. An option without a value is "true".
+func parseArgs(args []string) ([]string, cmdOptions) {
+	positional := []string{}
+	opts := cmdOptions{}
+	for i, arg := range args {
+		if arg == "--" {
+			for _, opt := range args[i+1:] {
+				parts := strings.SplitN(opt, "=", 2)
+				if len(parts) == 1 {
+					parts = append(parts, "true")
+				}
+				opts[parts[0]] = append(opts[parts[0]], parts[1])
+			}
+			break
+		}
+		positional = append(positional, arg)
+	}
+	return positional, opts
+}
+
+// lookup returns the last value of an option and whether it is set.
+func (o cmdOptions) lookup(key string) (string, bool) {
+	values := o[key]
+	if len(values) == 0 {
+		return "", false
+	}
+	return values[len(values)-1], true
+}
+
+// setDefault sets an option that is not already set.
+func (o cmdOptions) setDefault(key, value string) {
+	if _, ok := o.lookup(key); !ok {
+		o[key] = []string{value}
+	}
+}
+
+func (o cmdOptions) get(key, dflt string) string {
+	if value, ok := o.lookup(key); ok {
+		return value
+	}
+	return dflt
+}
+
+func (o cmdOptions) getInt(key string, dflt int) (int, error) {
+	value, ok := o.lookup(key)
+	if !ok {
+		return dflt, nil
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid value for %s: %q", key, value)
+	}
+	return i, nil
+}
+
+func (o cmdOptions) getBool(key string, dflt bool) (bool, error) {
+	value, ok := o.lookup(key)
+	if !ok {
+		return dflt, nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("Invalid value for %s: %q", key, value)
+	}
+	return b, nil
+}
+
+func (o cmdOptions) getDuration(key string, dflt time.Duration) (time.Duration, error) {
+	value, ok := o.lookup(key)
+	if !ok {
+		return dflt, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid value for %s: %q", key, value)
+	}
+	return d, nil
+}
+
+// getList returns a comma separated option as a list, skipping empty items.
+func (o cmdOptions) getList(key string) []string {
+	return splitList(o.get(key, ""))
+}
+
+// getAll returns every value of a repeated option.
+func (o cmdOptions) getAll(key string) []string {
+	return append([]string{}, o[key]...)
+}
+
+func splitList(value string) []string {
+	list := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
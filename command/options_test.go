@@ -0,0 +1,102 @@
+package command
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseArgs(t *testing.T) {
+	tests := []struct {
+		args       []string
+		positional []string
+		opts       cmdOptions
+	}{
+		{
+			args:       []string{},
+			positional: []string{},
+			opts:       cmdOptions{},
+		},
+		{
+			args:       []string{"host", "443"},
+			positional: []string{"host", "443"},
+			opts:       cmdOptions{},
+		},
+		{
+			// Positional arguments are never taken for options.
+			args:       []string{"user", "abc=def", "cn=admin,dc=example,dc=com"},
+			positional: []string{"user", "abc=def", "cn=admin,dc=example,dc=com"},
+			opts:       cmdOptions{},
+		},
+		{
+			args:       []string{"host", "--", "timeout=5s", "insecure", "body=a=b"},
+			positional: []string{"host"},
+			opts:       cmdOptions{"timeout": {"5s"}, "insecure": {"true"}, "body": {"a=b"}},
+		},
+		{
+			args:       []string{"--", "header=a", "header=b", "--"},
+			positional: []string{},
+			opts:       cmdOptions{"header": {"a", "b"}, "--": {"true"}},
+		},
+	}
+	for _, test := range tests {
+		positional, opts := parseArgs(test.args)
+		if !reflect.DeepEqual(positional, test.positional) {
+			t.Errorf("parseArgs(%q) positional = %q, want %q", test.args, positional, test.positional)
+		}
+		if !reflect.DeepEqual(opts, test.opts) {
+			t.Errorf("parseArgs(%q) opts = %q, want %q", test.args, opts, test.opts)
+		}
+	}
+}
+
+func TestOptionGetters(t *testing.T) {
+	_, opts := parseArgs([]string{"--", "n=3", "b=false", "d=2s", "list=a, ,b", "bad=x", "header=a", "header=b"})
+
+	if got := opts.get("missing", "dflt"); got != "dflt" {
+		t.Errorf("get default = %q", got)
+	}
+	if got, err := opts.getInt("n", 1); err != nil || got != 3 {
+		t.Errorf("getInt = %d, %v", got, err)
+	}
+	if _, err := opts.getInt("bad", 1); err == nil {
+		t.Error("getInt accepted an invalid value")
+	}
+	if got, err := opts.getBool("b", true); err != nil || got {
+		t.Errorf("getBool = %v, %v", got, err)
+	}
+	if got, err := opts.getDuration("d", 0); err != nil || got.Seconds() != 2 {
+		t.Errorf("getDuration = %v, %v", got, err)
+	}
+	if got := opts.getList("list"); !reflect.DeepEqual(got, []string{"a", "b"}) {
+		t.Errorf("getList = %q", got)
+	}
+	if got := opts.getAll("header"); !reflect.DeepEqual(got, []string{"a", "b"}) {
+		t.Errorf("getAll = %q", got)
+	}
+	if got := opts.getAll("missing"); len(got) != 0 {
+		t.Errorf("getAll missing = %q", got)
+	}
+	if got := opts.get("header", ""); got != "b" {
+		t.Errorf("get repeated = %q, want the last value", got)
+	}
+
+	// Values may contain newlines, which used to separate repeated options.
+	_, opts = parseArgs([]string{"--", "body=line 1\nline 2", "attribute=a=1"})
+	if got := opts.getAll("body"); !reflect.DeepEqual(got, []string{"line 1\nline 2"}) {
+		t.Errorf("getAll multi-line = %q", got)
+	}
+	getAll := opts.getAll("attribute")
+	getAll[0] = "changed"
+	if got := opts.get("attribute", ""); got != "a=1" {
+		t.Errorf("getAll returned the stored slice, get = %q", got)
+	}
+
+	opts.setDefault("attribute", "b=2")
+	opts.setDefault("new", "x")
+	if got := opts.getAll("attribute"); !reflect.DeepEqual(got, []string{"a=1"}) {
+		t.Errorf("setDefault replaced a value: %q", got)
+	}
+	if got, ok := opts.lookup("new"); !ok || got != "x" {
+		t.Errorf("lookup after setDefault = %q, %v", got, ok)
+	}
+}
@@ -0,0 +1,128 @@
+package command
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+type portCheckResult struct {
+	Address   string `json:"address"`
+	Port      int    `json:"port"`
+	Protocol  string `json:"protocol"`
+	Available bool   `json:"available"`
+	InUse     bool   `json:"in_use"`
+	Error     string `json:"error,omitempty"`
+	PID       int    `json:"pid,omitempty"`
+	Process   string `json:"process,omitempty"`
+}
+
+// portOwner identifies the process holding a socket.
+type portOwner struct {
+	PID     int
+	Process string
+}
+
+func portAvailableCommand(c *GoCmd, args ...string) ([]string, error) {
+	// Should be:
+	// 0..n: ports, optionally suffixed with /tcp or /udp, e.g. "80", "53/udp"
+	// Options:
+	// addresses: comma separated addresses to bind, defaults to all interfaces
+	// protocol: default protocol for ports without a suffix, "tcp" or "udp"
+	positional, opts := parseArgs(args)
+	if len(positional) < 1 {
+		return nil, ErrMissingArgs
+	}
+	addresses := opts.getList("addresses")
+	if len(addresses) == 0 {
+		addresses = []string{""}
+	}
+	defaultProtocol := opts.get("protocol", "tcp")
+
+	checks := []portCheckResult{}
+	for _, arg := range positional {
+		portStr, protocol := arg, defaultProtocol
+		if i := strings.Index(arg, "/"); i >= 0 {
+			portStr, protocol = arg[:i], arg[i+1:]
+		}
+		if protocol != "tcp" && protocol != "udp" {
+			return nil, fmt.Errorf("Protocol must be one of \"tcp\" or \"udp\": %q", arg)
+		}
+		port, err := strconv.Atoi(portStr)
+		if err != nil || port < 1 || port > 65535 {
+			return nil, fmt.Errorf("Invalid port: %q", arg)
+		}
+		for _, address := range addresses {
+			checks = append(checks, portCheck(address, port, protocol))
+		}
+	}
+
+	results, err := jsonResult(checks)
+	if err != nil {
+		return nil, err
+	}
+	inUse, failed := []string{}, []string{}
+	for _, check := range checks {
+		port := fmt.Sprintf("%s/%s", net.JoinHostPort(check.Address, strconv.Itoa(check.Port)), check.Protocol)
+		if check.InUse {
+			inUse = append(inUse, port)
+		} else if !check.Available {
+			failed = append(failed, fmt.Sprintf("%s (%s)", port, check.Error))
+		}
+	}
+	errMsgs := []string{}
+	if len(inUse) > 0 {
+		errMsgs = append(errMsgs, fmt.Sprintf("Ports in use: %s", strings.Join(inUse, ", ")))
+	}
+	if len(failed) > 0 {
+		errMsgs = append(errMsgs, fmt.Sprintf("Ports that could not be bound: %s", strings.Join(failed, ", ")))
+	}
+	if len(errMsgs) > 0 {
+		return results, ErrCommandResponse{strings.Join(errMsgs, "; ")}
+	}
+	return results, nil
+}
+
+// portCheck tries to bind the port and releases it immediately. Only
+// EADDRINUSE means the port is in use; other errors, such as EACCES for a
+// privileged port or EADDRNOTAVAIL for a foreign address, are reported as
+// failures to check it.
+func portCheck(address string, port int, protocol string) portCheckResult {
+	result := portCheckResult{
+		Address:  address,
+		Port:     port,
+		Protocol: protocol,
+	}
+	addr := net.JoinHostPort(address, strconv.Itoa(port))
+
+	var err error
+	if protocol == "udp" {
+		var conn net.PacketConn
+		if conn, err = net.ListenPacket(protocol, addr); err == nil {
+			conn.Close()
+		}
+	} else {
+		var listener net.Listener
+		if listener, err = net.Listen(protocol, addr); err == nil {
+			listener.Close()
+		}
+	}
+	if err == nil {
+		result.Available = true
+		return result
+	}
+
+	result.Error = err.Error()
+	if !errors.Is(err, syscall.EADDRINUSE) {
+		return result
+	}
+	result.InUse = true
+	if owner, err := findPortOwner(port, protocol); err == nil && owner != nil {
+		result.PID = owner.PID
+		result.Process = owner.Process
+	}
+	return result
+}
@@ -0,0 +1,90 @@
+package command
+
+import (
+	"encoding/json"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func runPortAvailable(t *testing.T, args ...string) ([]portCheckResult, error) {
+	t.Helper()
+	results, err := portAvailableCommand(nil, args...)
+	var checks []portCheckResult
+	if len(results) > 0 {
+		if err := json.Unmarshal([]byte(results[0]), &checks); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return checks, err
+}
+
+func TestPortAvailable(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := strconv.Itoa(l.Addr().(*net.TCPAddr).Port)
+	l.Close()
+
+	checks, err := runPortAvailable(t, port, port+"/udp", "--", "addresses=127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(checks) != 2 || !checks[0].Available || checks[0].InUse || checks[1].Protocol != "udp" || !checks[1].Available {
+		t.Errorf("checks = %+v", checks)
+	}
+}
+
+func TestPortAvailableInUse(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	port := strconv.Itoa(l.Addr().(*net.TCPAddr).Port)
+
+	checks, err := runPortAvailable(t, port, "--", "addresses=127.0.0.1")
+	if err == nil || !strings.HasPrefix(err.Error(), "Ports in use: 127.0.0.1:"+port+"/tcp") {
+		t.Fatalf("got %v, want ports in use", err)
+	}
+	if checks[0].Available || !checks[0].InUse || checks[0].Error == "" {
+		t.Errorf("check = %+v", checks[0])
+	}
+}
+
+func TestPortAvailableBindFailure(t *testing.T) {
+	// 192.0.2.1 is reserved for documentation, so it is not a local address
+	// and binding it fails with EADDRNOTAVAIL rather than EADDRINUSE.
+	checks, err := runPortAvailable(t, "8080", "--", "addresses=192.0.2.1")
+	if err == nil || !strings.HasPrefix(err.Error(), "Ports that could not be bound: 192.0.2.1:8080/tcp") {
+		t.Fatalf("got %v, want a bind failure", err)
+	}
+	if checks[0].Available || checks[0].InUse || checks[0].PID != 0 {
+		t.Errorf("check = %+v", checks[0])
+	}
+
+	if os.Geteuid() != 0 {
+		checks, err = runPortAvailable(t, "1", "--", "addresses=127.0.0.1")
+		if _, ok := err.(ErrCommandResponse); !ok || checks[0].InUse {
+			t.Errorf("privileged port: %v, %+v", err, checks)
+		}
+	}
+}
+
+func TestPortAvailableErrors(t *testing.T) {
+	for _, args := range [][]string{
+		{},
+		{"http"},
+		{"0"},
+		{"65536"},
+		{"80/sctp"},
+		{"80", "--", "protocol=sctp"},
+	} {
+		if _, err := portAvailableCommand(nil, args...); err == nil {
+			t.Errorf("port_available(%q) succeeded", args)
+		}
+	}
+}
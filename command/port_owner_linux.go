@@ -0,0 +1,87 @@
+//go:build linux
+// +build linux
+
+package command
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	procTCPListen    = "0A"
+	procUDPListening = "07"
+)
+
+// findPortOwner finds the process listening on port by looking up the socket
+// inode in /proc/net and then searching /proc/*/fd for it. Processes owned
+// by other users are only visible when running as root.
+func findPortOwner(port int, protocol string) (*portOwner, error) {
+	state := procTCPListen
+	if protocol == "udp" {
+		state = procUDPListening
+	}
+	inodes := map[string]bool{}
+	for _, name := range []string{protocol, protocol + "6"} {
+		if err := readProcNetInodes(filepath.Join("/proc/net", name), port, state, inodes); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	if len(inodes) == 0 {
+		return nil, nil
+	}
+
+	fds, err := filepath.Glob("/proc/[0-9]*/fd/*")
+	if err != nil {
+		return nil, err
+	}
+	for _, fd := range fds {
+		link, err := os.Readlink(fd)
+		if err != nil || !strings.HasPrefix(link, "socket:[") {
+			continue
+		}
+		if !inodes[strings.TrimSuffix(strings.TrimPrefix(link, "socket:["), "]")] {
+			continue
+		}
+		pidDir := filepath.Dir(filepath.Dir(fd))
+		pid, err := strconv.Atoi(filepath.Base(pidDir))
+		if err != nil {
+			continue
+		}
+		owner := &portOwner{PID: pid}
+		if comm, err := ioutil.ReadFile(filepath.Join(pidDir, "comm")); err == nil {
+			owner.Process = strings.TrimSpace(string(comm))
+		}
+		return owner, nil
+	}
+	return nil, nil
+}
+
+// readProcNetInodes adds the inodes of sockets in the given state bound to
+// port, as listed in a /proc/net/{tcp,udp}[6] table.
+func readProcNetInodes(path string, port int, state string, inodes map[string]bool) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	portHex := fmt.Sprintf(":%04X", port)
+	scanner := bufio.NewScanner(f)
+	scanner.Scan() // header
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 10 {
+			continue
+		}
+		if strings.HasSuffix(fields[1], portHex) && fields[3] == state {
+			inodes[fields[9]] = true
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+// +build !linux
+
+package command
+
+// findPortOwner is only implemented on Linux.
+func findPortOwner(port int, protocol string) (*portOwner, error) {
+	return nil, nil
+}
@@ -0,0 +1,472 @@
+package command
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultProxyHTTPURL       = "http://example.com/"
+	defaultProxyConnectTarget = "example.com:443"
+)
+
+type proxyProbe struct {
+	Target     string  `json:"target"`
+	StatusCode int     `json:"status_code,omitempty"`
+	LatencyMS  float64 `json:"latency_ms"`
+	Passed     bool    `json:"passed"`
+	Error      string  `json:"error,omitempty"`
+}
+
+type proxyCheckResult struct {
+	Proxy      string     `json:"proxy"`
+	HTTP       proxyProbe `json:"http"`
+	Connect    proxyProbe `json:"connect"`
+	AuthFailed bool       `json:"auth_failed"`
+	Passed     bool       `json:"passed"`
+}
+
+func proxyCheckCommand(c *GoCmd, args ...string) ([]string, error) {
+	// Options:
+	// proxy: proxy url, defaults to CmdConfig.ProxyURL
+	// user, password: proxy credentials, default to CmdConfig.ProxyUsername and ProxyPassword
+	// http_url: url fetched through the proxy with a plain HTTP request
+	// connect_target: host:port tunnelled to with CONNECT
+	// timeout: per request timeout
+	_, opts := parseArgs(args)
+	rawProxy := opts.get("proxy", c.config.ProxyURL)
+	if rawProxy == "" {
+		return nil, errors.New("No proxy configured")
+	}
+	proxyURL, err := parseProxyURL(rawProxy)
+	if err != nil {
+		return nil, err
+	}
+	if proxyURL.Scheme != "http" && proxyURL.Scheme != "https" {
+		return nil, fmt.Errorf("Unsupported proxy scheme for proxy_check: %q", proxyURL.Scheme)
+	}
+	if user := opts.get("user", c.config.ProxyUsername); user != "" {
+		proxyURL.User = url.UserPassword(user, opts.get("password", c.config.ProxyPassword))
+	}
+	timeout, err := opts.getDuration("timeout", defaultHTTPTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	result := proxyCheckResult{
+		Proxy:   proxyURL.Redacted(),
+		HTTP:    c.proxyHTTPProbe(proxyURL, opts.get("http_url", defaultProxyHTTPURL), timeout),
+		Connect: c.proxyConnectProbe(proxyURL, opts.get("connect_target", defaultProxyConnectTarget), timeout),
+	}
+	result.AuthFailed = result.HTTP.StatusCode == http.StatusProxyAuthRequired ||
+		result.Connect.StatusCode == http.StatusProxyAuthRequired
+	result.Passed = result.HTTP.Passed && result.Connect.Passed
+
+	results, err := jsonResult(result)
+	if err != nil {
+		return nil, err
+	}
+	if result.AuthFailed {
+		return results, ErrCommandResponse{fmt.Sprintf("Proxy authentication failed for %s", result.Proxy)}
+	}
+	if !result.Passed {
+		errMsgs := []string{}
+		for _, probe := range []proxyProbe{result.HTTP, result.Connect} {
+			if !probe.Passed {
+				errMsgs = append(errMsgs, fmt.Sprintf("%s: %s", probe.Target, probe.Error))
+			}
+		}
+		errMsg := fmt.Sprintf("Proxy check failed: %s", strings.Join(errMsgs, "; "))
+		return results, ErrCommandResponse{errMsg}
+	}
+	return results, nil
+}
+
+// proxyHTTPProbe fetches target through the proxy without following redirects.
+func (c *GoCmd) proxyHTTPProbe(proxyURL *url.URL, target string, timeout time.Duration) proxyProbe {
+	probe := proxyProbe{Target: target}
+	transport := c.httpTransport("tcp")
+	transport.Proxy = http.ProxyURL(proxyURL)
+	client := &http.Client{
+		Transport: transport,
+		Timeout:   timeout,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	start := time.Now()
+	resp, err := client.Get(target)
+	probe.LatencyMS = msSince(start)
+	if err != nil {
+		probe.Error = err.Error()
+		return probe
+	}
+	resp.Body.Close()
+	probe.StatusCode = resp.StatusCode
+	probe.Passed = proxyStatusOK(resp)
+	if !probe.Passed {
+		probe.Error = fmt.Sprintf("HTTP status %s", resp.Status)
+	}
+	return probe
+}
+
+// proxyConnectProbe opens a CONNECT tunnel to target. The tunnel is closed as
+// soon as the proxy answers.
+func (c *GoCmd) proxyConnectProbe(proxyURL *url.URL, target string, timeout time.Duration) (probe proxyProbe) {
+	probe.Target = target
+	start := time.Now()
+	defer func() { probe.LatencyMS = msSince(start) }()
+
+	conn, err := c.dialProxy(context.Background(), proxyURL, timeout)
+	if err != nil {
+		probe.Error = err.Error()
+		return probe
+	}
+	defer conn.Close()
+	conn.SetDeadline(start.Add(timeout))
+
+	resp, _, err := proxyConnect(conn, proxyURL, target)
+	if err != nil {
+		probe.Error = err.Error()
+		return probe
+	}
+	probe.StatusCode = resp.StatusCode
+	probe.Passed = resp.StatusCode == http.StatusOK
+	if !probe.Passed {
+		probe.Error = fmt.Sprintf("HTTP status %s", resp.Status)
+	}
+	return probe
+}
+
+// dialTCP connects to address, through a CONNECT or SOCKS5 tunnel when the
+// proxy configuration selects a proxy for it. Commands that speak protocols
+// other than HTTP use it so that they reach the same hosts as HTTP commands.
+func (c *GoCmd) dialTCP(ctx context.Context, address string, timeout time.Duration) (net.Conn, error) {
+	proxyURL, err := c.proxyFor(address)
+	if err != nil {
+		return nil, err
+	}
+	if proxyURL == nil {
+		return c.dial(ctx, "tcp", address, timeout)
+	}
+
+	start := time.Now()
+	var conn net.Conn
+	if proxyURL.Scheme == "socks5" {
+		conn, err = c.dial(ctx, "tcp", proxyAddr(proxyURL), timeout)
+	} else {
+		conn, err = c.dialProxy(ctx, proxyURL, timeout)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Unable to connect to proxy %s: %v", proxyURL.Redacted(), err)
+	}
+	conn.SetDeadline(start.Add(timeout))
+
+	tunnel := &tunnelConn{Conn: conn, target: address}
+	if proxyURL.Scheme == "socks5" {
+		err = socks5Connect(conn, proxyURL, address)
+	} else {
+		var resp *http.Response
+		resp, tunnel.reader, err = proxyConnect(conn, proxyURL, address)
+		if err == nil && resp.StatusCode != http.StatusOK {
+			err = fmt.Errorf("HTTP status %s", resp.Status)
+		}
+	}
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("Proxy %s could not connect to %s: %v", proxyURL.Redacted(), address, err)
+	}
+	conn.SetDeadline(time.Time{})
+	return tunnel, nil
+}
+
+// proxyFor returns the proxy dialTCP uses for address, or nil when it
+// connects directly.
+func (c *GoCmd) proxyFor(address string) (*url.URL, error) {
+	return c.proxyFunc()(&http.Request{URL: &url.URL{Scheme: "https", Host: address}})
+}
+
+// dialProxy connects to an http or https proxy.
+func (c *GoCmd) dialProxy(ctx context.Context, proxyURL *url.URL, timeout time.Duration) (net.Conn, error) {
+	conn, err := c.dial(ctx, "tcp", proxyAddr(proxyURL), timeout)
+	if err != nil {
+		return nil, err
+	}
+	if proxyURL.Scheme != "https" {
+		return conn, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	tlsConn := tls.Client(conn, &tls.Config{ServerName: proxyURL.Hostname()})
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
+// proxyConnect asks the proxy on conn for a tunnel to target. The returned
+// reader holds data the target sent after the proxy answered.
+func proxyConnect(conn net.Conn, proxyURL *url.URL, target string) (*http.Response, *bufio.Reader, error) {
+	req := &http.Request{
+		Method: "CONNECT",
+		URL:    &url.URL{Opaque: target},
+		Host:   target,
+		Header: http.Header{},
+	}
+	if proxyURL.User != nil {
+		password, _ := proxyURL.User.Password()
+		auth := base64.StdEncoding.EncodeToString([]byte(proxyURL.User.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+auth)
+	}
+	if err := req.Write(conn); err != nil {
+		return nil, nil, err
+	}
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		return nil, nil, err
+	}
+	resp.Body.Close()
+	return resp, reader, nil
+}
+
+// socks5Connect asks the SOCKS5 proxy on conn for a tunnel to target, with
+// username and password authentication if the proxy url has credentials.
+func socks5Connect(conn net.Conn, proxyURL *url.URL, target string) error {
+	host, portStr, err := net.SplitHostPort(target)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port < 1 || port > 65535 || len(host) > 255 {
+		return fmt.Errorf("Invalid target %q", target)
+	}
+
+	method := byte(0x00)
+	if proxyURL.User != nil {
+		method = 0x02
+	}
+	if _, err := conn.Write([]byte{0x05, 0x01, method}); err != nil {
+		return err
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[0] != 0x05 || reply[1] != method {
+		return errors.New("SOCKS5 authentication method not accepted")
+	}
+	if method == 0x02 {
+		username := proxyURL.User.Username()
+		password, _ := proxyURL.User.Password()
+		if len(username) > 255 || len(password) > 255 {
+			return errors.New("SOCKS5 credentials are too long")
+		}
+		auth := append([]byte{0x01, byte(len(username))}, username...)
+		auth = append(append(auth, byte(len(password))), password...)
+		if _, err := conn.Write(auth); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(conn, reply); err != nil {
+			return err
+		}
+		if reply[1] != 0x00 {
+			return errors.New("SOCKS5 authentication failed")
+		}
+	}
+
+	req := []byte{0x05, 0x01, 0x00}
+	if ip := net.ParseIP(host); ip != nil && ip.To4() != nil {
+		req = append(append(req, 0x01), ip.To4()...)
+	} else if ip != nil {
+		req = append(append(req, 0x04), ip.To16()...)
+	} else {
+		req = append(append(req, 0x03, byte(len(host))), host...)
+	}
+	req = append(req, byte(port>>8), byte(port))
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return err
+	}
+	if header[1] != 0x00 {
+		return fmt.Errorf("SOCKS5 connect failed with code %d", header[1])
+	}
+	// Skip the bound address, which is not needed.
+	var addrLen int
+	switch header[3] {
+	case 0x01:
+		addrLen = net.IPv4len
+	case 0x04:
+		addrLen = net.IPv6len
+	case 0x03:
+		if _, err := io.ReadFull(conn, reply[:1]); err != nil {
+			return err
+		}
+		addrLen = int(reply[0])
+	default:
+		return errors.New("Invalid SOCKS5 reply")
+	}
+	_, err = io.ReadFull(conn, make([]byte, addrLen+2))
+	return err
+}
+
+// tunnelConn is a connection tunnelled through a proxy. Its remote address
+// is the target rather than the proxy.
+type tunnelConn struct {
+	net.Conn
+	target string
+	reader *bufio.Reader
+}
+
+func (c *tunnelConn) Read(b []byte) (int, error) {
+	if c.reader != nil && c.reader.Buffered() > 0 {
+		return c.reader.Read(b)
+	}
+	return c.Conn.Read(b)
+}
+
+func (c *tunnelConn) RemoteAddr() net.Addr {
+	return tunnelAddr(c.target)
+}
+
+type tunnelAddr string
+
+func (a tunnelAddr) Network() string { return "tcp" }
+func (a tunnelAddr) String() string  { return string(a) }
+
+// proxyStatusOK reports whether the proxy forwarded the request. Gateway
+// errors are generated by the proxy when it cannot reach the target.
+func proxyStatusOK(resp *http.Response) bool {
+	switch resp.StatusCode {
+	case http.StatusProxyAuthRequired, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return false
+	}
+	return true
+}
+
+// proxyFunc returns the proxy selection used by every HTTP command. The
+// configured proxy takes precedence over the HTTP_PROXY environment variables
+// and hosts in CmdConfig.NoProxy always connect directly.
+func (c *GoCmd) proxyFunc() func(*http.Request) (*url.URL, error) {
+	proxy := http.ProxyFromEnvironment
+	if c.config.ProxyURL != "" {
+		proxyURL, err := parseProxyURL(c.config.ProxyURL)
+		proxy = func(*http.Request) (*url.URL, error) {
+			return proxyURL, err
+		}
+	}
+	noProxy := splitList(c.config.NoProxy)
+
+	return func(req *http.Request) (*url.URL, error) {
+		if noProxyMatches(req.URL, noProxy) {
+			return nil, nil
+		}
+		proxyURL, err := proxy(req)
+		if err != nil || proxyURL == nil {
+			return proxyURL, err
+		}
+		if c.config.ProxyUsername != "" && proxyURL.User == nil {
+			withUser := *proxyURL
+			withUser.User = url.UserPassword(c.config.ProxyUsername, c.config.ProxyPassword)
+			proxyURL = &withUser
+		}
+		return proxyURL, nil
+	}
+}
+
+// parseProxyURL parses a proxy url, defaulting to http when the scheme is
+// missing as curl does.
+func parseProxyURL(rawURL string) (*url.URL, error) {
+	if !strings.Contains(rawURL, "://") {
+		rawURL = "http://" + rawURL
+	}
+	proxyURL, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid proxy url: %v", err)
+	}
+	switch proxyURL.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, fmt.Errorf("Unsupported proxy scheme: %q", proxyURL.Scheme)
+	}
+	if proxyURL.Host == "" {
+		return nil, fmt.Errorf("Invalid proxy url: %q", rawURL)
+	}
+	return proxyURL, nil
+}
+
+func proxyAddr(proxyURL *url.URL) string {
+	if proxyURL.Port() != "" {
+		return proxyURL.Host
+	}
+	switch proxyURL.Scheme {
+	case "https":
+		return net.JoinHostPort(proxyURL.Hostname(), "443")
+	case "socks5":
+		return net.JoinHostPort(proxyURL.Hostname(), "1080")
+	}
+	return net.JoinHostPort(proxyURL.Hostname(), "80")
+}
+
+// noProxyMatches reports whether u is excluded from proxying. Entries are
+// "*", domains which also match their subdomains, IP addresses or CIDR
+// ranges, each optionally with a port.
+func noProxyMatches(u *url.URL, entries []string) bool {
+	host := strings.ToLower(u.Hostname())
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+	ip := net.ParseIP(host)
+
+	for _, entry := range entries {
+		entry = strings.ToLower(entry)
+		if entry == "*" {
+			return true
+		}
+		if _, ipNet, err := net.ParseCIDR(entry); err == nil {
+			if ip != nil && ipNet.Contains(ip) {
+				return true
+			}
+			continue
+		}
+		entryHost := entry
+		if h, p, err := net.SplitHostPort(entry); err == nil {
+			if p != port {
+				continue
+			}
+			entryHost = h
+		}
+		entryHost = strings.Trim(entryHost, "[]")
+		if entryIP := net.ParseIP(entryHost); entryIP != nil {
+			if ip != nil && ip.Equal(entryIP) {
+				return true
+			}
+			continue
+		}
+		entryHost = strings.TrimPrefix(strings.TrimPrefix(entryHost, "*"), ".")
+		if host == entryHost || strings.HasSuffix(host, "."+entryHost) {
+			return true
+		}
+	}
+	return false
+}
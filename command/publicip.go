@@ -0,0 +1,190 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	IPFamilyAny = "any"
+	IPFamilyV4  = "ipv4"
+	IPFamilyV6  = "ipv6"
+
+	defaultPublicIPTimeout = 5 * time.Second
+	maxPublicIPBodySize    = 256
+)
+
+var DefaultPublicIPProviders = []string{
+	"http://ipecho.net/plain",
+	"http://ip.appspot.com",
+	"http://whatismyip.akamai.com",
+}
+
+type publicIPAnswer struct {
+	provider string
+	ip       net.IP
+	err      error
+}
+
+func publicIPCommand(c *GoCmd, args ...string) ([]string, error) {
+	// Options:
+	// providers: comma separated provider urls, overriding CmdConfig.PublicIPProviders
+	// timeout: per provider timeout
+	// family: "any", "ipv4" or "ipv6". The family is that of the address the
+	//   providers report and of the connection to them, so it cannot be used
+	//   when a provider is reached through a proxy
+	// quorum: number of providers that must agree
+	_, opts := parseArgs(args)
+	providers := opts.getList("providers")
+	if len(providers) == 0 {
+		providers = splitList(c.config.PublicIPProviders)
+	}
+	if len(providers) == 0 {
+		providers = DefaultPublicIPProviders
+	}
+	timeout, err := opts.getDuration("timeout", defaultPublicIPTimeout)
+	if err != nil {
+		return nil, err
+	}
+	quorum, err := opts.getInt("quorum", 1)
+	if err != nil {
+		return nil, err
+	}
+	if quorum < 1 || quorum > len(providers) {
+		return nil, fmt.Errorf("Quorum must be between 1 and %d", len(providers))
+	}
+	family := opts.get("family", IPFamilyAny)
+	network, err := ipFamilyNetwork(family)
+	if err != nil {
+		return nil, err
+	}
+	if family != IPFamilyAny {
+		if err := c.requireDirect(providers); err != nil {
+			return nil, err
+		}
+	}
+
+	client := &http.Client{
+		Transport: c.httpTransport(network),
+		Timeout:   timeout,
+	}
+
+	// Cancelling the context aborts the requests still in flight once an
+	// answer has been chosen.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	answers := make(chan publicIPAnswer, len(providers))
+	for _, provider := range providers {
+		go func(provider string) {
+			ip, err := queryPublicIPProvider(ctx, client, provider, family)
+			answers <- publicIPAnswer{provider, ip, err}
+		}(provider)
+	}
+
+	votes := map[string]int{}
+	errMsgs := []string{}
+	for remaining := len(providers); remaining > 0; remaining-- {
+		answer := <-answers
+		if answer.err != nil {
+			errMsgs = append(errMsgs, fmt.Sprintf("%s: %v", answer.provider, answer.err))
+		} else {
+			ipStr := answer.ip.String()
+			votes[ipStr]++
+			if votes[ipStr] >= quorum {
+				return []string{ipStr}, nil
+			}
+		}
+
+		// Stop early once no address can reach the quorum.
+		best := 0
+		for _, n := range votes {
+			if n > best {
+				best = n
+			}
+		}
+		if best+remaining-1 < quorum {
+			break
+		}
+	}
+
+	if len(votes) > 1 {
+		errMsgs = append(errMsgs, fmt.Sprintf("providers disagree: %v", votes))
+	}
+	errMsg := "Error contacting publicip servers."
+	if len(errMsgs) > 0 {
+		errMsg = fmt.Sprintf("Error contacting publicip servers: %s", strings.Join(errMsgs, "; "))
+	}
+	return nil, errors.New(errMsg)
+}
+
+func queryPublicIPProvider(ctx context.Context, client *http.Client, provider, family string) (net.IP, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", provider, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP status code %d", resp.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxPublicIPBodySize))
+	if err != nil {
+		return nil, err
+	}
+	ipStr := strings.TrimSpace(string(body))
+	ip := net.ParseIP(ipStr)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid IP address %q", ipStr)
+	}
+	isV4 := ip.To4() != nil
+	if (family == IPFamilyV4 && !isV4) || (family == IPFamilyV6 && isV4) {
+		return nil, fmt.Errorf("IP address %s is not %s", ip, family)
+	}
+	return ip, nil
+}
+
+// requireDirect fails if any of the provider urls would be fetched through a
+// proxy, which would connect to them over its own choice of family.
+func (c *GoCmd) requireDirect(providers []string) error {
+	proxy := c.proxyFunc()
+	for _, provider := range providers {
+		req, err := http.NewRequest("GET", provider, nil)
+		if err != nil {
+			return err
+		}
+		proxyURL, err := proxy(req)
+		if err != nil {
+			return err
+		}
+		if proxyURL != nil {
+			return fmt.Errorf("IP family cannot be set when %s is reached through proxy %s", provider, proxyURL.Redacted())
+		}
+	}
+	return nil
+}
+
+// ipFamilyNetwork returns the network to dial for an IP family.
+func ipFamilyNetwork(family string) (string, error) {
+	switch family {
+	case IPFamilyAny:
+		return "tcp", nil
+	case IPFamilyV4:
+		return "tcp4", nil
+	case IPFamilyV6:
+		return "tcp6", nil
+	default:
+		return "", fmt.Errorf("IP family must be one of %q, %q or %q", IPFamilyAny, IPFamilyV4, IPFamilyV6)
+	}
+}
@@ -0,0 +1,143 @@
+package command
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// servePublicIP returns the url of a provider that answers with body.
+func servePublicIP(t *testing.T, body string) string {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, body)
+	}))
+	t.Cleanup(server.Close)
+	return server.URL
+}
+
+// serveSlowPublicIP returns the url of a provider that never answers before
+// the request is abandoned, and a channel that receives once it is.
+func serveSlowPublicIP(t *testing.T) (string, <-chan struct{}) {
+	t.Helper()
+	abandoned := make(chan struct{}, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+			abandoned <- struct{}{}
+		case <-time.After(10 * time.Second):
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server.URL, abandoned
+}
+
+func runPublicIP(t *testing.T, providers []string, args ...string) ([]string, error) {
+	t.Helper()
+	args = append([]string{"--", "providers=" + strings.Join(providers, ",")}, args...)
+	return publicIPCommand(newTestCmd(t), args...)
+}
+
+func TestPublicIPQuorum(t *testing.T) {
+	a := servePublicIP(t, "203.0.113.7")
+	b := servePublicIP(t, "203.0.113.7")
+	other := servePublicIP(t, "198.51.100.1")
+
+	// Any single answer is enough by default, whichever arrives first.
+	results, err := runPublicIP(t, []string{other, a, b})
+	if err != nil || len(results) != 1 {
+		t.Errorf("quorum=1: %q, %v", results, err)
+	}
+	results, err = runPublicIP(t, []string{other, a, b}, "quorum=2")
+	if err != nil || len(results) != 1 || results[0] != "203.0.113.7" {
+		t.Errorf("quorum=2: %q, %v", results, err)
+	}
+
+	if _, err := runPublicIP(t, []string{a, b}, "quorum=3"); err == nil || !strings.Contains(err.Error(), "between 1 and 2") {
+		t.Errorf("quorum=3: %v", err)
+	}
+	if _, err := runPublicIP(t, []string{a, b}, "quorum=0"); err == nil {
+		t.Error("quorum=0 succeeded")
+	}
+}
+
+func TestPublicIPDisagreement(t *testing.T) {
+	a := servePublicIP(t, "203.0.113.7")
+	b := servePublicIP(t, "198.51.100.1")
+	invalid := servePublicIP(t, "<html>blocked</html>")
+
+	_, err := runPublicIP(t, []string{a, b, invalid}, "quorum=2")
+	if err == nil {
+		t.Fatal("disagreeing providers reached a quorum")
+	}
+	for _, want := range []string{"providers disagree", "203.0.113.7:1", "198.51.100.1:1", `invalid IP address "<html>blocked</html>"`} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not contain %q", err, want)
+		}
+	}
+}
+
+func TestPublicIPTimeout(t *testing.T) {
+	fast := servePublicIP(t, "203.0.113.7")
+	slow, abandoned := serveSlowPublicIP(t)
+
+	// The slow provider is abandoned as soon as the fast one answers.
+	start := time.Now()
+	results, err := runPublicIP(t, []string{slow, fast}, "timeout=5s")
+	if err != nil || results[0] != "203.0.113.7" {
+		t.Fatalf("got %q, %v", results, err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("took %v waiting for the slow provider", elapsed)
+	}
+	select {
+	case <-abandoned:
+	case <-time.After(2 * time.Second):
+		t.Error("the slow provider's request was not abandoned")
+	}
+
+	// A provider that does not answer within the timeout counts as failed,
+	// so the quorum cannot be reached.
+	start = time.Now()
+	_, err = runPublicIP(t, []string{slow, fast}, "timeout=200ms", "quorum=2")
+	if err == nil || !strings.Contains(err.Error(), slow+": ") {
+		t.Errorf("got %v, want a timeout for %s", err, slow)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("took %v with a 200ms timeout", elapsed)
+	}
+}
+
+func TestPublicIPFamily(t *testing.T) {
+	v4 := servePublicIP(t, "203.0.113.7")
+
+	results, err := runPublicIP(t, []string{v4}, "family=ipv4")
+	if err != nil || results[0] != "203.0.113.7" {
+		t.Errorf("family=ipv4: %q, %v", results, err)
+	}
+	// The reported address must be of the family, not only the connection.
+	v6 := servePublicIP(t, "2001:db8::1")
+	if _, err := runPublicIP(t, []string{v6}, "family=ipv4"); err == nil || !strings.Contains(err.Error(), "is not ipv4") {
+		t.Errorf("family=ipv4 with an IPv6 answer: %v", err)
+	}
+	if _, err := runPublicIP(t, []string{v4}, "family=ipv6"); err == nil || !strings.Contains(err.Error(), "dial tcp6") {
+		t.Errorf("family=ipv6 over IPv4: %v", err)
+	}
+	if _, err := runPublicIP(t, []string{v4}, "family=ipv5"); err == nil {
+		t.Error("family=ipv5 succeeded")
+	}
+
+	// Through a proxy the family would only apply to the proxy connection.
+	proxy := serveTestProxy(t, false, "")
+	c := newProxiedCmd(t, CmdConfig{ProxyURL: proxy.url("http")})
+	if _, err := publicIPCommand(c, "--", "providers="+v4, "family=ipv4"); err == nil || !strings.Contains(err.Error(), "through proxy") {
+		t.Errorf("family with proxy: %v", err)
+	}
+	results, err = publicIPCommand(c, "--", "providers="+v4)
+	if err != nil || results[0] != "203.0.113.7" || proxy.count() != 1 {
+		t.Errorf("through proxy: %q, %v, %d proxied connections", results, err, proxy.count())
+	}
+}
@@ -0,0 +1,248 @@
+package command
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const (
+	RandomFormatCharset    = "charset"
+	RandomFormatPassword   = "password"
+	RandomFormatHex        = "hex"
+	RandomFormatBase64     = "base64"
+	RandomFormatBase64URL  = "base64url"
+	RandomFormatUUID       = "uuid"
+	RandomFormatPassphrase = "passphrase"
+
+	defaultRandomLength     = 16
+	minPassphraseBits       = 77
+	maxPasswordPolicyTries  = 1000
+	defaultRandomCharsetArg = "_A-Z-a-z-0-9"
+)
+
+func randomCommand(c *GoCmd, args ...string) ([]string, error) {
+	// Should be:
+	// 0: length (optional): characters, or bytes for hex and base64
+	// 1: charset (optional): tr style set such as "_A-Z-a-z-0-9"
+	// Options:
+	// format: "charset", "password", "hex", "base64", "base64url", "uuid" or "passphrase"
+	// words, separator, wordlist: passphrase word count, separator and word list file.
+	//   The default word count gives at least 77 bits of entropy.
+	// entropy: "true" to return the passphrase entropy in bits as a second result
+	positional, opts := parseArgs(args)
+	length := defaultRandomLength
+	if len(positional) > 0 {
+		var err error
+		length, err = strconv.Atoi(positional[0])
+		if err != nil {
+			return nil, err
+		}
+		if length < 0 {
+			return nil, fmt.Errorf("Invalid length: %d", length)
+		}
+	}
+	charsetArg := defaultRandomCharsetArg
+	if len(positional) > 1 && positional[1] != "" {
+		charsetArg = positional[1]
+	}
+
+	var str string
+	var err error
+	var bits float64
+	switch format := opts.get("format", RandomFormatCharset); format {
+	case RandomFormatCharset:
+		str, err = randSeq(expandCharset(charsetArg), length)
+	case RandomFormatPassword:
+		str, err = randPassword(expandCharset(charsetArg), length)
+	case RandomFormatHex:
+		var b []byte
+		b, err = randBytes(length)
+		str = hex.EncodeToString(b)
+	case RandomFormatBase64:
+		var b []byte
+		b, err = randBytes(length)
+		str = base64.StdEncoding.EncodeToString(b)
+	case RandomFormatBase64URL:
+		var b []byte
+		b, err = randBytes(length)
+		str = base64.RawURLEncoding.EncodeToString(b)
+	case RandomFormatUUID:
+		str, err = randUUID()
+	case RandomFormatPassphrase:
+		str, bits, err = randPassphrase(opts)
+	default:
+		return nil, fmt.Errorf("Unknown random format: %q", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if entropy, err := opts.getBool("entropy", false); err != nil {
+		return nil, err
+	} else if entropy && bits > 0 {
+		return []string{str, strconv.FormatFloat(bits, 'f', 1, 64)}, nil
+	}
+	return []string{str}, nil
+}
+
+// expandCharset expands a tr style character set, where "a-z" stands for the
+// range of characters and a "-" that does not form a range is literal.
+func expandCharset(set string) []rune {
+	chars := []rune(set)
+	seen := map[rune]bool{}
+	charset := []rune{}
+	add := func(r rune) {
+		if !seen[r] {
+			seen[r] = true
+			charset = append(charset, r)
+		}
+	}
+	for i := 0; i < len(chars); i++ {
+		if i+2 < len(chars) && chars[i+1] == '-' && chars[i] <= chars[i+2] {
+			for r := chars[i]; r <= chars[i+2]; r++ {
+				add(r)
+			}
+			i += 2
+			continue
+		}
+		add(chars[i])
+	}
+	return charset
+}
+
+// randIntn returns a uniform random integer in [0, n) from crypto/rand.
+func randIntn(n int) (int, error) {
+	i, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		return 0, err
+	}
+	return int(i.Int64()), nil
+}
+
+func randBytes(length int) ([]byte, error) {
+	b := make([]byte, length)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func randSeq(charset []rune, length int) (string, error) {
+	if len(charset) == 0 {
+		return "", errors.New("Charset is empty")
+	}
+	b := make([]rune, length)
+	for i := range b {
+		n, err := randIntn(len(charset))
+		if err != nil {
+			return "", err
+		}
+		b[i] = charset[n]
+	}
+	return string(b), nil
+}
+
+// randPassword returns a random string that contains at least one character
+// from each class (lower case, upper case, digit, other) present in charset.
+// Strings that do not satisfy the policy are discarded, which keeps the
+// selection uniform over the valid passwords.
+func randPassword(charset []rune, length int) (string, error) {
+	classes := map[int]bool{}
+	for _, r := range charset {
+		classes[charClass(r)] = true
+	}
+	if length < len(classes) {
+		return "", fmt.Errorf("Length must be at least %d to include every character class", len(classes))
+	}
+	for try := 0; try < maxPasswordPolicyTries; try++ {
+		str, err := randSeq(charset, length)
+		if err != nil {
+			return "", err
+		}
+		found := map[int]bool{}
+		for _, r := range str {
+			found[charClass(r)] = true
+		}
+		if len(found) == len(classes) {
+			return str, nil
+		}
+	}
+	return "", errors.New("Unable to generate a password that satisfies the policy")
+}
+
+func charClass(r rune) int {
+	switch {
+	case unicode.IsLower(r):
+		return 0
+	case unicode.IsUpper(r):
+		return 1
+	case unicode.IsDigit(r):
+		return 2
+	default:
+		return 3
+	}
+}
+
+func randUUID() (string, error) {
+	b, err := randBytes(16)
+	if err != nil {
+		return "", err
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
+
+// randPassphrase returns a passphrase of random words and its entropy in
+// bits. Without a words option, enough words are used to reach
+// minPassphraseBits.
+func randPassphrase(opts cmdOptions) (string, float64, error) {
+	words := passphraseWords
+	if path := opts.get("wordlist", ""); path != "" {
+		contents, err := ioutil.ReadFile(path)
+		if err != nil {
+			return "", 0, err
+		}
+		// Diceware lists prefix each word with its dice roll, so only the
+		// last field of each line is used. Duplicates would overstate the
+		// entropy and are skipped.
+		words = []string{}
+		seen := map[string]bool{}
+		for _, line := range strings.Split(string(contents), "\n") {
+			if fields := strings.Fields(line); len(fields) > 0 && !seen[fields[len(fields)-1]] {
+				seen[fields[len(fields)-1]] = true
+				words = append(words, fields[len(fields)-1])
+			}
+		}
+		if len(words) < 2 {
+			return "", 0, fmt.Errorf("Word list %s has fewer than 2 words", path)
+		}
+	}
+	bitsPerWord := math.Log2(float64(len(words)))
+
+	count, err := opts.getInt("words", int(math.Ceil(minPassphraseBits/bitsPerWord)))
+	if err != nil {
+		return "", 0, err
+	}
+	if count < 1 {
+		return "", 0, fmt.Errorf("Invalid value for words: %d", count)
+	}
+
+	passphrase := make([]string, count)
+	for i := range passphrase {
+		n, err := randIntn(len(words))
+		if err != nil {
+			return "", 0, err
+		}
+		passphrase[i] = words[n]
+	}
+	return strings.Join(passphrase, opts.get("separator", "-")), float64(count) * bitsPerWord, nil
+}
@@ -0,0 +1,156 @@
+package command
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"unicode"
+)
+
+func TestExpandCharset(t *testing.T) {
+	tests := []struct {
+		set  string
+		want string
+	}{
+		{"a-e", "abcde"},
+		{"_A-C-a-c-0-2", "_ABC-abc012"},
+		{"-a-c", "-abc"},
+		{"a-", "a-"},
+		{"z-a", "z-a"},
+		{"aab", "ab"},
+	}
+	for _, test := range tests {
+		if got := string(expandCharset(test.set)); got != test.want {
+			t.Errorf("expandCharset(%q) = %q, want %q", test.set, got, test.want)
+		}
+	}
+}
+
+func TestRandomFormats(t *testing.T) {
+	tests := []struct {
+		args    []string
+		pattern string
+	}{
+		{[]string{}, `^[_A-Za-z0-9-]{16}$`},
+		{[]string{"8", "a-c"}, `^[abc]{8}$`},
+		{[]string{"12", "a-z-A-Z-0-9-!", "--", "format=password"}, `^[a-zA-Z0-9!-]{12}$`},
+		{[]string{"4", "--", "format=hex"}, `^[0-9a-f]{8}$`},
+		{[]string{"3", "--", "format=base64"}, `^[A-Za-z0-9+/]{4}$`},
+		{[]string{"4", "--", "format=base64url"}, `^[A-Za-z0-9_-]{6}$`},
+		{[]string{"--", "format=uuid"}, `^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`},
+		{[]string{"--", "format=passphrase", "words=3", "separator=."}, `^[a-z]+\.[a-z]+\.[a-z]+$`},
+	}
+	for _, test := range tests {
+		results, err := randomCommand(nil, test.args...)
+		if err != nil {
+			t.Errorf("random(%q): %v", test.args, err)
+			continue
+		}
+		if len(results) != 1 || !regexp.MustCompile(test.pattern).MatchString(results[0]) {
+			t.Errorf("random(%q) = %q, want %s", test.args, results, test.pattern)
+		}
+	}
+}
+
+func TestRandomPasswordPolicy(t *testing.T) {
+	for i := 0; i < 50; i++ {
+		results, err := randomCommand(nil, "4", "a-z-A-Z-0-9-!", "--", "format=password")
+		if err != nil {
+			t.Fatal(err)
+		}
+		classes := map[int]bool{}
+		for _, r := range results[0] {
+			classes[charClass(r)] = true
+		}
+		if len(classes) != 4 {
+			t.Fatalf("password %q misses a character class", results[0])
+		}
+	}
+	if _, err := randomCommand(nil, "3", "a-z-A-Z-0-9-!", "--", "format=password"); err == nil {
+		t.Error("generated a password shorter than the number of classes")
+	}
+}
+
+func TestRandomErrors(t *testing.T) {
+	for _, args := range [][]string{
+		{"x"},
+		{"-1"},
+		{"--", "format=nope"},
+		{"--", "format=passphrase", "words=0"},
+		{"--", "format=passphrase", "wordlist=/nonexistent"},
+		{"--", "format=passphrase", "entropy=maybe"},
+	} {
+		if _, err := randomCommand(nil, args...); err == nil {
+			t.Errorf("random(%q) succeeded", args)
+		}
+	}
+}
+
+func TestPassphraseWords(t *testing.T) {
+	seen := map[string]bool{}
+	for _, word := range passphraseWords {
+		if seen[word] {
+			t.Errorf("duplicate word %q", word)
+		}
+		seen[word] = true
+		for _, r := range word {
+			if !unicode.IsLower(r) {
+				t.Errorf("word %q is not lower case", word)
+				break
+			}
+		}
+	}
+}
+
+func TestPassphraseEntropy(t *testing.T) {
+	results, err := randomCommand(nil, "--", "format=passphrase", "entropy=true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("results = %q", results)
+	}
+	bits, err := strconv.ParseFloat(results[1], 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bits < minPassphraseBits {
+		t.Errorf("default passphrase has %.1f bits, want at least %d", bits, minPassphraseBits)
+	}
+	if words := strings.Split(results[0], "-"); len(words) != 8 {
+		t.Errorf("default passphrase has %d words, want 8", len(words))
+	}
+}
+
+func TestPassphraseWordlist(t *testing.T) {
+	// A diceware style list with duplicates counts 4 distinct words.
+	path := filepath.Join(t.TempDir(), "words.txt")
+	list := "11111\talpha\n11112\tbravo\n11113\tcharlie\n11114\tdelta\n11115\tdelta\n\n"
+	if err := ioutil.WriteFile(path, []byte(list), 0644); err != nil {
+		t.Fatal(err)
+	}
+	results, err := randomCommand(nil, "--", "format=passphrase", "wordlist="+path, "entropy=true", "separator= ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 2 bits per word needs 39 words for 77 bits.
+	words := strings.Fields(results[0])
+	if len(words) != 39 || results[1] != "78.0" {
+		t.Errorf("passphrase has %d words and %s bits, want 39 and 78.0", len(words), results[1])
+	}
+	for _, word := range words {
+		if !strings.Contains(list, "\t"+word+"\n") {
+			t.Errorf("word %q is not from the list", word)
+		}
+	}
+
+	if err := ioutil.WriteFile(path, []byte("11111 alpha\n11112 alpha\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := randomCommand(nil, "--", "format=passphrase", "wordlist="+path); err == nil {
+		t.Error("accepted a word list with a single word")
+	}
+}
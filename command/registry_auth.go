@@ -0,0 +1,318 @@
+package command
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+const (
+	RegistryAuthNone   = "none"
+	RegistryAuthBasic  = "basic"
+	RegistryAuthBearer = "bearer"
+
+	dockerHubRegistry = "https://registry-1.docker.io"
+)
+
+var dockerHubAliases = []string{"docker.io", "index.docker.io", "registry-1.docker.io", "registry.hub.docker.com"}
+
+type registryAuthResult struct {
+	Registry      string `json:"registry"`
+	URL           string `json:"url"`
+	AuthType      string `json:"auth_type"`
+	Authenticated bool   `json:"authenticated"`
+	Repository    string `json:"repository,omitempty"`
+	PullAccess    *bool  `json:"pull_access,omitempty"`
+	Error         string `json:"error,omitempty"`
+	Passed        bool   `json:"passed"`
+}
+
+// registryChallenge is a parsed WWW-Authenticate header.
+type registryChallenge struct {
+	Scheme string
+	Params map[string]string
+}
+
+type registryClient struct {
+	client    *http.Client
+	base      string
+	auth      docker.AuthConfiguration
+	challenge registryChallenge
+}
+
+func registryAuthCommand(c *GoCmd, args ...string) ([]string, error) {
+	// Should be:
+	// 0: registry: host[:port] or url, "docker.io" for Docker Hub
+	// 1: username (optional)
+	// 2: password (required with username)
+	// Options:
+	// repository: checks pull access to this repository
+	// ca, insecure, timeout: as for http_check
+	positional, opts := parseArgs(args)
+	if len(positional) < 1 {
+		return nil, ErrMissingArgs
+	}
+	auth := docker.AuthConfiguration{ServerAddress: positional[0]}
+	if len(positional) > 1 && positional[1] != "" {
+		if len(positional) < 3 || positional[2] == "" {
+			return nil, ErrMissingArgs
+		}
+		auth.Username = positional[1]
+		auth.Password = positional[2]
+	}
+	base, isHub, err := registryBaseURL(auth.ServerAddress)
+	if err != nil {
+		return nil, err
+	}
+	client, err := c.httpClientFromOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	repository := opts.get("repository", "")
+	if isHub && repository != "" && !strings.Contains(repository, "/") {
+		repository = "library/" + repository
+	}
+
+	result := registryAuthResult{
+		Registry:   auth.ServerAddress,
+		URL:        base,
+		Repository: repository,
+	}
+	registry := &registryClient{client: client, base: base, auth: auth}
+	if err := registry.login(&result); err != nil {
+		result.Error = err.Error()
+	} else if repository != "" {
+		pull, err := registry.checkPull(repository)
+		result.PullAccess = &pull
+		if err != nil {
+			result.Error = err.Error()
+		}
+	}
+	result.Passed = result.Error == ""
+
+	results, err := jsonResult(result)
+	if err != nil {
+		return nil, err
+	}
+	if !result.Passed {
+		errMsg := fmt.Sprintf("Registry authentication failed: %s", result.Error)
+		if result.Authenticated {
+			errMsg = fmt.Sprintf("Registry pull check for %s failed: %s", repository, result.Error)
+		}
+		return results, ErrCommandResponse{errMsg}
+	}
+	return results, nil
+}
+
+// login pings /v2/ and authenticates as the challenge asks.
+func (r *registryClient) login(result *registryAuthResult) error {
+	resp, err := r.get(r.base+"/v2/", "")
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode == http.StatusOK {
+		result.AuthType = RegistryAuthNone
+		result.Authenticated = true
+		return nil
+	}
+	if resp.StatusCode != http.StatusUnauthorized {
+		return registryResponseError(resp)
+	}
+	r.challenge = parseRegistryChallenge(resp.Header.Get("WWW-Authenticate"))
+
+	var authorization string
+	switch r.challenge.Scheme {
+	case RegistryAuthBasic:
+		result.AuthType = RegistryAuthBasic
+		authorization = r.basicAuthorization()
+	case RegistryAuthBearer:
+		result.AuthType = RegistryAuthBearer
+		token, err := r.token("")
+		if err != nil {
+			return err
+		}
+		authorization = "Bearer " + token
+	default:
+		return fmt.Errorf("Unsupported registry auth challenge: %q", resp.Header.Get("WWW-Authenticate"))
+	}
+
+	resp, err = r.get(r.base+"/v2/", authorization)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return registryResponseError(resp)
+	}
+	result.Authenticated = true
+	return nil
+}
+
+// checkPull lists the tags of repository, which needs the same access as a
+// pull. The registry returns 401 or 403 when the token lacks pull access.
+func (r *registryClient) checkPull(repository string) (bool, error) {
+	authorization := ""
+	switch r.challenge.Scheme {
+	case RegistryAuthBasic:
+		authorization = r.basicAuthorization()
+	case RegistryAuthBearer:
+		token, err := r.token(fmt.Sprintf("repository:%s:pull", repository))
+		if err != nil {
+			return false, err
+		}
+		authorization = "Bearer " + token
+	}
+	resp, err := r.get(fmt.Sprintf("%s/v2/%s/tags/list", r.base, repository), authorization)
+	if err != nil {
+		return false, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return false, registryResponseError(resp)
+	}
+	return true, nil
+}
+
+// token exchanges the credentials for a bearer token at the realm of the
+// challenge. Anonymous tokens are requested when there is no username.
+func (r *registryClient) token(scope string) (string, error) {
+	realm := r.challenge.Params["realm"]
+	if realm == "" {
+		return "", errors.New("Registry bearer challenge has no realm")
+	}
+	tokenURL, err := url.Parse(realm)
+	if err != nil {
+		return "", fmt.Errorf("Invalid registry token realm: %v", err)
+	}
+	query := tokenURL.Query()
+	if service := r.challenge.Params["service"]; service != "" {
+		query.Set("service", service)
+	}
+	if scope != "" {
+		query.Set("scope", scope)
+	}
+	if r.auth.Username != "" {
+		query.Set("account", r.auth.Username)
+	}
+	tokenURL.RawQuery = query.Encode()
+
+	authorization := ""
+	if r.auth.Username != "" {
+		authorization = r.basicAuthorization()
+	}
+	resp, err := r.get(tokenURL.String(), authorization)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", registryResponseError(resp)
+	}
+	var body struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.Unmarshal(resp.body, &body); err != nil {
+		return "", fmt.Errorf("Invalid registry token response: %v", err)
+	}
+	if body.Token != "" {
+		return body.Token, nil
+	}
+	if body.AccessToken != "" {
+		return body.AccessToken, nil
+	}
+	return "", errors.New("Registry token response has no token")
+}
+
+type registryResponse struct {
+	*http.Response
+	body []byte
+}
+
+func (r *registryClient) get(reqURL, authorization string) (*registryResponse, error) {
+	req, err := http.NewRequest("GET", reqURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxAPIBodySize))
+	if err != nil {
+		return nil, err
+	}
+	return &registryResponse{resp, body}, nil
+}
+
+func (r *registryClient) basicAuthorization() string {
+	basic := base64.StdEncoding.EncodeToString([]byte(r.auth.Username + ":" + r.auth.Password))
+	return "Basic " + basic
+}
+
+func registryResponseError(resp *registryResponse) error {
+	return apiError{StatusCode: resp.StatusCode, Message: apiErrorMessage(resp.body)}
+}
+
+// registryBaseURL returns the registry API root and whether it is Docker Hub.
+func registryBaseURL(registry string) (string, bool, error) {
+	u, err := apiBaseURL(registry)
+	if err != nil {
+		return "", false, err
+	}
+	for _, alias := range dockerHubAliases {
+		if strings.EqualFold(u.Hostname(), alias) {
+			return dockerHubRegistry, true, nil
+		}
+	}
+	u.Path = ""
+	return u.String(), false, nil
+}
+
+// parseRegistryChallenge parses a WWW-Authenticate header such as
+// `Bearer realm="https://auth.docker.io/token",service="registry.docker.io"`.
+// Quoted values may contain commas.
+func parseRegistryChallenge(header string) registryChallenge {
+	challenge := registryChallenge{Params: map[string]string{}}
+	header = strings.TrimSpace(header)
+	i := strings.IndexByte(header, ' ')
+	if i < 0 {
+		challenge.Scheme = strings.ToLower(header)
+		return challenge
+	}
+	challenge.Scheme = strings.ToLower(header[:i])
+
+	rest := header[i+1:]
+	for {
+		rest = strings.TrimLeft(rest, " ,")
+		eq := strings.IndexByte(rest, '=')
+		if eq < 0 {
+			return challenge
+		}
+		key := strings.ToLower(strings.TrimSpace(rest[:eq]))
+		rest = rest[eq+1:]
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.IndexByte(rest[1:], '"')
+			if end < 0 {
+				value, rest = rest[1:], ""
+			} else {
+				value, rest = rest[1:end+1], rest[end+2:]
+			}
+		} else if comma := strings.IndexByte(rest, ','); comma >= 0 {
+			value, rest = rest[:comma], rest[comma:]
+		} else {
+			value, rest = rest, ""
+		}
+		challenge.Params[key] = value
+	}
+}
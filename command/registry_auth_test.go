@@ -0,0 +1,215 @@
+package command
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// serveFakeRegistry serves the registry API with the given auth scheme.
+// Bearer tokens come from /token on the same server and name the pull scope
+// they were issued for. Only the "team/app" repository can be pulled.
+func serveFakeRegistry(t *testing.T, scheme string) *httptest.Server {
+	t.Helper()
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, hasBasic := r.BasicAuth()
+		validUser := hasBasic && user == "user" && pass == "pass"
+
+		if r.URL.Path == "/token" {
+			if hasBasic && !validUser {
+				w.WriteHeader(http.StatusUnauthorized)
+				fmt.Fprint(w, `{"details":"incorrect username or password"}`)
+				return
+			}
+			if r.URL.Query().Get("service") != "test-registry" || r.URL.Query().Get("account") != user {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]string{"access_token": "token:" + r.URL.Query().Get("scope")})
+			return
+		}
+
+		authorized := scheme == RegistryAuthNone ||
+			(scheme == RegistryAuthBasic && validUser) ||
+			(scheme == RegistryAuthBearer && strings.HasPrefix(r.Header.Get("Authorization"), "Bearer token:"))
+		if !authorized {
+			switch scheme {
+			case RegistryAuthBasic:
+				w.Header().Set("WWW-Authenticate", `Basic realm="Registry Realm"`)
+			case RegistryAuthBearer:
+				w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="test-registry"`, server.URL))
+			default:
+				w.Header().Set("WWW-Authenticate", scheme)
+			}
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"errors":[{"code":"UNAUTHORIZED","message":"authentication required"}]}`)
+			return
+		}
+
+		switch {
+		case r.URL.Path == "/v2/":
+			fmt.Fprint(w, `{}`)
+		case r.URL.Path == "/v2/team/app/tags/list" &&
+			(scheme != RegistryAuthBearer || r.Header.Get("Authorization") == "Bearer token:repository:team/app:pull"):
+			fmt.Fprint(w, `{"name":"team/app","tags":["latest"]}`)
+		case strings.HasSuffix(r.URL.Path, "/tags/list"):
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `{"errors":[{"code":"DENIED","message":"requested access to the resource is denied"}]}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func runRegistryAuth(t *testing.T, args ...string) (registryAuthResult, error) {
+	t.Helper()
+	results, err := registryAuthCommand(newTestCmd(t), args...)
+	if _, ok := err.(ErrCommandResponse); err != nil && !ok {
+		t.Fatalf("registry_auth: %v", err)
+	}
+	var result registryAuthResult
+	if len(results) != 1 {
+		t.Fatalf("got %d results", len(results))
+	}
+	if err := json.Unmarshal([]byte(results[0]), &result); err != nil {
+		t.Fatal(err)
+	}
+	return result, err
+}
+
+func TestRegistryAuth(t *testing.T) {
+	pullAccess, noPullAccess := true, false
+	tests := []struct {
+		scheme     string
+		args       []string
+		pullAccess *bool
+		errPrefix  string
+	}{
+		{scheme: RegistryAuthNone},
+		{scheme: RegistryAuthNone, args: []string{"--", "repository=team/app"}, pullAccess: &pullAccess},
+		{scheme: RegistryAuthBearer, args: []string{"", "", "--", "repository=team/app"}, pullAccess: &pullAccess},
+		{scheme: RegistryAuthBasic, args: []string{"user", "pass", "--", "repository=team/app"}, pullAccess: &pullAccess},
+		{scheme: RegistryAuthBasic, args: []string{"user", "wrong"}, errPrefix: "Registry authentication failed: HTTP status code 401: authentication required"},
+		{scheme: RegistryAuthBasic, errPrefix: "Registry authentication failed: HTTP status code 401"},
+		{scheme: RegistryAuthBearer, args: []string{"user", "pass"}},
+		{scheme: RegistryAuthBearer, args: []string{"user", "pass", "--", "repository=team/app"}, pullAccess: &pullAccess},
+		{scheme: RegistryAuthBearer, args: []string{"--", "repository=team/app"}, pullAccess: &pullAccess},
+		{
+			scheme:     RegistryAuthBearer,
+			args:       []string{"user", "pass", "--", "repository=team/other"},
+			pullAccess: &noPullAccess,
+			errPrefix:  "Registry pull check for team/other failed: HTTP status code 403: requested access to the resource is denied",
+		},
+		{scheme: RegistryAuthBearer, args: []string{"user", "wrong"}, errPrefix: "Registry authentication failed: HTTP status code 401"},
+	}
+	for _, test := range tests {
+		server := serveFakeRegistry(t, test.scheme)
+		result, err := runRegistryAuth(t, append([]string{server.URL}, test.args...)...)
+		if test.errPrefix == "" {
+			if err != nil {
+				t.Errorf("%s %q: %v", test.scheme, test.args, err)
+				continue
+			}
+		} else if err == nil || !strings.HasPrefix(err.Error(), test.errPrefix) {
+			t.Errorf("%s %q: got error %v, want prefix %q", test.scheme, test.args, err, test.errPrefix)
+			continue
+		}
+		if result.Passed != (err == nil) || result.URL != server.URL {
+			t.Errorf("%s %q: unexpected result %+v", test.scheme, test.args, result)
+		}
+		if result.AuthType != test.scheme {
+			t.Errorf("%s %q: auth type %q", test.scheme, test.args, result.AuthType)
+		}
+		if !reflect.DeepEqual(result.PullAccess, test.pullAccess) {
+			t.Errorf("%s %q: pull access %v, want %v", test.scheme, test.args, result.PullAccess, test.pullAccess)
+		}
+	}
+}
+
+func TestRegistryAuthUnsupportedChallenge(t *testing.T) {
+	server := serveFakeRegistry(t, "Negotiate")
+	_, err := runRegistryAuth(t, server.URL, "user", "pass")
+	if err == nil || !strings.Contains(err.Error(), "Unsupported registry auth challenge") {
+		t.Errorf("got %v, want an unsupported challenge error", err)
+	}
+}
+
+func TestRegistryBaseURL(t *testing.T) {
+	tests := []struct {
+		registry string
+		base     string
+		isHub    bool
+	}{
+		{"docker.io", dockerHubRegistry, true},
+		{"https://index.docker.io/v1/", dockerHubRegistry, true},
+		{"Registry-1.Docker.io", dockerHubRegistry, true},
+		{"registry.example.com:5000", "https://registry.example.com:5000", false},
+		{"http://localhost:5000/v2/", "http://localhost:5000", false},
+	}
+	for _, test := range tests {
+		base, isHub, err := registryBaseURL(test.registry)
+		if err != nil {
+			t.Errorf("registryBaseURL(%q): %v", test.registry, err)
+			continue
+		}
+		if base != test.base || isHub != test.isHub {
+			t.Errorf("registryBaseURL(%q) = %q, %v, want %q, %v", test.registry, base, isHub, test.base, test.isHub)
+		}
+	}
+	if _, _, err := registryBaseURL("ftp://registry.example.com"); err == nil {
+		t.Error("ftp registry accepted")
+	}
+}
+
+func TestParseRegistryChallenge(t *testing.T) {
+	tests := []struct {
+		header    string
+		challenge registryChallenge
+	}{
+		{
+			`Bearer realm="https://auth.docker.io/token",service="registry.docker.io"`,
+			registryChallenge{"bearer", map[string]string{"realm": "https://auth.docker.io/token", "service": "registry.docker.io"}},
+		},
+		{
+			`Bearer realm="https://auth.example.com/token",scope="repository:a:pull,push", error=insufficient_scope`,
+			registryChallenge{"bearer", map[string]string{"realm": "https://auth.example.com/token", "scope": "repository:a:pull,push", "error": "insufficient_scope"}},
+		},
+		{
+			`Basic realm="Registry Realm"`,
+			registryChallenge{"basic", map[string]string{"realm": "Registry Realm"}},
+		},
+		{
+			`Basic`,
+			registryChallenge{"basic", map[string]string{}},
+		},
+		{
+			`Bearer realm="unterminated`,
+			registryChallenge{"bearer", map[string]string{"realm": "unterminated"}},
+		},
+	}
+	for _, test := range tests {
+		if got := parseRegistryChallenge(test.header); !reflect.DeepEqual(got, test.challenge) {
+			t.Errorf("parseRegistryChallenge(%q) = %+v, want %+v", test.header, got, test.challenge)
+		}
+	}
+}
+
+func TestRegistryAuthMissingArgs(t *testing.T) {
+	for _, args := range [][]string{
+		{},
+		{"registry.example.com", "user"},
+		{"registry.example.com", "user", ""},
+		{"registry.example.com", "user", "--", "repository=team/app"},
+	} {
+		if _, err := registryAuthCommand(newTestCmd(t), args...); err != ErrMissingArgs {
+			t.Errorf("registry_auth(%q): got %v, want ErrMissingArgs", args, err)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"net"
+)
+
+type reverseDNSName struct {
+	Name      string   `json:"name"`
+	Addresses []string `json:"addresses"`
+	Matches   bool     `json:"matches"`
+	Error     string   `json:"error,omitempty"`
+}
+
+type reverseDNSResult struct {
+	IP        string           `json:"ip"`
+	Names     []reverseDNSName `json:"names"`
+	Confirmed bool             `json:"confirmed"`
+	Error     string           `json:"error,omitempty"`
+}
+
+func reverseDNSCommand(c *GoCmd, args ...string) ([]string, error) {
+	// Should be:
+	// 0: ip: IPv4 or IPv6 address
+	// Options:
+	// server: nameserver to query instead of the system resolver
+	// timeout: overall timeout
+	positional, opts := parseArgs(args)
+	if len(positional) < 1 {
+		return nil, ErrMissingArgs
+	}
+	ip := net.ParseIP(positional[0])
+	if ip == nil {
+		return nil, fmt.Errorf("Invalid IP address: %q", positional[0])
+	}
+	timeout, err := opts.getDuration("timeout", defaultDNSTimeout)
+	if err != nil {
+		return nil, err
+	}
+	resolver := c.resolverFromOptions(opts)
+
+	// Lookups stop when the command is cancelled.
+	ctx, cancel := context.WithTimeout(c.context(), timeout)
+	defer cancel()
+
+	result := reverseDNSResult{
+		IP:    ip.String(),
+		Names: []reverseDNSName{},
+	}
+	names, err := resolver.LookupAddr(ctx, ip.String())
+	if err != nil {
+		result.Error = err.Error()
+	}
+
+	// Forward-confirmed reverse DNS requires one of the PTR names to resolve
+	// back to the original address.
+	for _, name := range names {
+		entry := reverseDNSName{
+			Name:      name,
+			Addresses: []string{},
+		}
+		addrs, err := resolver.LookupIPAddr(ctx, name)
+		if err != nil {
+			entry.Error = err.Error()
+		}
+		for _, addr := range addrs {
+			entry.Addresses = append(entry.Addresses, addr.IP.String())
+			if addr.IP.Equal(ip) {
+				entry.Matches = true
+				result.Confirmed = true
+			}
+		}
+		result.Names = append(result.Names, entry)
+	}
+
+	results, err := jsonResult(result)
+	if err != nil {
+		return nil, err
+	}
+	if !result.Confirmed {
+		errMsg := fmt.Sprintf("Reverse DNS for %s is not forward-confirmed", ip)
+		if len(names) == 0 {
+			errMsg = fmt.Sprintf("No PTR record found for %s", ip)
+		}
+		return results, ErrCommandResponse{errMsg}
+	}
+	return results, nil
+}
+
+// resolverFromOptions returns a resolver that queries the server option, or
+// the system resolver if it is not set.
+func (c *GoCmd) resolverFromOptions(opts cmdOptions) *net.Resolver {
+	server := opts.get("server", "")
+	if server == "" {
+		return net.DefaultResolver
+	}
+	server = dnsServerAddress(server)
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			return c.dial(ctx, network, server, 0)
+		},
+	}
+}
@@ -0,0 +1,117 @@
+package command
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// servePTR answers PTR queries for 10.2.3.4 with host.example.com and A
+// queries for host.example.com with addr.
+func servePTR(t *testing.T, addr [4]byte) string {
+	t.Helper()
+	return serveDNS(t, func(query *dnsmessage.Message, tcp bool) [][]byte {
+		q := query.Questions[0]
+		resp := dnsmessage.Message{
+			Header:    dnsmessage.Header{ID: query.ID, Response: true, Authoritative: true},
+			Questions: query.Questions,
+		}
+		header := dnsmessage.ResourceHeader{Name: q.Name, Type: q.Type, Class: dnsmessage.ClassINET, TTL: 60}
+		switch {
+		case q.Type == dnsmessage.TypePTR && q.Name.String() == "4.3.2.10.in-addr.arpa.":
+			resp.Answers = []dnsmessage.Resource{{Header: header, Body: &dnsmessage.PTRResource{PTR: dnsmessage.MustNewName("host.example.com.")}}}
+		case q.Type == dnsmessage.TypeA && q.Name.String() == "host.example.com.":
+			resp.Answers = []dnsmessage.Resource{{Header: header, Body: &dnsmessage.AResource{A: addr}}}
+		case q.Type == dnsmessage.TypeAAAA && q.Name.String() == "host.example.com.":
+		default:
+			resp.RCode = dnsmessage.RCodeNameError
+		}
+		packet, err := resp.Pack()
+		if err != nil {
+			t.Error(err)
+			return nil
+		}
+		return [][]byte{packet}
+	})
+}
+
+func runReverseDNS(t *testing.T, args ...string) (reverseDNSResult, error) {
+	t.Helper()
+	results, err := reverseDNSCommand(newTestCmd(t), args...)
+	var result reverseDNSResult
+	if len(results) > 0 {
+		if err := json.Unmarshal([]byte(results[0]), &result); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return result, err
+}
+
+func TestReverseDNS(t *testing.T) {
+	server := servePTR(t, [4]byte{10, 2, 3, 4})
+	result, err := runReverseDNS(t, "10.2.3.4", "--", "server="+server, "timeout=2s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Confirmed || len(result.Names) != 1 {
+		t.Fatalf("result = %+v", result)
+	}
+	name := result.Names[0]
+	if name.Name != "host.example.com." || !name.Matches || len(name.Addresses) != 1 || name.Addresses[0] != "10.2.3.4" {
+		t.Errorf("name = %+v", name)
+	}
+}
+
+func TestReverseDNSNotConfirmed(t *testing.T) {
+	server := servePTR(t, [4]byte{10, 9, 9, 9})
+	result, err := runReverseDNS(t, "10.2.3.4", "--", "server="+server, "timeout=2s")
+	if _, ok := err.(ErrCommandResponse); !ok {
+		t.Fatalf("got %v, want ErrCommandResponse", err)
+	}
+	if result.Confirmed || len(result.Names) != 1 || result.Names[0].Matches {
+		t.Errorf("result = %+v", result)
+	}
+
+	result, err = runReverseDNS(t, "10.5.5.5", "--", "server="+server, "timeout=2s")
+	if _, ok := err.(ErrCommandResponse); !ok {
+		t.Fatalf("got %v, want ErrCommandResponse", err)
+	}
+	if len(result.Names) != 0 || result.Error == "" {
+		t.Errorf("result = %+v", result)
+	}
+}
+
+func TestReverseDNSCancel(t *testing.T) {
+	server := serveDNS(t, func(query *dnsmessage.Message, tcp bool) [][]byte {
+		return nil
+	})
+
+	c := newTestCmd(t)
+	time.AfterFunc(100*time.Millisecond, c.Cancel)
+	start := time.Now()
+	results, err := reverseDNSCommand(c, "10.2.3.4", "--", "server="+server, "timeout=10s")
+	if _, ok := err.(ErrCommandResponse); !ok {
+		t.Fatalf("got %v, want ErrCommandResponse", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("cancelled lookup took %v", elapsed)
+	}
+	var result reverseDNSResult
+	if err := json.Unmarshal([]byte(results[0]), &result); err != nil {
+		t.Fatal(err)
+	}
+	if result.Error == "" {
+		t.Errorf("result = %+v", result)
+	}
+}
+
+func TestReverseDNSErrors(t *testing.T) {
+	if _, err := reverseDNSCommand(newTestCmd(t)); err != ErrMissingArgs {
+		t.Errorf("got %v, want ErrMissingArgs", err)
+	}
+	if _, err := reverseDNSCommand(newTestCmd(t), "host.example.com"); err == nil {
+		t.Error("accepted a name instead of an IP address")
+	}
+}
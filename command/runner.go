@@ -0,0 +1,142 @@
+package command
+
+import (
+	"context"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/fsouza/go-dockerclient"
+)
+
+// Runner runs commands and keeps track of the ones in flight so that their
+// containers can be cleaned up on shutdown.
+type Runner struct {
+	config       CmdConfig
+	dockerClient *docker.Client
+
+	mu       sync.Mutex
+	wg       sync.WaitGroup
+	active   map[runningCmd]struct{}
+	shutdown bool
+}
+
+// runningCmd is a go or container command in flight.
+type runningCmd interface {
+	Cancel()
+}
+
+func NewRunner(config CmdConfig, dockerClient *docker.Client) *Runner {
+	return &Runner{
+		config:       config,
+		dockerClient: dockerClient,
+		active:       map[runningCmd]struct{}{},
+	}
+}
+
+func (r *Runner) RunCommand(op string, args ...string) ([]string, error) {
+	if err := r.begin(); err != nil {
+		return nil, err
+	}
+	defer r.wg.Done()
+
+	goCmd, err := NewGoCmd(op, r.config, r.dockerClient)
+	if err == nil {
+		goCmd.runner = r
+		if err := r.track(goCmd); err != nil {
+			return nil, err
+		}
+		defer r.untrack(goCmd)
+		return goCmd.Run(args...)
+	}
+	if err != ErrCommandNotFound {
+		return nil, err
+	}
+
+	containerCmd, err := NewContainerCmd(op, r.config, r.dockerClient)
+	if err == nil {
+		containerCmd.runner = r
+		return containerCmd.Run(args...)
+	}
+	return nil, err
+}
+
+// Shutdown stops accepting new commands, cancels the running ones and kills
+// their containers, then waits for them to finish. If ctx expires first, the
+// remaining containers are removed and ctx.Err() is returned.
+func (r *Runner) Shutdown(ctx context.Context) error {
+	r.mu.Lock()
+	r.shutdown = true
+	cmds := r.activeCmds()
+	r.mu.Unlock()
+
+	log.Debugf("shutting down %d running commands", len(cmds))
+	for _, cmd := range cmds {
+		cmd.Cancel()
+		if containerCmd, ok := cmd.(*ContainerCmd); ok {
+			if containerID := containerCmd.getContainerID(); containerID != "" {
+				killContainer(r.dockerClient, containerID)
+			}
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		r.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		log.Debugf(" -> shutdown complete")
+		return nil
+	case <-ctx.Done():
+		r.mu.Lock()
+		cmds = r.activeCmds()
+		r.mu.Unlock()
+		for _, cmd := range cmds {
+			if containerCmd, ok := cmd.(*ContainerCmd); ok {
+				if containerID := containerCmd.getContainerID(); containerID != "" {
+					removeContainer(r.dockerClient, containerID)
+				}
+			}
+		}
+		log.Errorf(" -> shutdown incomplete: %v", ctx.Err())
+		return ctx.Err()
+	}
+}
+
+func (r *Runner) begin() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.shutdown {
+		return ErrShuttingDown
+	}
+	r.wg.Add(1)
+	return nil
+}
+
+// track registers a command started by this runner. Container commands run
+// from within a go command are tracked here as well.
+func (r *Runner) track(cmd runningCmd) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.shutdown {
+		return ErrShuttingDown
+	}
+	r.active[cmd] = struct{}{}
+	return nil
+}
+
+func (r *Runner) untrack(cmd runningCmd) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.active, cmd)
+}
+
+func (r *Runner) activeCmds() []runningCmd {
+	cmds := make([]runningCmd, 0, len(r.active))
+	for cmd := range r.active {
+		cmds = append(cmds, cmd)
+	}
+	return cmds
+}
@@ -0,0 +1,112 @@
+package command
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestShutdownCancelsGoCommands(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	started := make(chan struct{})
+	goCommands["test_block"] = func(c *GoCmd, args ...string) ([]string, error) {
+		conn, err := c.dial(context.Background(), "tcp", l.Addr().String(), time.Second)
+		if err != nil {
+			return nil, err
+		}
+		close(started)
+		// The listener never writes, so this blocks until the connection
+		// is closed by the cancellation.
+		_, err = conn.Read(make([]byte, 1))
+		return nil, err
+	}
+	defer delete(goCommands, "test_block")
+
+	r := NewRunner(CmdConfig{}, nil)
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := r.RunCommand("test_block")
+		errCh <- err
+	}()
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("command did not start")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := r.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+	if err := <-errCh; err == nil {
+		t.Error("expected the blocked command to fail")
+	}
+	if _, err := r.RunCommand("echo", "x"); err != ErrShuttingDown {
+		t.Errorf("RunCommand after shutdown: got %v, want ErrShuttingDown", err)
+	}
+}
+
+func TestDialAfterCancel(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	c, err := NewGoCmd("echo", CmdConfig{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Cancel()
+	if _, err := c.dial(context.Background(), "tcp", l.Addr().String(), time.Second); err != ErrCommandCancelled {
+		t.Errorf("got %v, want ErrCommandCancelled", err)
+	}
+}
+
+func TestRunClosesIdleConnections(t *testing.T) {
+	closed := make(chan struct{}, 1)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateClosed {
+			select {
+			case closed <- struct{}{}:
+			default:
+			}
+		}
+	}
+	server.Start()
+	defer server.Close()
+
+	var cmd *GoCmd
+	goCommands["test_http"] = func(c *GoCmd, args ...string) ([]string, error) {
+		cmd = c
+		resp, err := c.httpClient(time.Second).Get(server.URL)
+		if err != nil {
+			return nil, err
+		}
+		resp.Body.Close()
+		return nil, nil
+	}
+	defer delete(goCommands, "test_http")
+
+	if _, err := NewRunner(CmdConfig{}, nil).RunCommand("test_http"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("connection left open after the command finished")
+	}
+	if len(cmd.transports) != 0 {
+		t.Errorf("%d transports still referenced", len(cmd.transports))
+	}
+}
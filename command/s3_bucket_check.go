@@ -0,0 +1,230 @@
+package command
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/awslabs/aws-sdk-go/aws"
+	"github.com/awslabs/aws-sdk-go/service/s3"
+	"github.com/awslabs/aws-sdk-go/service/s3/s3manager"
+)
+
+const s3ProbeSize = 1024
+
+// errS3NotVerified marks a step whose outcome says nothing about its
+// permission.
+var errS3NotVerified = errors.New("Not verified, the probe object could not be written")
+
+type s3CheckStep struct {
+	Name        string `json:"name"`
+	Permission  string `json:"permission"`
+	Passed      bool   `json:"passed"`
+	NotVerified bool   `json:"not_verified,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+type s3BucketCheckResult struct {
+	Bucket             string        `json:"bucket"`
+	Region             string        `json:"region"`
+	Key                string        `json:"key"`
+	Multipart          bool          `json:"multipart"`
+	Steps              []s3CheckStep `json:"steps"`
+	MissingPermissions []string      `json:"missing_permissions,omitempty"`
+	Passed             bool          `json:"passed"`
+}
+
+func s3BucketCheckCommand(c *GoCmd, args ...string) ([]string, error) {
+	// Should be:
+	// 0: aws_access_key_id
+	// 1: aws_secret_access_key
+	// 2: bucket
+	// Options:
+	// prefix: key prefix the probe object is written under
+	// multipart: upload the probe with a multipart upload
+	// region, session_token, endpoint: as for aws_auth. Path-style addressing
+	//   is used when endpoint is set.
+	// ca, insecure, timeout: as for http_check
+	positional, opts := parseArgs(args)
+	if len(positional) < 3 {
+		return nil, ErrMissingArgs
+	}
+	bucket := positional[2]
+	multipart, err := opts.getBool("multipart", false)
+	if err != nil {
+		return nil, err
+	}
+	config, err := c.awsConfigFromOptions(positional[0], positional[1], opts)
+	if err != nil {
+		return nil, err
+	}
+	svc := s3.New(config)
+
+	suffix, err := randBytes(8)
+	if err != nil {
+		return nil, err
+	}
+	size := s3ProbeSize
+	if multipart {
+		size = int(s3manager.DefaultPartSize) + s3ProbeSize
+	}
+	probe, err := randBytes(size)
+	if err != nil {
+		return nil, err
+	}
+
+	result := s3BucketCheckResult{
+		Bucket:    bucket,
+		Region:    config.Region,
+		Key:       opts.get("prefix", "") + "libcmd-probe-" + hex.EncodeToString(suffix),
+		Multipart: multipart,
+		Steps:     []s3CheckStep{},
+	}
+	// Each step is attempted whatever the outcome of the previous ones, so
+	// every missing permission is reported.
+	step := func(name, permission string, fn func() error) error {
+		s := s3CheckStep{Name: name, Permission: permission}
+		err := fn()
+		if err == errS3NotVerified {
+			s.NotVerified = true
+			s.Error = err.Error()
+		} else if err != nil {
+			s.Error = s3ErrorMessage(err, config.Region)
+			if s3AccessDenied(err) {
+				result.MissingPermissions = append(result.MissingPermissions, permission)
+			}
+		} else {
+			s.Passed = true
+		}
+		result.Steps = append(result.Steps, s)
+		return err
+	}
+
+	err = step("exists", "s3:ListBucket", func() error {
+		_, err := svc.HeadBucket(&s3.HeadBucketInput{Bucket: aws.String(bucket)})
+		return err
+	})
+	// Access is only worth probing if the bucket is there and in the region.
+	// A 403 says nothing about the bucket, so the check carries on.
+	if err != nil && !s3AccessDenied(err) {
+		return s3BucketCheckResponse(result)
+	}
+	putErr := step("put", "s3:PutObject", func() error {
+		if multipart {
+			_, err := s3manager.Upload(svc, &s3manager.UploadInput{
+				Bucket: aws.String(bucket),
+				Key:    aws.String(result.Key),
+				Body:   bytes.NewReader(probe),
+			}, &s3manager.UploadOptions{PartSize: s3manager.DefaultPartSize, Concurrency: 2})
+			return err
+		}
+		_, err := svc.PutObject(&s3.PutObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(result.Key),
+			Body:   bytes.NewReader(probe),
+		})
+		return err
+	})
+	// Without the probe, reading it back is still attempted. A missing key
+	// then shows that s3:GetObject is granted, although the content can't
+	// be compared. S3 answers 403 rather than 404 for a missing key when
+	// s3:ListBucket is denied, so a 403 doesn't show s3:GetObject is missing.
+	step("get", "s3:GetObject", func() error {
+		out, err := svc.GetObject(&s3.GetObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(result.Key),
+		})
+		if putErr != nil && s3NoSuchKey(err) {
+			return nil
+		}
+		if putErr != nil && s3AccessDenied(err) {
+			return errS3NotVerified
+		}
+		if err != nil {
+			return err
+		}
+		defer out.Body.Close()
+		data, err := ioutil.ReadAll(out.Body)
+		if err != nil {
+			return err
+		}
+		if sha256.Sum256(data) != sha256.Sum256(probe) {
+			return fmt.Errorf("Object content does not match: read %d bytes, wrote %d", len(data), len(probe))
+		}
+		return nil
+	})
+	// Deleting a key that doesn't exist succeeds, so this checks
+	// s3:DeleteObject whether or not the probe was written.
+	step("delete", "s3:DeleteObject", func() error {
+		_, err := svc.DeleteObject(&s3.DeleteObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(result.Key),
+		})
+		return err
+	})
+
+	return s3BucketCheckResponse(result)
+}
+
+func s3BucketCheckResponse(result s3BucketCheckResult) ([]string, error) {
+	result.Passed = len(result.Steps) == 4
+	for _, s := range result.Steps {
+		result.Passed = result.Passed && s.Passed
+	}
+
+	results, err := jsonResult(result)
+	if err != nil {
+		return nil, err
+	}
+	if result.Passed {
+		return results, nil
+	}
+	if len(result.MissingPermissions) > 0 {
+		errMsg := fmt.Sprintf("Missing permissions %s on bucket %s", strings.Join(result.MissingPermissions, ", "), result.Bucket)
+		return results, ErrCommandResponse{errMsg}
+	}
+	failed := result.Steps[len(result.Steps)-1]
+	for _, s := range result.Steps {
+		if !s.Passed {
+			failed = s
+			break
+		}
+	}
+	errMsg := fmt.Sprintf("S3 bucket check failed at %s: %s", failed.Name, failed.Error)
+	return results, ErrCommandResponse{errMsg}
+}
+
+func s3NoSuchKey(err error) bool {
+	awserr := aws.Error(err)
+	return awserr != nil && awserr.Code == "NoSuchKey"
+}
+
+func s3AccessDenied(err error) bool {
+	awserr := aws.Error(err)
+	return awserr != nil && (awserr.Code == "AccessDenied" || awserr.StatusCode == http.StatusForbidden)
+}
+
+// s3ErrorMessage describes an S3 error. HEAD requests have no error body, so
+// those are described from the status code.
+func s3ErrorMessage(err error, region string) string {
+	awserr := aws.Error(err)
+	if awserr == nil {
+		return err.Error()
+	}
+	switch {
+	case awserr.Code == "NoSuchKey":
+		return "Object does not exist"
+	case awserr.Code == "NoSuchBucket" || awserr.StatusCode == http.StatusNotFound:
+		return "Bucket does not exist"
+	case awserr.StatusCode == http.StatusMovedPermanently:
+		return fmt.Sprintf("Bucket is not in region %s", region)
+	case s3AccessDenied(err):
+		return "Access denied"
+	}
+	return awserr.Error()
+}
@@ -0,0 +1,251 @@
+package command
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const testS3Bucket = "probe-bucket"
+
+// fakeS3 is a path-style S3 stand-in with a single bucket. Requests needing
+// a denied permission get a 403.
+type fakeS3 struct {
+	*fakeAWS
+	mu      sync.Mutex
+	deny    map[string]bool
+	objects map[string][]byte
+	parts   map[int][]byte
+}
+
+func serveFakeS3(t *testing.T, deny ...string) *fakeS3 {
+	t.Helper()
+	s := &fakeS3{deny: map[string]bool{}, objects: map[string][]byte{}, parts: map[int][]byte{}}
+	for _, permission := range deny {
+		s.deny[permission] = true
+	}
+	s.fakeAWS = serveFakeAWS(t, s.serveHTTP)
+	return s
+}
+
+func (s *fakeS3) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	path := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+	if path[0] != testS3Bucket {
+		w.WriteHeader(http.StatusNotFound)
+		if r.Method != "HEAD" {
+			fmt.Fprint(w, `<Error><Code>NoSuchBucket</Code><Message>The specified bucket does not exist</Message></Error>`)
+		}
+		return
+	}
+	permission := map[string]string{
+		"HEAD":   "s3:ListBucket",
+		"PUT":    "s3:PutObject",
+		"POST":   "s3:PutObject",
+		"GET":    "s3:GetObject",
+		"DELETE": "s3:DeleteObject",
+	}[r.Method]
+	if s.deny[permission] {
+		w.WriteHeader(http.StatusForbidden)
+		if r.Method != "HEAD" {
+			fmt.Fprint(w, `<Error><Code>AccessDenied</Code><Message>Access Denied</Message></Error>`)
+		}
+		return
+	}
+	if len(path) == 1 {
+		return
+	}
+
+	key := path[1]
+	query := r.URL.Query()
+	switch {
+	case r.Method == "POST" && query["uploads"] != nil:
+		s.parts = map[int][]byte{}
+		fmt.Fprintf(w, `<InitiateMultipartUploadResult><Bucket>%s</Bucket><Key>%s</Key><UploadId>1</UploadId></InitiateMultipartUploadResult>`, testS3Bucket, key)
+	case r.Method == "PUT" && query.Get("uploadId") != "":
+		n, _ := strconv.Atoi(query.Get("partNumber"))
+		s.parts[n], _ = ioutil.ReadAll(r.Body)
+		w.Header().Set("ETag", fmt.Sprintf(`"%d"`, n))
+	case r.Method == "POST" && query.Get("uploadId") != "":
+		var numbers []int
+		for n := range s.parts {
+			numbers = append(numbers, n)
+		}
+		sort.Ints(numbers)
+		var data []byte
+		for _, n := range numbers {
+			data = append(data, s.parts[n]...)
+		}
+		s.objects[key] = data
+		fmt.Fprintf(w, `<CompleteMultipartUploadResult><Location>http://%s/%s/%s</Location><Bucket>%s</Bucket><Key>%s</Key><ETag>"1"</ETag></CompleteMultipartUploadResult>`, r.Host, testS3Bucket, key, testS3Bucket, key)
+	case r.Method == "PUT":
+		s.objects[key], _ = ioutil.ReadAll(r.Body)
+	case r.Method == "GET":
+		data, ok := s.objects[key]
+		if !ok && s.deny["s3:ListBucket"] {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `<Error><Code>AccessDenied</Code><Message>Access Denied</Message></Error>`)
+			return
+		}
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `<Error><Code>NoSuchKey</Code><Message>The specified key does not exist.</Message></Error>`)
+			return
+		}
+		w.Write(data)
+	case r.Method == "DELETE":
+		delete(s.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (s *fakeS3) objectCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.objects)
+}
+
+func runS3BucketCheck(t *testing.T, s *fakeS3, bucket string, opts ...string) (s3BucketCheckResult, error) {
+	t.Helper()
+	args := append([]string{testAWSAccessKeyID, "secret", bucket, "--", "endpoint=" + s.URL}, opts...)
+	results, err := s3BucketCheckCommand(newTestCmd(t), args...)
+	if _, ok := err.(ErrCommandResponse); err != nil && !ok {
+		t.Fatalf("s3_bucket_check: %v", err)
+	}
+	var result s3BucketCheckResult
+	if len(results) != 1 {
+		t.Fatalf("got %d results", len(results))
+	}
+	if err := json.Unmarshal([]byte(results[0]), &result); err != nil {
+		t.Fatal(err)
+	}
+	return result, err
+}
+
+func stepResults(result s3BucketCheckResult) map[string]bool {
+	passed := map[string]bool{}
+	for _, s := range result.Steps {
+		passed[s.Name] = s.Passed
+	}
+	return passed
+}
+
+func TestS3BucketCheck(t *testing.T) {
+	for _, multipart := range []bool{false, true} {
+		s := serveFakeS3(t)
+		result, err := runS3BucketCheck(t, s, testS3Bucket, "prefix=checks/", "multipart="+strconv.FormatBool(multipart))
+		if err != nil {
+			t.Fatalf("multipart=%v: %v", multipart, err)
+		}
+		if !result.Passed || len(result.Steps) != 4 || len(result.MissingPermissions) != 0 {
+			t.Errorf("multipart=%v: unexpected result %+v", multipart, result)
+		}
+		if !strings.HasPrefix(result.Key, "checks/libcmd-probe-") {
+			t.Errorf("key = %q", result.Key)
+		}
+		if n := s.objectCount(); n != 0 {
+			t.Errorf("multipart=%v: %d objects left behind", multipart, n)
+		}
+		uploaded := false
+		for _, call := range s.calls() {
+			uploaded = uploaded || strings.HasPrefix(call, "POST ")
+		}
+		if uploaded != multipart {
+			t.Errorf("multipart=%v: calls %q", multipart, s.calls())
+		}
+	}
+}
+
+func TestS3BucketCheckMissingPermissions(t *testing.T) {
+	tests := []struct {
+		deny        []string
+		passed      map[string]bool
+		missing     []string
+		notVerified bool
+	}{
+		{
+			deny:    []string{"s3:PutObject"},
+			passed:  map[string]bool{"exists": true, "put": false, "get": true, "delete": true},
+			missing: []string{"s3:PutObject"},
+		},
+		{
+			deny:    []string{"s3:ListBucket"},
+			passed:  map[string]bool{"exists": false, "put": true, "get": true, "delete": true},
+			missing: []string{"s3:ListBucket"},
+		},
+		{
+			deny:    []string{"s3:ListBucket", "s3:GetObject", "s3:DeleteObject"},
+			passed:  map[string]bool{"exists": false, "put": true, "get": false, "delete": false},
+			missing: []string{"s3:ListBucket", "s3:GetObject", "s3:DeleteObject"},
+		},
+		{
+			// Without s3:ListBucket a missing key is a 403, so get can't be
+			// told apart from a denied s3:GetObject.
+			deny:        []string{"s3:ListBucket", "s3:PutObject"},
+			passed:      map[string]bool{"exists": false, "put": false, "get": false, "delete": true},
+			missing:     []string{"s3:ListBucket", "s3:PutObject"},
+			notVerified: true,
+		},
+		{
+			deny:        []string{"s3:ListBucket", "s3:PutObject", "s3:GetObject", "s3:DeleteObject"},
+			passed:      map[string]bool{"exists": false, "put": false, "get": false, "delete": false},
+			missing:     []string{"s3:ListBucket", "s3:PutObject", "s3:DeleteObject"},
+			notVerified: true,
+		},
+	}
+	for _, test := range tests {
+		s := serveFakeS3(t, test.deny...)
+		result, err := runS3BucketCheck(t, s, testS3Bucket)
+		if err == nil || result.Passed {
+			t.Errorf("deny %q: check passed", test.deny)
+			continue
+		}
+		if passed := stepResults(result); !reflect.DeepEqual(passed, test.passed) {
+			t.Errorf("deny %q: steps %v, want %v", test.deny, passed, test.passed)
+		}
+		if !reflect.DeepEqual(result.MissingPermissions, test.missing) {
+			t.Errorf("deny %q: missing permissions %q, want %q", test.deny, result.MissingPermissions, test.missing)
+		}
+		for _, s := range result.Steps {
+			if s.NotVerified != (test.notVerified && s.Name == "get") {
+				t.Errorf("deny %q: step %+v", test.deny, s)
+			}
+		}
+		want := fmt.Sprintf("Missing permissions %s on bucket %s", strings.Join(test.missing, ", "), testS3Bucket)
+		if err.Error() != want {
+			t.Errorf("deny %q: error %q, want %q", test.deny, err, want)
+		}
+	}
+}
+
+func TestS3BucketCheckNoBucket(t *testing.T) {
+	s := serveFakeS3(t)
+	result, err := runS3BucketCheck(t, s, "other-bucket")
+	if err == nil || !strings.Contains(err.Error(), "Bucket does not exist") {
+		t.Errorf("got %v, want a missing bucket error", err)
+	}
+	if len(result.Steps) != 1 || result.Passed {
+		t.Errorf("unexpected result %+v", result)
+	}
+	if calls := s.calls(); len(calls) != 1 {
+		t.Errorf("calls = %q, want only HeadBucket", calls)
+	}
+}
+
+func TestS3BucketCheckErrors(t *testing.T) {
+	if _, err := s3BucketCheckCommand(newTestCmd(t), testAWSAccessKeyID, "secret"); err != ErrMissingArgs {
+		t.Errorf("got %v, want ErrMissingArgs", err)
+	}
+	if _, err := s3BucketCheckCommand(newTestCmd(t), testAWSAccessKeyID, "secret", testS3Bucket, "--", "multipart=maybe"); err == nil {
+		t.Error("invalid multipart option accepted")
+	}
+}
@@ -0,0 +1,193 @@
+package command
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const maxAPIBodySize = 1 << 20
+
+type scmAuthResult struct {
+	Provider    string   `json:"provider"`
+	APIURL      string   `json:"api_url"`
+	Valid       bool     `json:"valid"`
+	Login       string   `json:"login,omitempty"`
+	Name        string   `json:"name,omitempty"`
+	TokenName   string   `json:"token_name,omitempty"`
+	Scopes      []string `json:"scopes,omitempty"`
+	Permissions []string `json:"permissions,omitempty"`
+	Missing     []string `json:"missing,omitempty"`
+	ExpiresAt   string   `json:"expires_at,omitempty"`
+	Error       string   `json:"error,omitempty"`
+}
+
+// apiError is a non-2xx response from a source control API, or a response
+// that fails a check when StatusCode is 0.
+type apiError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e apiError) Error() string {
+	if e.StatusCode == 0 {
+		return e.Message
+	}
+	if e.Message == "" {
+		return fmt.Sprintf("HTTP status code %d", e.StatusCode)
+	}
+	return fmt.Sprintf("HTTP status code %d: %s", e.StatusCode, e.Message)
+}
+
+// scmAuthResponse returns the results of a credential check. API errors fail
+// the check, other errors are returned as is.
+func scmAuthResponse(result *scmAuthResult, err error) ([]string, error) {
+	if err != nil {
+		if _, ok := err.(apiError); !ok {
+			return nil, err
+		}
+		result.Valid = false
+		result.Error = err.Error()
+	}
+	results, err := jsonResult(result)
+	if err != nil {
+		return nil, err
+	}
+	if !result.Valid {
+		errMsg := fmt.Sprintf("%s authentication failed: %s", result.Provider, result.Error)
+		return results, ErrCommandResponse{errMsg}
+	}
+	return results, nil
+}
+
+// requireValues records the required values missing from have and fails the
+// check if there are any.
+func requireValues(result *scmAuthResult, have, required []string, kind string) error {
+	for _, value := range required {
+		if !containsString(have, value) {
+			result.Missing = append(result.Missing, value)
+		}
+	}
+	if len(result.Missing) > 0 {
+		return apiError{Message: fmt.Sprintf("missing %s %s", kind, strings.Join(result.Missing, ", "))}
+	}
+	return nil
+}
+
+// apiRequest sends a JSON request and decodes a successful JSON response into
+// out. Other responses are returned as an apiError.
+func apiRequest(client *http.Client, method, reqURL string, header http.Header, body, out interface{}) (*http.Response, error) {
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reqBody = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, reqURL, reqBody)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxAPIBodySize))
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp, apiError{StatusCode: resp.StatusCode, Message: apiErrorMessage(data)}
+	}
+	if out != nil {
+		if err := json.Unmarshal(data, out); err != nil {
+			return nil, fmt.Errorf("Invalid API response from %s: %v", req.URL.Host, err)
+		}
+	}
+	return resp, nil
+}
+
+// apiErrorMessage extracts the message from the error bodies returned by
+// GitHub, GitLab and Bitbucket.
+func apiErrorMessage(data []byte) string {
+	var body struct {
+		Message          interface{} `json:"message"`
+		Error            interface{} `json:"error"`
+		ErrorDescription string      `json:"error_description"`
+		Errors           []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	if err := json.Unmarshal(data, &body); err != nil {
+		return ""
+	}
+	switch {
+	case body.ErrorDescription != "":
+		return body.ErrorDescription
+	case body.Message != nil:
+		return apiMessageString(body.Message)
+	case len(body.Errors) > 0:
+		return body.Errors[0].Message
+	case body.Error != nil:
+		return apiMessageString(body.Error)
+	}
+	return ""
+}
+
+// apiMessageString flattens messages that are strings or, as Bitbucket Cloud
+// and GitLab validation errors return, objects.
+func apiMessageString(v interface{}) string {
+	switch m := v.(type) {
+	case string:
+		return m
+	case map[string]interface{}:
+		if msg, ok := m["message"].(string); ok {
+			return msg
+		}
+	}
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+// apiBaseURL parses a self-hosted base url, defaulting to https when the
+// scheme is missing. The path is kept without a trailing slash so that
+// installs under a context path work.
+func apiBaseURL(rawURL string) (*url.URL, error) {
+	if !strings.Contains(rawURL, "://") {
+		rawURL = "https://" + rawURL
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid url: %v", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("Invalid url scheme: %q", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("Invalid url: %q", rawURL)
+	}
+	return &url.URL{Scheme: u.Scheme, Host: u.Host, Path: strings.TrimSuffix(u.Path, "/")}, nil
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
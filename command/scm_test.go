@@ -0,0 +1,89 @@
+package command
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// interceptingProxy is an HTTP proxy that terminates the TLS of every
+// CONNECT tunnel itself, with a certificate from the test PKI, and serves the
+// requests with handler. It stands in for the hosted APIs whose urls are
+// fixed, such as Bitbucket Cloud and gitlab.com.
+type interceptingProxy struct {
+	URL string
+
+	mu      sync.Mutex
+	targets []string
+}
+
+func serveInterceptingProxy(t *testing.T, pki *testPKI, handler http.Handler, dnsNames ...string) *interceptingProxy {
+	t.Helper()
+	config := &tls.Config{Certificates: []tls.Certificate{pki.tlsCertificate(t, dnsNames...)}}
+	p := &interceptingProxy{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "CONNECT" {
+			http.Error(w, "CONNECT only", http.StatusMethodNotAllowed)
+			return
+		}
+		p.mu.Lock()
+		p.targets = append(p.targets, r.Host)
+		p.mu.Unlock()
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+		go http.Serve(&oneConnListener{conn: tls.Server(conn, config)}, handler)
+	}))
+	t.Cleanup(server.Close)
+	p.URL = server.URL
+	return p
+}
+
+// connected returns the host:port of every tunnel requested so far.
+func (p *interceptingProxy) connected() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.targets...)
+}
+
+// oneConnListener hands a single connection to http.Serve.
+type oneConnListener struct {
+	conn net.Conn
+	once sync.Once
+}
+
+func (l *oneConnListener) Accept() (net.Conn, error) {
+	var conn net.Conn
+	l.once.Do(func() { conn = l.conn })
+	if conn == nil {
+		return nil, net.ErrClosed
+	}
+	return conn, nil
+}
+
+func (l *oneConnListener) Close() error   { return nil }
+func (l *oneConnListener) Addr() net.Addr { return l.conn.LocalAddr() }
+
+func TestAPIErrorMessage(t *testing.T) {
+	tests := []struct {
+		body string
+		want string
+	}{
+		{`{"message": "Bad credentials"}`, "Bad credentials"},
+		{`{"message": {"error": ["invalid"]}}`, `{"error":["invalid"]}`},
+		{`{"error": "invalid_token", "error_description": "Token was revoked"}`, "Token was revoked"},
+		{`{"error": {"message": "Access token expired"}}`, "Access token expired"},
+		{`{"errors": [{"message": "Authentication failed"}]}`, "Authentication failed"},
+		{`<html>Forbidden</html>`, ""},
+	}
+	for _, test := range tests {
+		if got := apiErrorMessage([]byte(test.body)); got != test.want {
+			t.Errorf("apiErrorMessage(%s) = %q, want %q", test.body, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,341 @@
+package command
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+const (
+	SMTPSecurityNone     = "none"
+	SMTPSecuritySTARTTLS = "starttls"
+	SMTPSecurityTLS      = "tls"
+
+	SMTPAuthPlain   = "PLAIN"
+	SMTPAuthLogin   = "LOGIN"
+	SMTPAuthCRAMMD5 = "CRAM-MD5"
+
+	defaultSMTPHelo    = "localhost"
+	defaultSMTPSubject = "SMTP check"
+	defaultSMTPTimeout = 30 * time.Second
+)
+
+type smtpStep struct {
+	Name    string `json:"name"`
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+	Passed  bool   `json:"passed"`
+}
+
+type smtpCheckResult struct {
+	Address       string     `json:"address"`
+	Security      string     `json:"security"`
+	Banner        string     `json:"banner"`
+	Extensions    []string   `json:"extensions"`
+	TLSVersion    string     `json:"tls_version,omitempty"`
+	AuthMechanism string     `json:"auth_mechanism,omitempty"`
+	Authenticated bool       `json:"authenticated"`
+	Sent          bool       `json:"sent"`
+	Steps         []smtpStep `json:"steps"`
+	Passed        bool       `json:"passed"`
+}
+
+type smtpSession struct {
+	conn   net.Conn
+	text   *textproto.Conn
+	result *smtpCheckResult
+}
+
+func smtpCheckCommand(c *GoCmd, args ...string) ([]string, error) {
+	// Should be:
+	// 0: host
+	// 1: port (optional, defaults to 465 for tls, 587 for starttls and 25 otherwise)
+	// Options:
+	// security: "none", "starttls" or "tls", defaults to starttls
+	// user, password: credentials to authenticate with
+	// auth: "PLAIN", "LOGIN" or "CRAM-MD5", defaults to the first one advertised
+	// insecure_auth: allow PLAIN and LOGIN without TLS
+	// from, to: send a test message when set, to is comma separated
+	// subject: subject of the test message
+	// helo: name sent with EHLO
+	// server_name: TLS server name, defaults to host
+	// ca, insecure: as for http_check
+	// timeout: overall timeout
+	positional, opts := parseArgs(args)
+	if len(positional) < 1 {
+		return nil, ErrMissingArgs
+	}
+	host := positional[0]
+	security := opts.get("security", SMTPSecuritySTARTTLS)
+	var port string
+	switch security {
+	case SMTPSecurityNone:
+		port = "25"
+	case SMTPSecuritySTARTTLS:
+		port = "587"
+	case SMTPSecurityTLS:
+		port = "465"
+	default:
+		return nil, fmt.Errorf("SMTP security must be one of %q, %q or %q", SMTPSecurityNone, SMTPSecuritySTARTTLS, SMTPSecurityTLS)
+	}
+	if len(positional) > 1 {
+		port = positional[1]
+	}
+	timeout, err := opts.getDuration("timeout", defaultSMTPTimeout)
+	if err != nil {
+		return nil, err
+	}
+	insecureAuth, err := opts.getBool("insecure_auth", false)
+	if err != nil {
+		return nil, err
+	}
+	// These end up in SMTP commands or message headers, where a line break
+	// would start another command or header.
+	for _, name := range []string{"from", "to", "subject", "helo"} {
+		for _, value := range opts.getAll(name) {
+			if strings.ContainsAny(value, "\r\n") {
+				return nil, fmt.Errorf("Invalid %s: line breaks are not allowed", name)
+			}
+		}
+	}
+	tlsConfig, err := tlsConfigFromOptions(host, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	result := smtpCheckResult{
+		Address:    net.JoinHostPort(host, port),
+		Security:   security,
+		Extensions: []string{},
+		Steps:      []smtpStep{},
+	}
+	result.Passed = c.smtpCheck(&result, tlsConfig, opts, timeout, insecureAuth) == nil
+
+	results, err := jsonResult(result)
+	if err != nil {
+		return nil, err
+	}
+	if !result.Passed {
+		failed := result.Steps[len(result.Steps)-1]
+		errMsg := fmt.Sprintf("SMTP %s failed: %s", failed.Name, failed.Message)
+		if failed.Code != 0 {
+			errMsg = fmt.Sprintf("SMTP %s failed with %d: %s", failed.Name, failed.Code, failed.Message)
+		}
+		return results, ErrCommandResponse{errMsg}
+	}
+	return results, nil
+}
+
+// smtpCheck runs the SMTP dialogue over a connection made through the
+// configured proxy. Every failure is recorded as the last step of result
+// before it is returned.
+func (c *GoCmd) smtpCheck(result *smtpCheckResult, tlsConfig *tls.Config, opts cmdOptions, timeout time.Duration, insecureAuth bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	conn, err := c.dialTCP(ctx, result.Address, timeout)
+	if err != nil {
+		result.Steps = append(result.Steps, smtpStep{Name: "connect", Message: err.Error()})
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+	if result.Security == SMTPSecurityTLS {
+		tlsConn := tls.Client(conn, tlsConfig)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			result.Steps = append(result.Steps, smtpStep{Name: "connect", Message: err.Error()})
+			return err
+		}
+		conn = tlsConn
+	}
+
+	s := &smtpSession{conn: conn, text: textproto.NewConn(conn), result: result}
+	s.recordTLS()
+	_, banner, err := s.read("banner", 220)
+	if err != nil {
+		return err
+	}
+	result.Banner = banner
+
+	helo := opts.get("helo", defaultSMTPHelo)
+	if err := s.ehlo(helo); err != nil {
+		return err
+	}
+	if result.Security == SMTPSecuritySTARTTLS {
+		if _, _, err := s.cmd("starttls", 220, "STARTTLS"); err != nil {
+			return err
+		}
+		tlsConn := tls.Client(conn, tlsConfig)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			return s.fail("tls_handshake", err)
+		}
+		s.conn = tlsConn
+		s.text = textproto.NewConn(tlsConn)
+		s.recordTLS()
+		if err := s.ehlo(helo); err != nil {
+			return err
+		}
+	}
+
+	if user := opts.get("user", ""); user != "" {
+		mechanism, err := s.authMechanism(opts.get("auth", ""))
+		if err != nil {
+			return s.fail("auth", err)
+		}
+		if result.TLSVersion == "" && !insecureAuth && mechanism != SMTPAuthCRAMMD5 {
+			err := fmt.Errorf("Refusing %s auth over an unencrypted connection", mechanism)
+			return s.fail("auth", err)
+		}
+		result.AuthMechanism = mechanism
+		if err := s.auth(mechanism, user, opts.get("password", "")); err != nil {
+			return err
+		}
+		result.Authenticated = true
+	}
+
+	from, to := opts.get("from", ""), opts.getList("to")
+	if from != "" && len(to) > 0 {
+		if err := s.send(from, to, opts.get("subject", defaultSMTPSubject)); err != nil {
+			return err
+		}
+		result.Sent = true
+	}
+
+	s.cmd("quit", 221, "QUIT")
+	return nil
+}
+
+func (s *smtpSession) recordTLS() {
+	if tlsConn, ok := s.conn.(*tls.Conn); ok {
+		s.result.TLSVersion = tls.VersionName(tlsConn.ConnectionState().Version)
+	}
+}
+
+// ehlo records the extensions advertised in the EHLO reply, one per line
+// after the greeting.
+func (s *smtpSession) ehlo(helo string) error {
+	_, msg, err := s.cmd("ehlo", 250, "EHLO %s", helo)
+	if err != nil {
+		return err
+	}
+	s.result.Extensions = []string{}
+	lines := strings.Split(msg, "\n")
+	for _, line := range lines[1:] {
+		s.result.Extensions = append(s.result.Extensions, line)
+	}
+	return nil
+}
+
+// authMechanism returns the requested mechanism, or the first of PLAIN, LOGIN
+// and CRAM-MD5 that the server advertises.
+func (s *smtpSession) authMechanism(requested string) (string, error) {
+	advertised := []string{}
+	for _, ext := range s.result.Extensions {
+		fields := strings.Fields(strings.ToUpper(ext))
+		if len(fields) > 0 && fields[0] == "AUTH" {
+			advertised = fields[1:]
+		}
+	}
+	if requested != "" {
+		requested = strings.ToUpper(requested)
+		if requested != SMTPAuthPlain && requested != SMTPAuthLogin && requested != SMTPAuthCRAMMD5 {
+			return "", fmt.Errorf("SMTP auth must be one of %q, %q or %q", SMTPAuthPlain, SMTPAuthLogin, SMTPAuthCRAMMD5)
+		}
+		return requested, nil
+	}
+	for _, mechanism := range []string{SMTPAuthPlain, SMTPAuthLogin, SMTPAuthCRAMMD5} {
+		if containsString(advertised, mechanism) {
+			return mechanism, nil
+		}
+	}
+	return "", errors.New("Server does not advertise a supported auth mechanism")
+}
+
+func (s *smtpSession) auth(mechanism, user, password string) error {
+	encode := base64.StdEncoding.EncodeToString
+	switch mechanism {
+	case SMTPAuthPlain:
+		_, _, err := s.cmd("auth", 235, "AUTH PLAIN %s", encode([]byte("\x00"+user+"\x00"+password)))
+		return err
+	case SMTPAuthLogin:
+		if _, _, err := s.cmd("auth", 334, "AUTH LOGIN"); err != nil {
+			return err
+		}
+		if _, _, err := s.cmd("auth", 334, "%s", encode([]byte(user))); err != nil {
+			return err
+		}
+		_, _, err := s.cmd("auth", 235, "%s", encode([]byte(password)))
+		return err
+	default:
+		_, msg, err := s.cmd("auth", 334, "AUTH CRAM-MD5")
+		if err != nil {
+			return err
+		}
+		challenge, err := base64.StdEncoding.DecodeString(msg)
+		if err != nil {
+			return s.fail("auth", err)
+		}
+		mac := hmac.New(md5.New, []byte(password))
+		mac.Write(challenge)
+		response := fmt.Sprintf("%s %s", user, hex.EncodeToString(mac.Sum(nil)))
+		_, _, err = s.cmd("auth", 235, "%s", encode([]byte(response)))
+		return err
+	}
+}
+
+func (s *smtpSession) send(from string, to []string, subject string) error {
+	if _, _, err := s.cmd("mail_from", 250, "MAIL FROM:<%s>", from); err != nil {
+		return err
+	}
+	for _, rcpt := range to {
+		if _, _, err := s.cmd("rcpt_to", 25, "RCPT TO:<%s>", rcpt); err != nil {
+			return err
+		}
+	}
+	if _, _, err := s.cmd("data", 354, "DATA"); err != nil {
+		return err
+	}
+	w := s.text.DotWriter()
+	fmt.Fprintf(w, "From: %s\r\nTo: %s\r\nSubject: %s\r\nDate: %s\r\n\r\n", from, strings.Join(to, ", "), subject, time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(w, "This is a test message sent to check the SMTP settings.\r\n")
+	if err := w.Close(); err != nil {
+		return s.fail("data", err)
+	}
+	_, _, err := s.read("data", 250)
+	return err
+}
+
+// cmd sends a command and records the reply as a step. A prefix of the
+// expected code is accepted as textproto.ReadResponse does.
+func (s *smtpSession) cmd(step string, expect int, format string, args ...interface{}) (int, string, error) {
+	id, err := s.text.Cmd(format, args...)
+	if err != nil {
+		return 0, "", s.fail(step, err)
+	}
+	s.text.StartResponse(id)
+	defer s.text.EndResponse(id)
+	return s.read(step, expect)
+}
+
+func (s *smtpSession) read(step string, expect int) (int, string, error) {
+	code, msg, err := s.text.ReadResponse(expect)
+	entry := smtpStep{Name: step, Code: code, Message: msg, Passed: err == nil}
+	if _, ok := err.(*textproto.Error); err != nil && !ok {
+		entry.Message = err.Error()
+	}
+	s.result.Steps = append(s.result.Steps, entry)
+	return code, msg, err
+}
+
+func (s *smtpSession) fail(step string, err error) error {
+	s.result.Steps = append(s.result.Steps, smtpStep{Name: step, Message: err.Error()})
+	return err
+}
@@ -0,0 +1,361 @@
+package command
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeSMTP is an SMTP stand-in that accepts user/pass with any of the
+// advertised auth mechanisms and rejects recipients at reject.example.com.
+type fakeSMTP struct {
+	addr      string
+	tlsConfig *tls.Config
+	implicit  bool
+	auth      string
+
+	mu       sync.Mutex
+	messages []string
+}
+
+// serveFakeSMTP listens on a local port. With implicit set, TLS starts on
+// connect, otherwise STARTTLS is offered.
+func serveFakeSMTP(t *testing.T, pki *testPKI, implicit bool, auth string) *fakeSMTP {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	s := &fakeSMTP{
+		addr:      l.Addr().String(),
+		tlsConfig: &tls.Config{Certificates: []tls.Certificate{pki.tlsCertificate(t, "mail.example.com")}},
+		implicit:  implicit,
+		auth:      auth,
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *fakeSMTP) serve(conn net.Conn) {
+	defer func() { conn.Close() }()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	secure := s.implicit
+	if secure {
+		conn = tls.Server(conn, s.tlsConfig)
+	}
+	text := textproto.NewConn(conn)
+	text.PrintfLine("220 mail.example.com ESMTP ready")
+
+	authenticated := func(user, pass string) {
+		if user == "user" && pass == "pass" {
+			text.PrintfLine("235 2.7.0 Authentication successful")
+		} else {
+			text.PrintfLine("535 5.7.8 Authentication credentials invalid")
+		}
+	}
+	readBase64 := func() string {
+		line, _ := text.ReadLine()
+		data, _ := base64.StdEncoding.DecodeString(line)
+		return string(data)
+	}
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg := line, ""
+		if i := strings.IndexByte(line, ' '); i >= 0 {
+			verb, arg = line[:i], line[i+1:]
+		}
+		switch strings.ToUpper(verb) {
+		case "EHLO":
+			lines := []string{"mail.example.com greets " + arg}
+			if !secure {
+				lines = append(lines, "STARTTLS")
+			}
+			lines = append(lines, "AUTH "+s.auth, "8BITMIME")
+			for i, l := range lines {
+				sep := "-"
+				if i == len(lines)-1 {
+					sep = " "
+				}
+				text.PrintfLine("250%s%s", sep, l)
+			}
+		case "STARTTLS":
+			text.PrintfLine("220 2.0.0 Ready to start TLS")
+			conn = tls.Server(conn, s.tlsConfig)
+			text = textproto.NewConn(conn)
+			secure = true
+		case "AUTH":
+			fields := strings.Fields(arg)
+			if !containsString(strings.Fields(s.auth), fields[0]) {
+				text.PrintfLine("504 5.5.4 Unrecognized authentication type")
+				continue
+			}
+			switch fields[0] {
+			case SMTPAuthPlain:
+				data, _ := base64.StdEncoding.DecodeString(fields[1])
+				parts := strings.Split(string(data), "\x00")
+				if len(parts) != 3 {
+					text.PrintfLine("501 5.5.2 Bad PLAIN response")
+					continue
+				}
+				authenticated(parts[1], parts[2])
+			case SMTPAuthLogin:
+				text.PrintfLine("334 VXNlcm5hbWU6")
+				user := readBase64()
+				text.PrintfLine("334 UGFzc3dvcmQ6")
+				authenticated(user, readBase64())
+			case SMTPAuthCRAMMD5:
+				challenge := "<1234.5678@mail.example.com>"
+				text.PrintfLine("334 %s", base64.StdEncoding.EncodeToString([]byte(challenge)))
+				fields := strings.Fields(readBase64())
+				mac := hmac.New(md5.New, []byte("pass"))
+				mac.Write([]byte(challenge))
+				if len(fields) == 2 && fields[0] == "user" && fields[1] == hex.EncodeToString(mac.Sum(nil)) {
+					authenticated("user", "pass")
+				} else {
+					authenticated("", "")
+				}
+			default:
+				text.PrintfLine("504 5.5.4 Unrecognized authentication type")
+			}
+		case "MAIL":
+			text.PrintfLine("250 2.1.0 Ok")
+		case "RCPT":
+			if strings.HasSuffix(arg, "@reject.example.com>") {
+				text.PrintfLine("550 5.1.1 Recipient address rejected")
+			} else {
+				text.PrintfLine("250 2.1.5 Ok")
+			}
+		case "DATA":
+			text.PrintfLine("354 End data with <CR><LF>.<CR><LF>")
+			lines, err := text.ReadDotLines()
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.messages = append(s.messages, strings.Join(lines, "\n"))
+			s.mu.Unlock()
+			text.PrintfLine("250 2.0.0 Ok: queued")
+		case "QUIT":
+			text.PrintfLine("221 2.0.0 Bye")
+			return
+		default:
+			text.PrintfLine("502 5.5.2 Command not recognized")
+		}
+	}
+}
+
+func (s *fakeSMTP) sent() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.messages...)
+}
+
+func runSMTPCheck(t *testing.T, c *GoCmd, addr string, opts ...string) (smtpCheckResult, error) {
+	t.Helper()
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	args := append([]string{host, port, "--", "timeout=5s"}, opts...)
+	results, err := smtpCheckCommand(c, args...)
+	if _, ok := err.(ErrCommandResponse); err != nil && !ok {
+		t.Fatalf("smtp_check: %v", err)
+	}
+	var result smtpCheckResult
+	if len(results) != 1 {
+		t.Fatalf("got %d results", len(results))
+	}
+	if err := json.Unmarshal([]byte(results[0]), &result); err != nil {
+		t.Fatal(err)
+	}
+	return result, err
+}
+
+func TestSMTPCheck(t *testing.T) {
+	pki := newTestPKI(t)
+	tests := []struct {
+		name      string
+		implicit  bool
+		auth      string
+		opts      []string
+		mechanism string
+	}{
+		{"starttls", false, "PLAIN LOGIN", []string{"security=starttls"}, SMTPAuthPlain},
+		{"starttls login", false, "PLAIN LOGIN", []string{"auth=login"}, SMTPAuthLogin},
+		{"tls", true, "LOGIN CRAM-MD5", []string{"security=tls"}, SMTPAuthLogin},
+		{"tls cram-md5", true, "PLAIN CRAM-MD5", []string{"security=tls", "auth=CRAM-MD5"}, SMTPAuthCRAMMD5},
+	}
+	for _, test := range tests {
+		s := serveFakeSMTP(t, pki, test.implicit, test.auth)
+		opts := append([]string{"ca=" + pki.caPEM, "server_name=mail.example.com", "user=user", "password=pass",
+			"from=check@example.com", "to=ops@example.com, admin@example.com", "subject=Settings check"}, test.opts...)
+		result, err := runSMTPCheck(t, newTestCmd(t), s.addr, opts...)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if !result.Passed || !result.Authenticated || !result.Sent || result.AuthMechanism != test.mechanism {
+			t.Errorf("%s: unexpected result %+v", test.name, result)
+		}
+		if result.TLSVersion != "TLS 1.3" {
+			t.Errorf("%s: TLS version %q", test.name, result.TLSVersion)
+		}
+		if result.Banner != "mail.example.com ESMTP ready" {
+			t.Errorf("%s: banner %q", test.name, result.Banner)
+		}
+		if !containsString(result.Extensions, "AUTH "+test.auth) || containsString(result.Extensions, "STARTTLS") {
+			t.Errorf("%s: extensions after TLS %q", test.name, result.Extensions)
+		}
+		sent := s.sent()
+		if len(sent) != 1 || !strings.Contains(sent[0], "Subject: Settings check\n") ||
+			!strings.Contains(sent[0], "To: ops@example.com, admin@example.com\n") {
+			t.Errorf("%s: sent %q", test.name, sent)
+		}
+	}
+}
+
+func TestSMTPCheckPlaintext(t *testing.T) {
+	pki := newTestPKI(t)
+
+	// CRAM-MD5 doesn't send the password, so it is allowed without TLS.
+	s := serveFakeSMTP(t, pki, false, "CRAM-MD5")
+	result, err := runSMTPCheck(t, newTestCmd(t), s.addr, "security=none", "user=user", "password=pass")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.TLSVersion != "" || !result.Authenticated || result.Sent {
+		t.Errorf("unexpected result %+v", result)
+	}
+
+	s = serveFakeSMTP(t, pki, false, "PLAIN")
+	_, err = runSMTPCheck(t, newTestCmd(t), s.addr, "security=none", "user=user", "password=pass")
+	if err == nil || !strings.Contains(err.Error(), "Refusing PLAIN auth over an unencrypted connection") {
+		t.Errorf("got %v, want plaintext auth refused", err)
+	}
+	if _, err := runSMTPCheck(t, newTestCmd(t), s.addr, "security=none", "user=user", "password=pass", "insecure_auth=true"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSMTPCheckFailures(t *testing.T) {
+	pki := newTestPKI(t)
+	s := serveFakeSMTP(t, pki, false, "PLAIN LOGIN")
+	tests := []struct {
+		name   string
+		opts   []string
+		step   string
+		errMsg string
+	}{
+		{
+			name:   "wrong password",
+			opts:   []string{"ca=" + pki.caPEM, "server_name=mail.example.com", "user=user", "password=wrong"},
+			step:   "auth",
+			errMsg: "SMTP auth failed with 535: 5.7.8 Authentication credentials invalid",
+		},
+		{
+			name:   "rejected recipient",
+			opts:   []string{"ca=" + pki.caPEM, "server_name=mail.example.com", "from=check@example.com", "to=ops@example.com,ops@reject.example.com"},
+			step:   "rcpt_to",
+			errMsg: "SMTP rcpt_to failed with 550: 5.1.1 Recipient address rejected",
+		},
+		{
+			name: "untrusted certificate",
+			opts: []string{"server_name=mail.example.com"},
+			step: "tls_handshake",
+		},
+		{
+			name:   "unadvertised mechanism",
+			opts:   []string{"ca=" + pki.caPEM, "server_name=mail.example.com", "user=user", "password=pass", "auth=cram-md5"},
+			step:   "auth",
+			errMsg: "SMTP auth failed with 504: 5.5.4 Unrecognized authentication type",
+		},
+	}
+	for _, test := range tests {
+		result, err := runSMTPCheck(t, newTestCmd(t), s.addr, test.opts...)
+		if err == nil || result.Passed {
+			t.Errorf("%s: check passed", test.name)
+			continue
+		}
+		if failed := result.Steps[len(result.Steps)-1]; failed.Name != test.step || failed.Passed {
+			t.Errorf("%s: last step %+v, want a failed %s", test.name, failed, test.step)
+		}
+		if test.errMsg != "" && err.Error() != test.errMsg {
+			t.Errorf("%s: error %q, want %q", test.name, err, test.errMsg)
+		}
+	}
+	if len(s.sent()) != 0 {
+		t.Errorf("messages sent: %q", s.sent())
+	}
+}
+
+func TestSMTPCheckThroughProxy(t *testing.T) {
+	pki := newTestPKI(t)
+	s := serveFakeSMTP(t, pki, true, "PLAIN")
+	for _, test := range []struct {
+		socks5 bool
+		scheme string
+	}{
+		{false, "http"},
+		{true, "socks5"},
+	} {
+		proxy := serveTestProxy(t, test.socks5, "")
+		c := newProxiedCmd(t, CmdConfig{ProxyURL: proxy.url(test.scheme)})
+		result, err := runSMTPCheck(t, c, s.addr, "security=tls", "ca="+pki.caPEM, "server_name=mail.example.com", "user=user", "password=pass")
+		if err != nil {
+			t.Errorf("%s: %v", test.scheme, err)
+			continue
+		}
+		if !result.Authenticated {
+			t.Errorf("%s: unexpected result %+v", test.scheme, result)
+		}
+		if proxy.count() != 1 {
+			t.Errorf("%s: %d proxied connections, want 1", test.scheme, proxy.count())
+		}
+	}
+}
+
+func TestSMTPCheckErrors(t *testing.T) {
+	if _, err := smtpCheckCommand(newTestCmd(t)); err != ErrMissingArgs {
+		t.Errorf("got %v, want ErrMissingArgs", err)
+	}
+	for _, opt := range []string{"security=ssl", "timeout=soon", "insecure_auth=maybe"} {
+		if _, err := smtpCheckCommand(newTestCmd(t), "127.0.0.1", "--", opt); err == nil {
+			t.Errorf("%s accepted", opt)
+		}
+	}
+
+	// Line breaks would smuggle in SMTP commands or headers, so they are
+	// rejected before connecting.
+	for _, opt := range []string{
+		"from=sender@example.com\r\nRCPT TO:<victim@example.com>",
+		"to=rcpt@example.com,other@example.com\nDATA",
+		"subject=Check\r\nBcc: victim@example.com",
+		"helo=client\rQUIT",
+	} {
+		_, err := smtpCheckCommand(newTestCmd(t), "127.0.0.1", "--", "to=rcpt@example.com", opt)
+		if err == nil || !strings.Contains(err.Error(), "line breaks are not allowed") {
+			t.Errorf("%q: got %v", opt, err)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package command
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/awslabs/aws-sdk-go/aws"
+	"github.com/awslabs/aws-sdk-go/service/sns"
+)
+
+const (
+	SNSFailureTopicNotFound = "topic_not_found"
+	SNSFailureAuthorization = "authorization"
+	SNSFailureKMS           = "kms"
+	SNSFailureOther         = "other"
+
+	defaultSNSCheckMessage = "SNS publish check"
+)
+
+type snsPublishCheckResult struct {
+	TopicARN  string `json:"topic_arn"`
+	Region    string `json:"region"`
+	MessageID string `json:"message_id,omitempty"`
+	Failure   string `json:"failure,omitempty"`
+	Error     string `json:"error,omitempty"`
+	Passed    bool   `json:"passed"`
+}
+
+func snsPublishCheckCommand(c *GoCmd, args ...string) ([]string, error) {
+	// Should be:
+	// 0: aws_access_key_id
+	// 1: aws_secret_access_key
+	// 2: topic_arn
+	// Options:
+	// message, subject: the test message
+	// attribute: "name=value", or "name:Type=value" for a Number or Binary
+	//   data type, may be repeated
+	// region: defaults to the region in the topic ARN
+	// session_token, endpoint: as for aws_auth
+	// ca, insecure, timeout: as for http_check
+	positional, opts := parseArgs(args)
+	if len(positional) < 3 {
+		return nil, ErrMissingArgs
+	}
+	topicARN := positional[2]
+	arnParts := strings.Split(topicARN, ":")
+	if len(arnParts) != 6 || arnParts[0] != "arn" || arnParts[2] != AWSServiceSNS {
+		return nil, fmt.Errorf("Invalid SNS topic ARN: %q", topicARN)
+	}
+	opts.setDefault("region", arnParts[3])
+	attributes, err := snsMessageAttributes(opts.getAll("attribute"))
+	if err != nil {
+		return nil, err
+	}
+	config, err := c.awsConfigFromOptions(positional[0], positional[1], opts)
+	if err != nil {
+		return nil, err
+	}
+
+	input := &sns.PublishInput{
+		TopicARN: aws.String(topicARN),
+		Message:  aws.String(opts.get("message", defaultSNSCheckMessage)),
+	}
+	if subject := opts.get("subject", ""); subject != "" {
+		input.Subject = aws.String(subject)
+	}
+	if len(attributes) > 0 {
+		input.MessageAttributes = &attributes
+	}
+
+	result := snsPublishCheckResult{
+		TopicARN: topicARN,
+		Region:   config.Region,
+	}
+	out, err := sns.New(config).Publish(input)
+	if awserr := aws.Error(err); awserr != nil {
+		result.Failure = snsFailure(awserr.Code)
+		result.Error = awserr.Error()
+	} else if err != nil {
+		return nil, err
+	} else {
+		result.MessageID = stringValue(out.MessageID)
+		result.Passed = true
+	}
+
+	results, err := jsonResult(result)
+	if err != nil {
+		return nil, err
+	}
+	if !result.Passed {
+		var errMsg string
+		switch result.Failure {
+		case SNSFailureTopicNotFound:
+			errMsg = fmt.Sprintf("SNS topic %s does not exist", topicARN)
+		case SNSFailureAuthorization:
+			errMsg = fmt.Sprintf("Not authorized to publish to SNS topic %s: %s", topicARN, result.Error)
+		case SNSFailureKMS:
+			errMsg = fmt.Sprintf("SNS topic %s is encrypted with a KMS key that cannot be used: %s", topicARN, result.Error)
+		default:
+			errMsg = fmt.Sprintf("SNS publish failed: %s", result.Error)
+		}
+		return results, ErrCommandResponse{errMsg}
+	}
+	return results, nil
+}
+
+// snsFailure classifies the error codes returned by Publish.
+func snsFailure(code string) string {
+	switch {
+	case code == "NotFound":
+		return SNSFailureTopicNotFound
+	case code == "AuthorizationError" || code == "InvalidClientTokenId" || code == "SignatureDoesNotMatch":
+		return SNSFailureAuthorization
+	case strings.HasPrefix(code, "KMS"):
+		return SNSFailureKMS
+	default:
+		return SNSFailureOther
+	}
+}
+
+func snsMessageAttributes(specs []string) (map[string]*sns.MessageAttributeValue, error) {
+	attributes := map[string]*sns.MessageAttributeValue{}
+	for _, spec := range specs {
+		parts := strings.SplitN(spec, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("Invalid message attribute: %q", spec)
+		}
+		name, dataType := parts[0], "String"
+		if i := strings.Index(name, ":"); i >= 0 {
+			name, dataType = name[:i], name[i+1:]
+		}
+		value := &sns.MessageAttributeValue{DataType: aws.String(dataType)}
+		switch strings.SplitN(dataType, ".", 2)[0] {
+		case "String", "Number":
+			value.StringValue = aws.String(parts[1])
+		case "Binary":
+			value.BinaryValue = []byte(parts[1])
+		default:
+			return nil, fmt.Errorf("Invalid message attribute data type: %q", dataType)
+		}
+		attributes[name] = value
+	}
+	return attributes, nil
+}
@@ -0,0 +1,192 @@
+package command
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeSNS answers Publish by topic name: "missing", "denied" and
+// "encrypted" fail the way SNS does, "invalid" fails with another error and
+// any other topic accepts the message.
+type fakeSNS struct {
+	*fakeAWS
+	mu      sync.Mutex
+	publish url.Values
+}
+
+func serveFakeSNS(t *testing.T) *fakeSNS {
+	t.Helper()
+	s := &fakeSNS{}
+	s.fakeAWS = serveFakeAWS(t, s.serveHTTP)
+	return s
+}
+
+func (s *fakeSNS) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Form.Get("Action") != "Publish" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	s.publish = r.Form
+	s.mu.Unlock()
+
+	arn := r.Form.Get("TopicArn")
+	snsError := func(status int, code, message string) {
+		w.WriteHeader(status)
+		fmt.Fprintf(w, `<ErrorResponse><Error><Type>Sender</Type><Code>%s</Code><Message>%s</Message></Error><RequestId>1</RequestId></ErrorResponse>`, code, message)
+	}
+	switch arn[strings.LastIndex(arn, ":")+1:] {
+	case "missing":
+		snsError(http.StatusNotFound, "NotFound", "Topic does not exist")
+	case "denied":
+		snsError(http.StatusForbidden, "AuthorizationError", "User is not authorized to perform: SNS:Publish")
+	case "encrypted":
+		snsError(http.StatusBadRequest, "KMSDisabled", "The request was rejected because the specified key is disabled")
+	case "invalid":
+		snsError(http.StatusBadRequest, "InvalidParameter", "Invalid parameter: Message too long")
+	default:
+		fmt.Fprint(w, `<PublishResponse><PublishResult><MessageId>11111111-2222-3333-4444-555555555555</MessageId></PublishResult><ResponseMetadata><RequestId>1</RequestId></ResponseMetadata></PublishResponse>`)
+	}
+}
+
+// attributes returns the message attributes of the last Publish as
+// "DataType value".
+func (s *fakeSNS) attributes() map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	attributes := map[string]string{}
+	for i := 1; ; i++ {
+		prefix := fmt.Sprintf("MessageAttributes.entry.%d.", i)
+		name := s.publish.Get(prefix + "Name")
+		if name == "" {
+			return attributes
+		}
+		value := s.publish.Get(prefix + "Value.StringValue")
+		if value == "" {
+			value = s.publish.Get(prefix + "Value.BinaryValue")
+		}
+		attributes[name] = s.publish.Get(prefix+"Value.DataType") + " " + value
+	}
+}
+
+func runSNSPublishCheck(t *testing.T, s *fakeSNS, accessKeyID, arn string, opts ...string) (snsPublishCheckResult, error) {
+	t.Helper()
+	args := append([]string{accessKeyID, "secret", arn, "--", "endpoint=" + s.URL}, opts...)
+	results, err := snsPublishCheckCommand(newTestCmd(t), args...)
+	if _, ok := err.(ErrCommandResponse); err != nil && !ok {
+		t.Fatalf("sns_publish_check: %v", err)
+	}
+	var result snsPublishCheckResult
+	if len(results) != 1 {
+		t.Fatalf("got %d results", len(results))
+	}
+	if err := json.Unmarshal([]byte(results[0]), &result); err != nil {
+		t.Fatal(err)
+	}
+	return result, err
+}
+
+func TestSNSPublishCheck(t *testing.T) {
+	s := serveFakeSNS(t)
+	arn := "arn:aws:sns:us-west-2:123456789012:events"
+	result, err := runSNSPublishCheck(t, s, testAWSAccessKeyID, arn,
+		"subject=Check",
+		"attribute=color=red",
+		"attribute=count:Number=3",
+		"attribute=raw:Binary=xyz",
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := snsPublishCheckResult{
+		TopicARN:  arn,
+		Region:    "us-west-2",
+		MessageID: "11111111-2222-3333-4444-555555555555",
+		Passed:    true,
+	}
+	if result != want {
+		t.Errorf("result = %+v, want %+v", result, want)
+	}
+	if got := s.publish.Get("Message"); got != defaultSNSCheckMessage {
+		t.Errorf("message = %q", got)
+	}
+	if got := s.publish.Get("Subject"); got != "Check" {
+		t.Errorf("subject = %q", got)
+	}
+	wantAttributes := map[string]string{
+		"color": "String red",
+		"count": "Number 3",
+		"raw":   "Binary eHl6",
+	}
+	if got := s.attributes(); !reflect.DeepEqual(got, wantAttributes) {
+		t.Errorf("attributes = %q, want %q", got, wantAttributes)
+	}
+
+	result, err = runSNSPublishCheck(t, s, testAWSAccessKeyID, arn, "message=hello", "region=eu-west-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Region != "eu-west-1" {
+		t.Errorf("region = %q, want the region option", result.Region)
+	}
+	if got := s.publish.Get("Message"); got != "hello" {
+		t.Errorf("message = %q", got)
+	}
+	if _, ok := s.publish["Subject"]; ok {
+		t.Error("subject sent without the subject option")
+	}
+}
+
+func TestSNSPublishCheckFailures(t *testing.T) {
+	s := serveFakeSNS(t)
+	tests := []struct {
+		accessKeyID string
+		topic       string
+		failure     string
+		errPrefix   string
+	}{
+		{testAWSAccessKeyID, "missing", SNSFailureTopicNotFound, "SNS topic arn:aws:sns:us-east-1:123456789012:missing does not exist"},
+		{testAWSAccessKeyID, "denied", SNSFailureAuthorization, "Not authorized to publish"},
+		{"AKIDWRONG", "events", SNSFailureAuthorization, "Not authorized to publish"},
+		{testAWSAccessKeyID, "encrypted", SNSFailureKMS, "SNS topic arn:aws:sns:us-east-1:123456789012:encrypted is encrypted with a KMS key"},
+		{testAWSAccessKeyID, "invalid", SNSFailureOther, "SNS publish failed"},
+	}
+	for _, test := range tests {
+		result, err := runSNSPublishCheck(t, s, test.accessKeyID, "arn:aws:sns:us-east-1:123456789012:"+test.topic)
+		if err == nil || result.Passed {
+			t.Errorf("%s: check passed", test.topic)
+			continue
+		}
+		if result.Failure != test.failure {
+			t.Errorf("%s: failure %q, want %q", test.topic, result.Failure, test.failure)
+		}
+		if !strings.HasPrefix(err.Error(), test.errPrefix) {
+			t.Errorf("%s: error %q, want prefix %q", test.topic, err, test.errPrefix)
+		}
+		if result.Error == "" || result.MessageID != "" {
+			t.Errorf("%s: unexpected result %+v", test.topic, result)
+		}
+	}
+}
+
+func TestSNSPublishCheckErrors(t *testing.T) {
+	arn := "arn:aws:sns:us-east-1:123456789012:events"
+	for _, args := range [][]string{
+		{testAWSAccessKeyID, "secret"},
+		{testAWSAccessKeyID, "secret", "events"},
+		{testAWSAccessKeyID, "secret", "arn:aws:sqs:us-east-1:123456789012:events"},
+		{testAWSAccessKeyID, "secret", arn, "--", "attribute=novalue"},
+		{testAWSAccessKeyID, "secret", arn, "--", "attribute==value"},
+		{testAWSAccessKeyID, "secret", arn, "--", "attribute=name:Map=value"},
+	} {
+		if _, err := snsPublishCheckCommand(newTestCmd(t), args...); err == nil {
+			t.Errorf("sns_publish_check(%q) succeeded", args)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package command
+
+import (
+	"encoding/pem"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const defaultSSHRSABits = 3072
+
+func sshKeygenCommand(c *GoCmd, args ...string) ([]string, error) {
+	// Options:
+	// key: "ed25519", "ecdsa-p256", "ecdsa-p384" or "rsa"
+	// bits: rsa key size
+	// comment: authorized_keys comment
+	// passphrase: encrypts the private key
+	// Returns the private key, the authorized_keys line and the SHA256 and MD5
+	// fingerprints.
+	_, opts := parseArgs(args)
+	bits, err := opts.getInt("bits", defaultSSHRSABits)
+	if err != nil {
+		return nil, err
+	}
+	key, err := generateKey(opts.get("key", KeyTypeEd25519), bits)
+	if err != nil {
+		return nil, err
+	}
+
+	comment := opts.get("comment", "")
+	var block *pem.Block
+	if passphrase := opts.get("passphrase", ""); passphrase != "" {
+		block, err = ssh.MarshalPrivateKeyWithPassphrase(key, comment, []byte(passphrase))
+	} else {
+		block, err = ssh.MarshalPrivateKey(key, comment)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	pub, err := ssh.NewPublicKey(key.Public())
+	if err != nil {
+		return nil, err
+	}
+	authorizedKey := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(pub)))
+	if comment != "" {
+		authorizedKey = authorizedKey + " " + comment
+	}
+
+	return []string{
+		strings.TrimSpace(string(pem.EncodeToMemory(block))),
+		authorizedKey,
+		ssh.FingerprintSHA256(pub),
+		ssh.FingerprintLegacyMD5(pub),
+	}, nil
+}
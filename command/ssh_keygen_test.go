@@ -0,0 +1,109 @@
+package command
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestSSHKeygen(t *testing.T) {
+	tests := []struct {
+		args    []string
+		keyType string
+		bits    int
+	}{
+		{args: nil, keyType: ssh.KeyAlgoED25519, bits: 256},
+		{args: []string{"key=ed25519", "comment=deploy@example.com"}, keyType: ssh.KeyAlgoED25519, bits: 256},
+		{args: []string{"key=ecdsa-p256"}, keyType: ssh.KeyAlgoECDSA256, bits: 256},
+		{args: []string{"key=ecdsa-p384", "comment=ci"}, keyType: ssh.KeyAlgoECDSA384, bits: 384},
+		{args: []string{"key=rsa"}, keyType: ssh.KeyAlgoRSA, bits: defaultSSHRSABits},
+		{args: []string{"key=rsa", "bits=2048"}, keyType: ssh.KeyAlgoRSA, bits: 2048},
+		{args: []string{"key=ecdsa-p256", "passphrase=secret"}, keyType: ssh.KeyAlgoECDSA256, bits: 256},
+	}
+	for _, test := range tests {
+		_, opts := parseArgs(append([]string{"--"}, test.args...))
+		results, err := sshKeygenCommand(nil, append([]string{"--"}, test.args...)...)
+		if err != nil {
+			t.Errorf("ssh_keygen(%q): %v", test.args, err)
+			continue
+		}
+		if len(results) != 4 {
+			t.Fatalf("ssh_keygen(%q) returned %d results", test.args, len(results))
+		}
+
+		var signer ssh.Signer
+		if passphrase := opts.get("passphrase", ""); passphrase != "" {
+			if _, err := ssh.ParsePrivateKey([]byte(results[0])); err == nil {
+				t.Errorf("ssh_keygen(%q): private key is not encrypted", test.args)
+			}
+			signer, err = ssh.ParsePrivateKeyWithPassphrase([]byte(results[0]), []byte(passphrase))
+		} else {
+			signer, err = ssh.ParsePrivateKey([]byte(results[0]))
+		}
+		if err != nil {
+			t.Errorf("ssh_keygen(%q): private key: %v", test.args, err)
+			continue
+		}
+
+		pub, comment, _, rest, err := ssh.ParseAuthorizedKey([]byte(results[1]))
+		if err != nil || len(rest) != 0 {
+			t.Errorf("ssh_keygen(%q): authorized key %q: %v", test.args, results[1], err)
+			continue
+		}
+		if pub.Type() != test.keyType {
+			t.Errorf("ssh_keygen(%q): key type %s, want %s", test.args, pub.Type(), test.keyType)
+		}
+		if comment != opts.get("comment", "") {
+			t.Errorf("ssh_keygen(%q): comment %q", test.args, comment)
+		}
+		if string(pub.Marshal()) != string(signer.PublicKey().Marshal()) {
+			t.Errorf("ssh_keygen(%q): authorized key does not match the private key", test.args)
+		}
+		if bits := sshKeyBits(pub); bits != test.bits {
+			t.Errorf("ssh_keygen(%q): %d bit key, want %d", test.args, bits, test.bits)
+		}
+
+		if results[2] != ssh.FingerprintSHA256(pub) || !strings.HasPrefix(results[2], "SHA256:") {
+			t.Errorf("ssh_keygen(%q): SHA256 fingerprint %q, want %q", test.args, results[2], ssh.FingerprintSHA256(pub))
+		}
+		if results[3] != ssh.FingerprintLegacyMD5(pub) {
+			t.Errorf("ssh_keygen(%q): MD5 fingerprint %q, want %q", test.args, results[3], ssh.FingerprintLegacyMD5(pub))
+		}
+
+		// The key signs what the public key verifies.
+		data := []byte("ssh_keygen")
+		sig, err := signer.Sign(nil, data)
+		if err != nil || pub.Verify(data, sig) != nil {
+			t.Errorf("ssh_keygen(%q): signature does not verify: %v", test.args, err)
+		}
+	}
+}
+
+// sshKeyBits returns the size of an ssh public key.
+func sshKeyBits(pub ssh.PublicKey) int {
+	switch k := pub.(ssh.CryptoPublicKey).CryptoPublicKey().(type) {
+	case *rsa.PublicKey:
+		return k.N.BitLen()
+	case *ecdsa.PublicKey:
+		return k.Curve.Params().BitSize
+	default:
+		return 256
+	}
+}
+
+func TestSSHKeygenErrors(t *testing.T) {
+	for _, args := range [][]string{
+		{"key=rsa", "bits=512"},
+		{"key=rsa", "bits=3000"},
+		{"key=rsa", "bits=large"},
+		{"key=dsa"},
+		{"key=ecdsa-p521"},
+	} {
+		if _, err := sshKeygenCommand(nil, append([]string{"--"}, args...)...); err == nil {
+			t.Errorf("ssh_keygen(%q) succeeded", args)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+const (
+	defaultBannerTimeout = 2 * time.Second
+	maxBannerSize        = 512
+)
+
+type tcpCheckResult struct {
+	Target     string  `json:"target"`
+	Connected  bool    `json:"connected"`
+	LatencyMS  float64 `json:"latency_ms"`
+	RemoteAddr string  `json:"remote_addr,omitempty"`
+	Proxy      string  `json:"proxy,omitempty"`
+	Banner     string  `json:"banner,omitempty"`
+	Error      string  `json:"error,omitempty"`
+}
+
+func tcpCheckCommand(c *GoCmd, args ...string) ([]string, error) {
+	// Should be:
+	// 0..n: targets as host:port
+	// Options:
+	// timeout: connect timeout
+	// proxy: connect through the configured proxy, defaults to false
+	// banner: read what the server sends first, such as an SSH version string
+	// banner_timeout: how long to wait for the banner
+	positional, opts := parseArgs(args)
+	if len(positional) < 1 {
+		return nil, ErrMissingArgs
+	}
+	for _, target := range positional {
+		if _, _, err := net.SplitHostPort(target); err != nil {
+			return nil, fmt.Errorf("Invalid target %q: %v", target, err)
+		}
+	}
+	timeout, err := opts.getDuration("timeout", defaultDialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	proxy, err := opts.getBool("proxy", false)
+	if err != nil {
+		return nil, err
+	}
+	banner, err := opts.getBool("banner", false)
+	if err != nil {
+		return nil, err
+	}
+	bannerTimeout, err := opts.getDuration("banner_timeout", defaultBannerTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	checks := make([]tcpCheckResult, len(positional))
+	var wg sync.WaitGroup
+	for i, target := range positional {
+		wg.Add(1)
+		go func(i int, target string) {
+			defer wg.Done()
+			checks[i] = c.tcpCheck(target, timeout, proxy, banner, bannerTimeout)
+		}(i, target)
+	}
+	wg.Wait()
+
+	results, err := jsonResult(checks)
+	if err != nil {
+		return nil, err
+	}
+	failed := []string{}
+	for _, check := range checks {
+		if !check.Connected {
+			failed = append(failed, check.Target)
+		}
+	}
+	if len(failed) > 0 {
+		errMsg := fmt.Sprintf("Unable to connect to %s", strings.Join(failed, ", "))
+		return results, ErrCommandResponse{errMsg}
+	}
+	return results, nil
+}
+
+// tcpCheck connects to target and optionally reads the banner the server
+// sends on connect. It dials directly unless proxy is set, in which case it
+// goes through the configured proxy, if any, and records it in the result.
+// The connection is always closed.
+func (c *GoCmd) tcpCheck(target string, timeout time.Duration, proxy, banner bool, bannerTimeout time.Duration) tcpCheckResult {
+	result := tcpCheckResult{Target: target}
+
+	if proxy {
+		proxyURL, err := c.proxyFor(target)
+		if err != nil {
+			result.Error = err.Error()
+			return result
+		}
+		if proxyURL != nil {
+			result.Proxy = proxyURL.Redacted()
+		}
+	}
+
+	start := time.Now()
+	var conn net.Conn
+	var err error
+	if proxy {
+		conn, err = c.dialTCP(context.Background(), target, timeout)
+	} else {
+		conn, err = c.dial(context.Background(), "tcp", target, timeout)
+	}
+	result.LatencyMS = float64(time.Since(start)) / float64(time.Millisecond)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	defer conn.Close()
+	result.Connected = true
+	result.RemoteAddr = conn.RemoteAddr().String()
+
+	if banner {
+		conn.SetReadDeadline(time.Now().Add(bannerTimeout))
+		buf := make([]byte, maxBannerSize)
+		n, err := conn.Read(buf)
+		if n > 0 {
+			result.Banner = sanitizeBanner(string(buf[:n]))
+		} else if err != nil {
+			result.Error = fmt.Sprintf("no banner: %v", err)
+		}
+	}
+	return result
+}
+
+// sanitizeBanner trims the banner and replaces control characters so that
+// binary protocols do not garble the output.
+func sanitizeBanner(banner string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsPrint(r) || r == '\n' {
+			return r
+		}
+		return '.'
+	}, strings.TrimSpace(banner))
+}
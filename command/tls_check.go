@@ -0,0 +1,175 @@
+package command
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+const defaultDialTimeout = 10 * time.Second
+
+type tlsCheckResult struct {
+	Address       string     `json:"address"`
+	ServerName    string     `json:"server_name"`
+	Version       string     `json:"version"`
+	CipherSuite   string     `json:"cipher_suite"`
+	Protocol      string     `json:"protocol,omitempty"`
+	Chain         []certInfo `json:"chain"`
+	HostnameValid bool       `json:"hostname_valid"`
+	HostnameError string     `json:"hostname_error,omitempty"`
+	Verified      bool       `json:"verified"`
+	VerifyError   string     `json:"verify_error,omitempty"`
+}
+
+func tlsCheckCommand(c *GoCmd, args ...string) ([]string, error) {
+	// Should be:
+	// 0: host
+	// 1: port
+	// 2: servername (optional, defaults to host)
+	// Options:
+	// ca: trusted CA bundle, PEM or base64. Defaults to the system pool.
+	// client_cert, client_key: client certificate, PEM or base64
+	// timeout: dial and handshake timeout
+	// The connection goes through the configured proxy, as for HTTP commands.
+	positional, opts := parseArgs(args)
+	if len(positional) < 2 {
+		return nil, ErrMissingArgs
+	}
+	host := positional[0]
+	port := positional[1]
+	serverName := host
+	if len(positional) > 2 && positional[2] != "" {
+		serverName = positional[2]
+	}
+
+	timeout, err := opts.getDuration("timeout", defaultDialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	roots, err := certPoolFromOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{
+		ServerName: serverName,
+		// Verification is done below so that the chain can be reported even
+		// when it is not trusted.
+		InsecureSkipVerify: true,
+	}
+	if clientCert, err := clientCertificateFromOptions(opts); err != nil {
+		return nil, err
+	} else if clientCert != nil {
+		tlsConfig.Certificates = []tls.Certificate{*clientCert}
+	}
+
+	address := net.JoinHostPort(host, port)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	rawConn, err := c.dialTCP(ctx, address, timeout)
+	if err != nil {
+		errMsg := fmt.Sprintf("TLS handshake with %s failed: %v", address, err)
+		return []string{"false"}, ErrCommandResponse{errMsg}
+	}
+	conn := tls.Client(rawConn, tlsConfig)
+	defer conn.Close()
+	if err := conn.HandshakeContext(ctx); err != nil {
+		errMsg := fmt.Sprintf("TLS handshake with %s failed: %v", address, err)
+		return []string{"false"}, ErrCommandResponse{errMsg}
+	}
+
+	state := conn.ConnectionState()
+	result := tlsCheckResult{
+		Address:     address,
+		ServerName:  serverName,
+		Version:     tls.VersionName(state.Version),
+		CipherSuite: tls.CipherSuiteName(state.CipherSuite),
+		Protocol:    state.NegotiatedProtocol,
+		Chain:       []certInfo{},
+	}
+	for _, cert := range state.PeerCertificates {
+		result.Chain = append(result.Chain, describeCertificate(cert))
+	}
+	if len(state.PeerCertificates) == 0 {
+		return []string{"false"}, ErrCommandResponse{"Server presented no certificates"}
+	}
+
+	leaf := state.PeerCertificates[0]
+	if err := leaf.VerifyHostname(serverName); err != nil {
+		result.HostnameError = err.Error()
+	} else {
+		result.HostnameValid = true
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range state.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+	verifyOpts := x509.VerifyOptions{
+		DNSName:       serverName,
+		Roots:         roots,
+		Intermediates: intermediates,
+	}
+	if _, err := leaf.Verify(verifyOpts); err != nil {
+		result.VerifyError = err.Error()
+	} else {
+		result.Verified = true
+	}
+
+	results, err := jsonResult(result)
+	if err != nil {
+		return nil, err
+	}
+	if !result.Verified {
+		errMsg := fmt.Sprintf("TLS verification failed: %s", result.VerifyError)
+		return results, ErrCommandResponse{errMsg}
+	}
+	return results, nil
+}
+
+// clientCertificateFromOptions loads the client_cert and client_key options,
+// returning nil if neither is set.
+func clientCertificateFromOptions(opts cmdOptions) (*tls.Certificate, error) {
+	certInput := opts.get("client_cert", "")
+	keyInput := opts.get("client_key", "")
+	if certInput == "" && keyInput == "" {
+		return nil, nil
+	}
+	if certInput == "" || keyInput == "" {
+		return nil, errors.New("Both client_cert and client_key are required")
+	}
+	certPEM, err := decodePEMInput(certInput)
+	if err != nil {
+		return nil, err
+	}
+	keyPEM, err := decodePEMInput(keyInput)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, err
+	}
+	return &cert, nil
+}
+
+// tlsConfigFromOptions returns a client config for host from the
+// server_name, ca and insecure options.
+func tlsConfigFromOptions(host string, opts cmdOptions) (*tls.Config, error) {
+	insecure, err := opts.getBool("insecure", false)
+	if err != nil {
+		return nil, err
+	}
+	roots, err := certPoolFromOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		ServerName:         opts.get("server_name", host),
+		RootCAs:            roots,
+		InsecureSkipVerify: insecure,
+	}, nil
+}
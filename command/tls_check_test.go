@@ -0,0 +1,191 @@
+package command
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+)
+
+// newTestCmd returns a go command to pass to command functions in tests.
+func newTestCmd(t *testing.T) *GoCmd {
+	t.Helper()
+	c, err := NewGoCmd("echo", CmdConfig{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		c.Cancel()
+		c.closeTransports()
+	})
+	return c
+}
+
+// testPKI is a CA created with ca_create and used to issue test certificates.
+type testPKI struct {
+	caPEM    string
+	caKeyPEM string
+	ca       *x509.Certificate
+	pool     *x509.CertPool
+}
+
+func newTestPKI(t *testing.T) *testPKI {
+	t.Helper()
+	caPEM, caKeyPEM, ca := createTestCA(t, "key=ecdsa-p256")
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+	return &testPKI{caPEM: caPEM, caKeyPEM: caKeyPEM, ca: ca, pool: pool}
+}
+
+// issue returns a certificate for 127.0.0.1 and the given DNS names, and its
+// key, both in PEM.
+func (p *testPKI) issue(t *testing.T, dnsNames ...string) (string, string) {
+	t.Helper()
+	results, err := certIssueCommand(nil, p.caPEM, p.caKeyPEM, "--", "key=ecdsa-p256", "format=pem",
+		"cn=test", "ip=127.0.0.1", "dns="+strings.Join(dnsNames, ","), "ext_usage=server_auth,client_auth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return results[2], results[0]
+}
+
+func (p *testPKI) tlsCertificate(t *testing.T, dnsNames ...string) tls.Certificate {
+	t.Helper()
+	certPEM, keyPEM := p.issue(t, dnsNames...)
+	cert, err := tls.X509KeyPair([]byte(certPEM), []byte(keyPEM))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+// serveTLS accepts TLS connections on a local listener and completes the
+// handshake on each.
+func serveTLS(t *testing.T, config *tls.Config) (string, string) {
+	t.Helper()
+	l, err := tls.Listen("tcp", "127.0.0.1:0", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				conn.(*tls.Conn).Handshake()
+				conn.Read(make([]byte, 1))
+			}()
+		}
+	}()
+	host, port, _ := net.SplitHostPort(l.Addr().String())
+	return host, port
+}
+
+func runTLSCheck(t *testing.T, args ...string) (tlsCheckResult, error) {
+	t.Helper()
+	results, err := tlsCheckCommand(newTestCmd(t), args...)
+	var result tlsCheckResult
+	if len(results) > 0 && results[0] != "false" {
+		if err := json.Unmarshal([]byte(results[0]), &result); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return result, err
+}
+
+func TestTLSCheck(t *testing.T) {
+	pki := newTestPKI(t)
+	host, port := serveTLS(t, &tls.Config{
+		Certificates: []tls.Certificate{pki.tlsCertificate(t, "svc.example.com")},
+		NextProtos:   []string{"h2"},
+	})
+
+	result, err := runTLSCheck(t, host, port, "svc.example.com", "--", "ca="+pki.caPEM)
+	if err != nil {
+		t.Fatalf("tls_check: %v", err)
+	}
+	if !result.Verified || !result.HostnameValid {
+		t.Errorf("verified = %v, hostname valid = %v", result.Verified, result.HostnameValid)
+	}
+	if result.Version != "TLS 1.3" || result.CipherSuite == "" {
+		t.Errorf("version = %q, cipher suite = %q", result.Version, result.CipherSuite)
+	}
+	if len(result.Chain) != 2 || result.Chain[1].Subject != pki.ca.Subject.String() {
+		t.Errorf("chain = %+v", result.Chain)
+	}
+
+	// The server name defaults to the host, which the certificate covers.
+	if _, err := runTLSCheck(t, host, port, "--", "ca="+pki.caPEM); err != nil {
+		t.Errorf("tls_check without server name: %v", err)
+	}
+
+	result, err = runTLSCheck(t, host, port, "other.example.com", "--", "ca="+pki.caPEM)
+	if _, ok := err.(ErrCommandResponse); !ok {
+		t.Fatalf("tls_check with the wrong name: got %v, want ErrCommandResponse", err)
+	}
+	if result.HostnameValid || result.Verified || len(result.Chain) != 2 {
+		t.Errorf("wrong name: hostname valid = %v, verified = %v", result.HostnameValid, result.Verified)
+	}
+
+	// The chain is still reported when it is not trusted.
+	result, err = runTLSCheck(t, host, port, "svc.example.com", "--", "ca="+newTestPKI(t).caPEM)
+	if _, ok := err.(ErrCommandResponse); !ok {
+		t.Fatalf("tls_check with an untrusted CA: got %v, want ErrCommandResponse", err)
+	}
+	if !result.HostnameValid || result.Verified || result.VerifyError == "" || len(result.Chain) != 2 {
+		t.Errorf("untrusted CA: %+v", result)
+	}
+}
+
+func TestTLSCheckClientCertificate(t *testing.T) {
+	pki := newTestPKI(t)
+	host, port := serveTLS(t, &tls.Config{
+		Certificates: []tls.Certificate{pki.tlsCertificate(t)},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pki.pool,
+		// Client certificates are checked after the TLS 1.3 handshake, so
+		// use TLS 1.2 for the client to see the failure.
+		MaxVersion: tls.VersionTLS12,
+	})
+
+	if _, err := runTLSCheck(t, host, port, "--", "ca="+pki.caPEM); err == nil {
+		t.Error("handshake succeeded without a client certificate")
+	}
+
+	certPEM, keyPEM := pki.issue(t)
+	result, err := runTLSCheck(t, host, port, "--", "ca="+pki.caPEM, "client_cert="+certPEM, "client_key="+keyPEM)
+	if err != nil {
+		t.Fatalf("tls_check with a client certificate: %v", err)
+	}
+	if result.Version != "TLS 1.2" {
+		t.Errorf("version = %q", result.Version)
+	}
+
+	if _, err := runTLSCheck(t, host, port, "--", "client_cert="+certPEM); err == nil || err == ErrMissingArgs {
+		t.Errorf("client_cert without client_key: %v", err)
+	}
+}
+
+func TestTLSCheckErrors(t *testing.T) {
+	if _, err := runTLSCheck(t, "127.0.0.1"); err != ErrMissingArgs {
+		t.Errorf("got %v, want ErrMissingArgs", err)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	host, port, _ := net.SplitHostPort(l.Addr().String())
+	l.Close()
+	if _, err := runTLSCheck(t, host, port, "--", "timeout=1s"); err == nil {
+		t.Error("tls_check succeeded against a closed port")
+	} else if _, ok := err.(ErrCommandResponse); !ok {
+		t.Errorf("got %v, want ErrCommandResponse", err)
+	}
+}
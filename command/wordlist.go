@@ -0,0 +1,116 @@
+package command
+
+import "strings"
+
+// passphraseWords is the built in word list for passphrases. Every word is
+// short, common and unique so that passphrases are easy to type.
+var passphraseWords = strings.Fields(`
+able acid acorn acre act actor adapt add admit adopt adult aft
+after again age agent agile aging agree ahead aid aim air aisle
+alarm album alert alias alibi alien align alike alive alley allow alloy
+almond aloe alpha also alter amber amend amid amino ample amuse angel
+anger angle angry ankle annex answer ant anvil apple apply apron aqua
+arbor arch arena argue arise arm armor army aroma arrow art ash
+aside ask aspen asset atlas atom attic audio audit aunt auto avid
+avoid awake award axis bacon badge bagel baker balm bamboo banjo bank
+barn baron basil basin batch bath baton bay beach beam bean bear
+beard beast bed beef beet bell belt bench berry bike bird birch
+bison blade blank blast blaze blend bless blimp blink bliss block bloom
+blue blunt blush board boat body bolt bonus book boost boot border
+boss bound bowl box brain brake brand brass brave bread break brick
+bride brief brim brisk broad brook broom brown brush bucket buddy budget
+buggy build bulb bulk bunch bunny burst bush butter button buzz cabin
+cable cactus cadet cage cake calm camel camp canal candy canoe canvas
+canyon cape card cargo carol carpet carrot cart carve case cash cast
+castle cat catch cause cave cedar cell cello chain chair chalk champ
+chant chaos charm chart chase cheek cheer chef cherry chess chest chick
+chief child chili chin chip choir chop chord chunk cider cigar cinema
+circle city civic claim clam clap clash clasp class claw clay clean
+clerk click cliff climb cling clip cloak clock close cloth cloud clown
+club clue coach coast coat cobra cocoa code coil coin cola comet
+comic coral cord core corn couch cough count court cover cow cozy
+crab craft crane crate crawl crayon crazy cream creek crew crib crisp
+crop cross crowd crown crumb crush crust cub cube cuff cup curb
+curl curve cycle daily dairy daisy dance dart dash data dawn deal
+debut decal decoy deer delta demo denim dense depot depth derby desk
+dial diary dice diet digit diner dingo disco dish ditch diver dizzy
+dock dodge dog doll dolphin dome donor donut door dose dove down
+dozen draft dragon drain drama drape draw dream dress drift drill drink
+drive drum duck duet dune dust duty dwarf eager eagle early earth
+easel east echo edge eel effort egg eight elbow elder elk elm
+email ember emblem empty enact end enjoy enter entry envoy epic equal
+era erase errand essay ether even event evict exact exam exile exit
+expo extra fable face fact fade fair fairy faith false fame fancy
+fang farm fast fault fawn feast feat fence fern ferry fetch fever
+fiber field fig film final finch find fire firm fish fist five
+flag flake flame flash flask fleet flint float flock flood floor flour
+flute foam focus fog foil folk font food fork form fort forum
+fossil found fox frame fresh frog front frost fruit fudge fuel fun
+fund fury fuse gala gamer gap garden garlic gate gauge gecko gem
+genre giant gift ginger give glad glass glide globe glove glow glue
+goal goat gold golf good goose gorge gown grab grace grade grain
+grand grant grape graph grass gravy great green grid grill grin grip
+groom group grove grow guard guess guest guide guild guitar gull gum
+guru habit hail hair half hall halo hammer hand happy harbor hardy
+harp hatch haven hawk hazel head heap heart heat hedge helmet help
+hen herb hero heron hill hinge hippo hobby hockey holly home honey
+hood hook hope horn horse host hotel hound hour house human humor
+hunt hurry husky hut ice icon idea igloo image inch index ink
+inlet input iris iron island item ivory ivy jacket jade jaguar jam
+jar jazz jeans jelly jewel job jog joke jolly journal joy judge
+juice jumbo jump jungle junior jury kayak keen kettle key kick kid
+kind king kite kitten kiwi knee knife knob knot koala label lace
+ladder lady lake lamb lamp lance land lane laser latch lava lawn
+layer leaf league lemon lens level lever lid life lift light lilac
+lily limb lime line linen lion lip list liver lizard llama load
+loaf lobby local lock lodge logic loop lotus loud love lucky lunar
+lunch lyric macro magic magnet maid mail major mango manor map maple
+marble march mask mason match mayor maze meadow meal medal melon memo
+menu merit mesa metal meter midst mild mile milk mill mimic mind
+mint minus mirror mist mix moat model modem mole money monk month
+moon moose moral motor motto mound mount mouse mouth movie mud mug
+mule mural muse music mustard myth nail name nap navy near neck
+nectar needle neon nerve nest net nickel night ninja noble node noise
+noon north nose notch note novel nurse nut oak oasis oat ocean
+octave odor offer office olive omega onion open opera orbit orchid order
+organ otter ounce outer oval oven owl owner oxide oyster pace pack
+paddle page paint palm panda panel panic pants paper parade park parrot
+party pasta patch path patio pause peach peak pear pearl pecan pedal
+pelican pen pencil penny pepper perch piano pick pie pier pig pilot
+pine pink pipe pitch pixel pizza place plain plan plane plant plate
+plaza plot plum plush poem poet point polar pole polka pond pony
+pool poppy porch port pose pouch pound power press price pride prime
+print prism prize probe prose proud prune pulse puma pump punch pupil
+puppy purse puzzle quail quake query quest quick quiet quilt quiz quota
+rabbit race radar radio raft rail rain rally ramp ranch range rapid
+raven razor reach ready realm rebel recipe reef relay relic remedy rent
+reply rhino rhyme ribbon rice rider ridge rifle ring rinse ripple river
+road robin robot rock rocket rodeo roof room root rope rose rover
+royal ruby rug ruler rumor rune rural rust saddle safe saga sail
+salad salmon salon salt sand satin sauce sauna scale scarf scene scent
+school scoop scout scrap screen scroll scuba sea seal season seat seed
+shade shadow shake shape share shark sheep shelf shell shield shift shine
+ship shirt shock shoe shore short shovel shrub sigma sign silk silver
+siren sister sketch ski skill skirt sky slate sled sleep slice slide
+slope sloth smile smoke snack snail snake snow soap soccer sock sofa
+soil solar solid song sonic sound soup south space spade spark spear
+speed spell spice spider spike spine spoon sport spot spray spring sprout
+spur squad squid stable stage stair stamp stand star start state steam
+steel stem step stew stick still sting stock stone stool storm story
+stove straw stream street stripe study sugar suit summit sun super surf
+swan sweet swift swing sword syrup table tablet taco tail talent tango
+tank tape target task taxi tea teal team teeth tempo tennis tent
+term test text theme thorn thumb thunder ticket tide tiger tile timber
+time tint toast today token tomato tone tool topaz torch total totem
+tour towel tower town toy track trade trail train tray treat tree
+trend trial tribe trick trip trophy truck trunk trust truth tuba tulip
+tuna tunnel turkey turtle tutor twig twin type ultra umbra uncle union
+unit upper urban usage usher valid valley value valve vapor vault velvet
+vendor venue verb verse vessel video view villa vine vinyl violin virus
+visa visit visor vital vivid vocal voice volt vote voyage wafer wagon
+waist walk wall walnut wand water wave wax wealth weave web wedge
+whale wheat wheel whip whisk width wild willow wind window wing winter
+wire wise wish wizard wolf wood wool word world worm wrap wren
+wrist yacht yard yarn year yeast yellow yield yoga yogurt young zebra
+zero zesty zinc zipper zone zoom
+`)
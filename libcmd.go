@@ -1,7 +1,13 @@
 package libcmd
 
 import (
+	"context"
+	"os"
+	"os/signal"
 	"reflect"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/replicatedcom/libcmd/command"
 
@@ -11,6 +17,8 @@ import (
 
 var (
 	globalDockerClient *docker.Client
+	globalRunner       *command.Runner
+	runnerMu           sync.Mutex
 	config             command.CmdConfig
 
 	cmdConfigDefaultOpts = map[string]string{
@@ -18,10 +26,28 @@ var (
 		"DockerEndpoint":      "unix:///var/run/docker.sock",
 		"ContainerRepository": "freighterio/cmd",
 		"ContainerTag":        "latest",
+		"BuildContextDir":     "",
+		"ImageArchive":        "",
+		"PublicIPProviders":   "",
+		"ProxyURL":            "",
+		"ProxyUsername":       "",
+		"ProxyPassword":       "",
+		"NoProxy":             "",
 	}
 )
 
+// InitCmdContainer calls Init and exits the process if it fails.
+//
+// Deprecated: use Init, which returns the error instead.
 func InitCmdContainer(opts map[string]string) {
+	if err := Init(opts); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Init configures the library and makes the command image available. Images
+// without a manifest get the built-in container commands.
+func Init(opts map[string]string) error {
 	config = command.CmdConfig{}
 	for key, dflt := range cmdConfigDefaultOpts {
 		field := reflect.ValueOf(&config).Elem().FieldByName(key)
@@ -34,26 +60,92 @@ func InitCmdContainer(opts map[string]string) {
 
 	client, err := docker.NewClient(config.DockerEndpoint)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 	globalDockerClient = client
-	if err := command.PullImage(globalDockerClient, config.ContainerRepository, config.ContainerTag); err != nil {
-		log.Fatal(err)
+	if err := initImage(); err != nil {
+		return err
 	}
+
+	manifest, err := command.ReadManifest(globalDockerClient, config)
+	if err == command.ErrManifestNotFound {
+		log.Warnf("Command image %s:%s has no manifest, using the built-in container commands", config.ContainerRepository, config.ContainerTag)
+		manifest = command.DefaultManifest()
+	} else if err != nil {
+		return err
+	}
+	if err := manifest.Validate(); err != nil {
+		return err
+	}
+	command.RegisterContainerCommands(manifest)
+
+	runnerMu.Lock()
+	globalRunner = command.NewRunner(config, globalDockerClient)
+	runnerMu.Unlock()
+	return nil
 }
 
-func RunCommand(op string, args ...string) ([]string, error) {
-	goCmd, err := command.NewGoCmd(op, config, globalDockerClient)
-	if err == nil {
-		return goCmd.Run(args...)
+// runner returns the runner set up by Init. Go commands don't
+// need the command image, so a runner without one is created for them when
+// the library is used without init.
+func runner() *command.Runner {
+	runnerMu.Lock()
+	defer runnerMu.Unlock()
+	if globalRunner == nil {
+		globalRunner = command.NewRunner(config, globalDockerClient)
 	}
-	if err != command.ErrCommandNotFound {
-		return nil, err
+	return globalRunner
+}
+
+// initImage makes the command image available, building it from
+// BuildContextDir or loading it from ImageArchive when either is set and
+// pulling it from the registry otherwise.
+func initImage() error {
+	switch {
+	case config.BuildContextDir != "":
+		tag, err := command.BuildImage(globalDockerClient, config.BuildContextDir, config.ContainerRepository)
+		if err != nil {
+			return err
+		}
+		config.ContainerTag = tag
+		return nil
+	case config.ImageArchive != "":
+		return command.LoadImage(globalDockerClient, config.ImageArchive, config.ContainerRepository, config.ContainerTag)
+	default:
+		return command.PullImage(globalDockerClient, config.ContainerRepository, config.ContainerTag)
 	}
+}
 
-	containerCmd, err := command.NewContainerCmd(op, config, globalDockerClient)
-	if err == nil {
-		return containerCmd.Run(args...)
+func RunCommand(op string, args ...string) ([]string, error) {
+	return runner().RunCommand(op, args...)
+}
+
+// Shutdown cancels running commands and removes their containers, waiting
+// until they finish or ctx expires.
+func Shutdown(ctx context.Context) error {
+	return runner().Shutdown(ctx)
+}
+
+// ShutdownOnSignal calls Shutdown when one of sigs is received and then exits
+// the process. It is intended for command line tools.
+func ShutdownOnSignal(timeout time.Duration, sigs ...os.Signal) {
+	if len(sigs) == 0 {
+		sigs = []os.Signal{os.Interrupt, syscall.SIGTERM}
 	}
-	return nil, err
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, sigs...)
+	go func() {
+		sig := <-ch
+		log.Infof("Received %v, shutting down", sig)
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		if err := Shutdown(ctx); err != nil {
+			log.Error(err)
+		}
+		cancel()
+		code := 1
+		if s, ok := sig.(syscall.Signal); ok {
+			code = 128 + int(s)
+		}
+		os.Exit(code)
+	}()
 }
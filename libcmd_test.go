@@ -0,0 +1,32 @@
+package libcmd
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/replicatedcom/libcmd/command"
+)
+
+func TestRunCommandWithoutInit(t *testing.T) {
+	results, err := RunCommand("echo", "hello", "world")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(results, []string{"hello world"}) {
+		t.Errorf("results = %q", results)
+	}
+	if _, err := RunCommand("no_such_command"); err != command.ErrCommandNotFound {
+		t.Errorf("got %v, want ErrCommandNotFound", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+	if _, err := RunCommand("echo", "x"); err != command.ErrShuttingDown {
+		t.Errorf("RunCommand after shutdown: got %v, want ErrShuttingDown", err)
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"flag"
+	"time"
 
 	"github.com/replicatedcom/libcmd"
 	"github.com/replicatedcom/libcmd/command"
@@ -10,11 +11,19 @@ import (
 )
 
 var (
-	op string
+	op           string
+	buildContext string
+	imageArchive string
+	proxyURL     string
+	noProxy      string
 )
 
 func init() {
 	flag.StringVar(&op, "cmd", "random", "command to run")
+	flag.StringVar(&buildContext, "build-context", "", "build the command image from this directory instead of pulling it")
+	flag.StringVar(&imageArchive, "image-archive", "", "load the command image from this docker save tarball instead of pulling it")
+	flag.StringVar(&proxyURL, "proxy", "", "send HTTP requests made by commands through this proxy")
+	flag.StringVar(&noProxy, "no-proxy", "", "comma separated hosts that are never proxied")
 	flag.Parse()
 }
 
@@ -24,8 +33,15 @@ func main() {
 	opts := map[string]string{
 		"ContainerRepository": "freighter/cmd",
 		"ContainerTag":        "latest",
+		"BuildContextDir":     buildContext,
+		"ImageArchive":        imageArchive,
+		"ProxyURL":            proxyURL,
+		"NoProxy":             noProxy,
 	}
-	libcmd.InitCmdContainer(opts)
+	if err := libcmd.Init(opts); err != nil {
+		log.Fatal(err)
+	}
+	libcmd.ShutdownOnSignal(time.Second * 10)
 
 	log.Infof("Running command \"%s\"", op)
 